@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/chhoumann/uca/internal/agents"
+)
+
+// advisoryCounts tallies npm advisories by severity (info, low, moderate, high, critical).
+type advisoryCounts map[string]int
+
+// seriousSeverities are the severities --audit reports, most severe first.
+var seriousSeverities = []string{"critical", "high"}
+
+// serious renders the high and critical counts, e.g. "1 critical, 2 high"; "" when there are none.
+func (c advisoryCounts) serious() string {
+	parts := []string{}
+	for _, severity := range seriousSeverities {
+		if n := c[severity]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, severity))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// npmAuditSummaryPattern matches npm's install summary, e.g. "3 vulnerabilities (1 moderate, 2 high)".
+var npmAuditSummaryPattern = regexp.MustCompile(`(\d+)\s+vulnerabilit(?:y|ies)\s*\(([^)]*)\)`)
+
+// parseAuditSummary reads the advisory summary npm prints after an install. ok is false when the output
+// has none (npm skips the audit for most global installs).
+func parseAuditSummary(out string) (advisoryCounts, bool) {
+	match := npmAuditSummaryPattern.FindStringSubmatch(out)
+	if match == nil {
+		if strings.Contains(out, "found 0 vulnerabilities") {
+			return advisoryCounts{}, true
+		}
+		return nil, false
+	}
+	counts := advisoryCounts{}
+	for _, part := range strings.Split(match[2], ",") {
+		fields := strings.Fields(part)
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.Atoi(fields[0]); err == nil {
+			counts[strings.ToLower(fields[1])] = n
+		}
+	}
+	return counts, true
+}
+
+// auditNodeAgents is --audit: for each npm agent that updated (or was already current) it reads the
+// advisory summary npm prints after the agent's own install and records high/critical counts on the
+// result. Global packages have no lockfile for `npm audit` to read, so when the install output has no
+// summary the agent is only noted as unaudited.
+func auditNodeAgents(results []result) {
+	for i := range results {
+		res := &results[i]
+		if res.Method != agents.KindNpm || (res.Status != statusUpdated && res.Status != statusUnchanged) {
+			continue
+		}
+		pkg := nodePackageName(res.Agent.Strategies)
+		if pkg == "" {
+			continue
+		}
+		// A batch's output covers every package in it, so it can't be attributed to one agent.
+		if res.Batched {
+			res.Explain = appendNote(res.Explain, "npm advisories unavailable: the batch's audit summary covers all of its packages")
+			continue
+		}
+		counts, ok := parseAuditSummary(res.Log)
+		if !ok {
+			res.Explain = appendNote(res.Explain, "npm advisories unavailable: npm printed no audit summary for this install")
+			continue
+		}
+		if serious := counts.serious(); serious != "" {
+			res.Advisories = serious
+			res.Explain = appendHint(res.Explain, fmt.Sprintf("npm audit: %s advisories in %s", serious, pkg))
+		}
+	}
+}
+
+// formatAdvisories is the --audit summary line, e.g. "advisories: gemini (2 high)".
+func formatAdvisories(results []result) string {
+	items := []string{}
+	for _, res := range results {
+		if res.Advisories != "" {
+			items = append(items, fmt.Sprintf("%s (%s)", res.Agent.Name, res.Advisories))
+		}
+	}
+	var b strings.Builder
+	writeSummaryLine(&b, "advisories", items)
+	return b.String()
+}
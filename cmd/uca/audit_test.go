@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chhoumann/uca/internal/agents"
+)
+
+func TestParseAuditSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		want   string
+		wantOK bool
+	}{
+		{"mixed", "changed 3 packages in 4s\n\n3 vulnerabilities (1 moderate, 2 high)\n", "2 high", true},
+		{"critical first", "5 vulnerabilities (2 low, 1 high, 2 critical)", "2 critical, 1 high", true},
+		{"single", "1 vulnerability (1 low)", "", true},
+		{"clean", "found 0 vulnerabilities", "", true},
+		{"no audit", "changed 1 package in 2s", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts, ok := parseAuditSummary(tt.out)
+			if ok != tt.wantOK || counts.serious() != tt.want {
+				t.Fatalf("parseAuditSummary() = %q, %v; want %q, %v", counts.serious(), ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAuditNodeAgents(t *testing.T) {
+	gemini := agents.Agent{Name: "gemini", Strategies: []agents.UpdateStrategy{{Kind: agents.KindNpm, Package: "@google/gemini-cli"}}}
+	codex := agents.Agent{Name: "codex", Strategies: []agents.UpdateStrategy{{Kind: agents.KindNpm, Package: "@openai/codex"}}}
+	pi := agents.Agent{Name: "pi", Strategies: []agents.UpdateStrategy{{Kind: agents.KindNpm, Package: "pi-agent"}}}
+	amp := agents.Agent{Name: "amp", Strategies: []agents.UpdateStrategy{{Kind: agents.KindNative}}}
+	results := []result{
+		{Agent: gemini, Status: statusUpdated, Method: agents.KindNpm, Log: "3 vulnerabilities (1 moderate, 2 high)"},
+		{Agent: codex, Status: statusUnchanged, Method: agents.KindNpm, Batched: true, Log: "9 vulnerabilities (9 critical)"},
+		{Agent: pi, Status: statusUpdated, Method: agents.KindNpm, Log: "changed 1 package in 2s"},
+		{Agent: amp, Status: statusUpdated, Method: agents.KindNative},
+	}
+	auditNodeAgents(results)
+
+	if results[0].Advisories != "2 high" || results[1].Advisories != "" || results[2].Advisories != "" {
+		t.Fatalf("advisories = %q, %q, %q", results[0].Advisories, results[1].Advisories, results[2].Advisories)
+	}
+	if !strings.Contains(results[1].Explain, "batch") {
+		t.Fatalf("codex explain = %q", results[1].Explain)
+	}
+	if !strings.Contains(results[2].Explain, "no audit summary") {
+		t.Fatalf("pi explain = %q", results[2].Explain)
+	}
+	if results[3].Explain != "" {
+		t.Fatalf("amp explain = %q", results[3].Explain)
+	}
+	if got := formatAdvisories(results); got != "advisories: gemini (2 high)\n" {
+		t.Fatalf("formatAdvisories() = %q", got)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chhoumann/uca/internal/agents"
+)
+
+// installCopy is another installation of an agent, through a different manager than the one uca
+// resolved (e.g. copilot from both brew and npm).
+type installCopy struct {
+	kind string
+	pkg  string
+	cmd  []string
+}
+
+// otherInstalls finds the agent's package strategies, other than method, whose manager also has the
+// package installed. Only package managers are checked: a native updater updates whichever copy it is.
+func (e *envState) otherInstalls(agent agents.Agent, method string) []installCopy {
+	if packageUpdateCommand(agents.UpdateStrategy{Kind: method}) == nil {
+		return nil
+	}
+	seen := map[string]bool{method: true}
+	copies := []installCopy{}
+	for _, strat := range agent.Strategies {
+		cmd := packageUpdateCommand(strat)
+		if cmd == nil || seen[strat.Kind] || strat.Package == "" {
+			continue
+		}
+		seen[strat.Kind] = true
+		if e.hasPackageInstalled(strat) {
+			copies = append(copies, installCopy{kind: strat.Kind, pkg: strat.Package, cmd: cmd})
+		}
+	}
+	return copies
+}
+
+func (e *envState) hasPackageInstalled(strat agents.UpdateStrategy) bool {
+	switch strat.Kind {
+	case agents.KindBrew:
+		return e.hasBrew && e.brewHas(strat.Package)
+	case agents.KindPip:
+		return e.hasPython && e.pipHas(strat.Package)
+	case agents.KindUv:
+		return e.hasUv && e.uvHas(strat.Package)
+	case agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun:
+		return e.hasNodeManager(strat.Kind) && e.nodeManagerHasPackage(strat.Kind, strat.Package)
+	}
+	return false
+}
+
+// otherInstallsNote describes the extra copies for --explain.
+func otherInstallsNote(copies []installCopy, updateAll bool) string {
+	items := make([]string, 0, len(copies))
+	for _, c := range copies {
+		items = append(items, fmt.Sprintf("%s (%s)", c.kind, c.pkg))
+	}
+	note := "also installed via " + strings.Join(items, ", ")
+	if updateAll {
+		return note + "; updating those copies too"
+	}
+	return note + "; only the copy first on PATH runs and the others go stale, so remove the extras (or rerun with --update-all-copies)"
+}
+
+// updateOtherCopies records each agent's extra installs on its result and, with --update-all-copies,
+// updates them after every task has finished, so no manager lock is held by another task. A copy's
+// outcome goes to the log and --explain; the agent's status stays that of its main install.
+func updateOtherCopies(ctx context.Context, env *envState, works []agentWork, results []result, opts options) {
+	for _, work := range works {
+		if len(work.copies) == 0 {
+			continue
+		}
+		res := &results[work.index]
+		for _, c := range work.copies {
+			res.OtherInstalls = append(res.OtherInstalls, c.kind)
+		}
+		if !opts.UpdateAllCopies || opts.DryRun || res.Status == statusSkipped {
+			continue
+		}
+		for _, c := range work.copies {
+			if ctx.Err() != nil {
+				return
+			}
+			out, classifyOut, exitCode, duration, _ := runUpdateCmd(ctx, env.commands(), c.cmd, work.timeout, false, nil)
+			res.Duration += duration
+			res.Log = strings.TrimLeft(strings.TrimRight(res.Log, "\n")+fmt.Sprintf("\n\n(uca) updating the %s copy: %s\n", c.kind, cmdString(c.cmd))+strings.TrimSpace(out), "\n")
+			if exitCode != 0 {
+				_, hint := classifyUpdateFailure(c.cmd, classifyOut)
+				res.Explain = appendHint(res.Explain, appendNote(fmt.Sprintf("updating the %s copy with `%s` failed (exit %d)", c.kind, cmdString(c.cmd), exitCode), hint))
+				continue
+			}
+			if isNodeKind(c.kind) {
+				env.refreshNodePackages(c.kind, []string{work.agent.Binary})
+			}
+			res.Explain = appendNote(res.Explain, fmt.Sprintf("updated the %s copy with `%s`", c.kind, cmdString(c.cmd)))
+		}
+	}
+}
+
+// formatOtherInstalls is the summary line naming agents installed through more than one manager.
+func formatOtherInstalls(results []result) string {
+	items := []string{}
+	for _, res := range results {
+		if len(res.OtherInstalls) == 0 {
+			continue
+		}
+		items = append(items, fmt.Sprintf("%s (%s)", res.Agent.Name, strings.Join(append([]string{res.Method}, res.OtherInstalls...), ", ")))
+	}
+	var b strings.Builder
+	writeSummaryLine(&b, "multiple installs", items)
+	return b.String()
+}
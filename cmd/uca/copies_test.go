@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/chhoumann/uca/internal/agents"
+)
+
+func TestOtherInstalls(t *testing.T) {
+	copilot := agents.Agent{Name: "copilot", Binary: "copilot", Strategies: []agents.UpdateStrategy{
+		{Kind: agents.KindBrew, Package: "copilot-cli"},
+		{Kind: agents.KindNpm, Package: "@github/copilot"},
+		{Kind: agents.KindPnpm, Package: "@github/copilot"},
+	}}
+	npmUpdate := "npm install -g @github/copilot@latest"
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		"brew list --formula --versions copilot-cli": {{out: "copilot-cli 0.0.330\n"}},
+		npmUpdate: {{out: "changed 1 package in 3s"}},
+	}}
+	env := &envState{hasBrew: true, hasNpm: true, runner: runner, npmPkgs: map[string]string{"@github/copilot": "0.0.320"}}
+	env.npmPkgOnce.Do(func() {})
+
+	copies := env.otherInstalls(copilot, agents.KindBrew)
+	if len(copies) != 1 || copies[0].kind != agents.KindNpm || cmdString(copies[0].cmd) != npmUpdate {
+		t.Fatalf("otherInstalls() = %+v, want the npm copy", copies)
+	}
+	if got := env.otherInstalls(copilot, agents.KindNative); got != nil {
+		t.Fatalf("otherInstalls(native) = %+v, want none", got)
+	}
+
+	works := []agentWork{{agent: copilot, index: 0, method: agents.KindBrew, copies: copies}}
+	results := []result{{Agent: copilot, Status: statusUpdated, Method: agents.KindBrew, Log: "==> Upgrading copilot-cli"}}
+	updateOtherCopies(context.Background(), env, works, results, options{})
+	if strings.Join(results[0].OtherInstalls, ",") != agents.KindNpm || strings.Contains(strings.Join(runner.calls, "\n"), npmUpdate) {
+		t.Fatalf("without --update-all-copies: result %+v, calls %v", results[0], runner.calls)
+	}
+	if got, want := formatOtherInstalls(results), "multiple installs: copilot (brew, npm)\n"; got != want {
+		t.Fatalf("formatOtherInstalls() = %q, want %q", got, want)
+	}
+
+	results = []result{{Agent: copilot, Status: statusUpdated, Method: agents.KindBrew, Log: "==> Upgrading copilot-cli"}}
+	updateOtherCopies(context.Background(), env, works, results, options{UpdateAllCopies: true})
+	if !strings.Contains(results[0].Log, "(uca) updating the npm copy: "+npmUpdate+"\nchanged 1 package") ||
+		!strings.Contains(results[0].Explain, "updated the npm copy") || results[0].Status != statusUpdated {
+		t.Fatalf("with --update-all-copies: result %+v", results[0])
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// writeOutputDump is --dump-output: one DIR/<agent>.log per agent that produced output, with the exact
+// combined output of its update command under a header of what ran and how it ended. Unlike printLogs,
+// nothing is grouped or deduplicated, so a file can be attached to an upstream bug report as is.
+func writeOutputDump(dir string, results []result) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("write --dump-output: %w", err)
+	}
+	for _, res := range results {
+		if strings.TrimSpace(res.Log) == "" {
+			continue
+		}
+		path := filepath.Join(dir, dumpFileName(res.Agent.Name))
+		if err := os.WriteFile(path, []byte(formatOutputDump(res)), 0o644); err != nil {
+			return fmt.Errorf("write --dump-output: %w", err)
+		}
+	}
+	return nil
+}
+
+// dumpFileName is the agent's log file name; config agent names may contain path separators.
+func dumpFileName(name string) string {
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		name = "agent"
+	}
+	return name + ".log"
+}
+
+func formatOutputDump(res result) string {
+	var b strings.Builder
+	status := res.Status
+	if reason := res.Reason(); reason != "" {
+		status += " (" + reason + ")"
+	}
+	fmt.Fprintf(&b, "agent: %s\n", res.Agent.Name)
+	fmt.Fprintf(&b, "status: %s\n", status)
+	if res.ExitCode != 0 {
+		fmt.Fprintf(&b, "exit code: %d\n", res.ExitCode)
+	}
+	fmt.Fprintf(&b, "version: %s -> %s\n", safeVersion(res.Before), safeVersion(res.After))
+	if res.Method != "" {
+		fmt.Fprintf(&b, "method: %s\n", res.Method)
+	}
+	if res.UpdateCmd != "" {
+		command := res.UpdateCmd
+		if res.Batched {
+			command += " (batched)"
+		}
+		fmt.Fprintf(&b, "command: %s\n", command)
+	}
+	fmt.Fprintf(&b, "duration: %s\n", res.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&b, "uca: %s\n\n", version)
+	b.WriteString(res.Log)
+	if !strings.HasSuffix(res.Log, "\n") {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
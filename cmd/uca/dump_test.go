@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chhoumann/uca/internal/agents"
+)
+
+func TestWriteOutputDump(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, Before: "0.1.0", After: "0.2.0", Method: agents.KindNpm, UpdateCmd: "npm install -g @openai/codex@latest opencode-ai@latest", Batched: true, Duration: 8123 * time.Millisecond, Log: "added 1 package in 8s"},
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusFailed, ReasonCode: codeExitStatus, ReasonDetail: "exit 1", ExitCode: 1, Before: "1.0.0", After: "1.0.0", Method: agents.KindNpm, UpdateCmd: "npm install -g @google/gemini-cli@latest", Duration: time.Second, Log: "npm error code E500\n"},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusSkipped, ReasonCode: codeMissing},
+		{Agent: agents.Agent{Name: "team/tool"}, Status: statusUnchanged, Log: "already current\n"},
+	}
+	if err := writeOutputDump(dir, results); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "codex.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "agent: codex\nstatus: updated\nversion: 0.1.0 -> 0.2.0\nmethod: npm\n" +
+		"command: npm install -g @openai/codex@latest opencode-ai@latest (batched)\nduration: 8.123s\n" +
+		"uca: " + version + "\n\nadded 1 package in 8s\n"
+	if string(data) != want {
+		t.Fatalf("codex.log =\n%s\nwant\n%s", data, want)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "gemini.log"))
+	if err != nil || !strings.Contains(string(data), "status: failed (exit 1)\nexit code: 1\n") {
+		t.Fatalf("gemini.log = %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "amp.log")); !os.IsNotExist(err) {
+		t.Fatalf("amp produced no output but got a log file (%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "team_tool.log")); err != nil {
+		t.Fatalf("team/tool log: %v", err)
+	}
+}
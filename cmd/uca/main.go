@@ -4,21 +4,29 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/chhoumann/uca/internal/agents"
@@ -32,7 +40,7 @@ type options struct {
 	Safe     bool
 	Timeout  time.Duration
 	// Concurrency limits how many update commands are allowed to run at once.
-	// 0 means "no limit" (default).
+	// 0 means "no limit" (default); concurrencyAuto sizes it from the CPU count and the tasks (auto).
 	Concurrency int
 	Verbose     bool
 	Quiet       bool
@@ -42,19 +50,150 @@ type options struct {
 	Skip        string
 	Help        bool
 	Version     bool
+	// BeforeAfterOnly prints only changed agents (and failures), without the summary.
+	BeforeAfterOnly bool
+	// Config is an optional JSON file with additional or overriding agent definitions.
+	Config string
+	// Color is "auto", "always", or "never".
+	Color string
+	// Unicode is "auto", "always", or "never".
+	Unicode string
+	// Progress prints periodic status lines to stderr when not attached to a TTY.
+	Progress bool
+	// AgentsFile lists agent names to include, like --only but read from disk.
+	AgentsFile string
+	// BatchSize caps how many packages go into one node batch command. 0 means no limit.
+	BatchSize int
+	// NoBatch sends every node agent through its own update command.
+	NoBatch bool
+	// GuardMajor holds back updates that would cross a major version; AllowMajor overrides it.
+	GuardMajor bool
+	AllowMajor bool
+	// Watch re-runs everything on this interval until interrupted. 0 runs once.
+	Watch time.Duration
+	// OnlyOutdated skips agents whose installed version already matches the latest known version.
+	OnlyOutdated bool
+	// RequireUpdate exits non-zero when an agent's update ran but its version did not move.
+	RequireUpdate bool
+	// GitHub emits GitHub Actions ::error/::warning annotations on stderr for failures and notable skips.
+	GitHub bool
+	// RefreshFirst refreshes local manager indexes (brew update, ...) once before any update runs.
+	RefreshFirst bool
+	// SafeConcurrency is the cap --safe applies when --concurrency is not set. 0 means defaultSafeConcurrency.
+	SafeConcurrency int
+	// MaxNetwork caps concurrent download-heavy tasks (node, brew, pip, uv, ...); native updaters are not
+	// counted. 0 means no cap.
+	MaxNetwork int
+	// ManagerPriority is a comma-separated node manager order used to break detection ties.
+	ManagerPriority string
+	// Prefer is "native" or "package": which kind of strategy resolveUpdate tries first ("" keeps agent order).
+	Prefer string
+	// RefreshInterval is how often the TTY dashboard redraws between events.
+	RefreshInterval time.Duration
+	// InstallMissing installs missing agents named in --only; InstallAllMissing lifts that restriction.
+	InstallMissing    bool
+	InstallAllMissing bool
+	// CleanReinstall uninstalls and reinstalls a single npm global package whose install keeps failing.
+	CleanReinstall bool
+	// UpdateAllCopies also updates an agent's installs through other managers (e.g. brew and npm).
+	UpdateAllCopies bool
+	// LegacyPeerDeps adds --legacy-peer-deps to npm update and install commands.
+	LegacyPeerDeps bool
+	// Fast adds --no-fund --no-audit to npm update and install commands.
+	Fast bool
+	// Reinstall forces update commands to reinstall the current version (npm --force, brew reinstall, ...).
+	Reinstall bool
+	// Audit checks updated npm agents for high/critical advisories after the run.
+	Audit bool
+	// Verify re-runs each agent's version command after its update and fails agents that stopped launching.
+	Verify bool
+	// Rollback reinstalls the previous version of an agent --verify found broken (implies Verify).
+	Rollback bool
+	// AssumeYes answers yes to every confirmation (clean reinstall, rollback, installs, major upgrades).
+	AssumeYes bool
+	// gate confirms destructive actions interactively; nil proceeds without asking.
+	gate *confirmGate
+	// DetectTimeout bounds each detection command (npm list -g, brew list, ...).
+	DetectTimeout time.Duration
+	// LockTimeout bounds how long a task waits for its manager and conflict-group locks. 0 waits for as
+	// long as the holder runs.
+	LockTimeout time.Duration
+	// NoSpinner disables periodic redraws; the dashboard only redraws on events.
+	NoSpinner bool
+	// KeepDashboard reprints the final dashboard as static text when the run ends, so it stays in scrollback.
+	KeepDashboard bool
+	// GroupFailures prints failure logs grouped by classified reason instead of per exact log.
+	GroupFailures bool
+	// ErrorsOnly drops updated/unchanged/missing output and keeps failure detail.
+	ErrorsOnly bool
+	// Pins are "agent=dist-tag" entries selecting a node dist-tag other than latest.
+	Pins listFlag
+	// AgentTimeouts are "agent=duration" entries overriding Timeout for one agent.
+	AgentTimeouts listFlag
+	// agentTimeouts is AgentTimeouts resolved to canonical agent names.
+	agentTimeouts map[string]time.Duration
+	// Detect runs detection only and prints a report (the `detect` subcommand).
+	Detect bool
+	JSON   bool
+	// List prints the agent catalog (built-ins plus --config agents) without detecting anything.
+	List bool
+	// ListManagers prints each package manager's detection state (present, global bin, package count).
+	ListManagers bool
+	// PrintConfig prints the effective agent definitions as a --config file.
+	PrintConfig bool
+	// ExplainJSON prints the detect report as JSON with each agent's strategy decision trace.
+	ExplainJSON bool
+	// Output is a file that receives the per-agent results and summary (JSON with --json).
+	Output string
+	// DumpOutput is a directory that receives one <agent>.log per agent with its raw update output.
+	DumpOutput string
+	// Format selects stdout output: text (default), json, tsv, csv, or a text/template applied to each result.
+	Format string
+	// Header adds a column header row to --format tsv/csv.
+	Header bool
+	// resultFormat is the parsed per-result template for custom --format values.
+	resultFormat *template.Template
+	// Webhook is a URL that receives the JSON run report (plus host and version) after each run.
+	Webhook string
+	// WebhookHeaders are extra "Name: value" headers for the --webhook request.
+	WebhookHeaders headerFlag
+	// webhookHeaders is WebhookHeaders parsed.
+	webhookHeaders http.Header
+	// StateFile overrides where per-agent "last updated" records are kept.
+	StateFile string
+	// RetryFailed selects the agents whose update failed in earlier runs, as recorded in the state file.
+	RetryFailed bool
+	// Profile is a file that receives per-task timings (worker, lock and network waits) as JSON.
+	Profile string
+	// Check reports what would be updated (like --dry-run); with ChangedSince it also lists stale agents.
+	Check        bool
+	ChangedSince time.Duration
+	// lastUpdated holds the records loaded from the state file, keyed by canonical agent name.
+	lastUpdated map[string]agentRecord
 }
 
 type result struct {
-	Agent     agents.Agent
-	Status    string
-	Reason    string
-	Before    string
-	After     string
-	Duration  time.Duration
-	Log       string
-	UpdateCmd string
-	Method    string
-	Explain   string
+	Agent  agents.Agent
+	Status string
+	// ReasonCode is why the result has its status; the label people see is derived from it (Reason).
+	ReasonCode reasonCode
+	// ReasonDetail replaces the code's label when it says more, e.g. "exit 3" or "would fail: pnpm not found".
+	ReasonDetail string
+	Before       string
+	After        string
+	Duration     time.Duration
+	Log          string
+	UpdateCmd    string
+	Method       string
+	Explain      string
+	// Batched is true when the agent was updated by a command shared with other agents.
+	Batched bool
+	// ExitCode is a failed update command's exit status (0 for timeouts and cancellations).
+	ExitCode int
+	// Advisories is --audit's high/critical count for the installed package, e.g. "2 high".
+	Advisories string
+	// OtherInstalls are the other managers that also have the agent installed, e.g. ["npm"].
+	OtherInstalls []string
 }
 
 const (
@@ -66,49 +205,402 @@ const (
 
 var version = "dev"
 
+// commit is the git revision uca was built from, set with -ldflags "-X main.commit=..."; when empty,
+// buildMetadata falls back to the revision the Go toolchain stamped into the binary.
+var commit = ""
+
+// buildInfo is uca's own build metadata, printed by `uca version --json`.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"goVersion"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+func buildMetadata() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if info.Commit == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range bi.Settings {
+				if setting.Key == "vcs.revision" {
+					info.Commit = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+// reasonCode is the stable, machine-readable form of a result's reason ("reasonCode" in JSON). Reason
+// labels are for people and may be reworded; codes are only ever added.
+type reasonCode string
+
 const (
-	reasonMissing       = "missing"
-	reasonMissingBun    = "missing bun"
-	reasonMissingCode   = "missing vscode"
-	reasonManualInstall = "manual install"
-	reasonQuota         = "quota"
-	reasonNpmNotEmpty   = "npm ENOTEMPTY"
+	codeMissing       reasonCode = "missing"
+	codeMissingBun    reasonCode = "missing_bun"
+	codeMissingVSCode reasonCode = "missing_vscode"
+	codeManualInstall reasonCode = "manual_install"
+	codeBrokenInstall reasonCode = "broken_install"
+	codeDuplicate     reasonCode = "duplicate"
+	// codeBroken is a --verify regression: the binary launched before the update and fails after it.
+	codeBroken        reasonCode = "broken"
+	codeRolledBack    reasonCode = "rolled_back"
+	codeDetectTimeout reasonCode = "detect_timeout"
+	codeInstalled     reasonCode = "installed"
+	codeReinstalled   reasonCode = "reinstalled"
+	codeBatchPartial  reasonCode = "batch_partial"
+	codeCanceled      reasonCode = "canceled"
+	codeLockTimeout   reasonCode = "lock_timeout"
+	codeCurrent       reasonCode = "current"
+	codeMajorUpgrade  reasonCode = "major_upgrade"
+	codeAuth          reasonCode = "auth"
+	codeQuota         reasonCode = "quota"
+	codeNpmNotEmpty   reasonCode = "npm_enotempty"
+	codeDepConflict   reasonCode = "dependency_conflict"
+	codePnpmIntegrity reasonCode = "pnpm_integrity"
+	codePnpmStore     reasonCode = "pnpm_store"
+	codePnpmLockfile  reasonCode = "pnpm_lockfile"
+	codeDryRun        reasonCode = "dry_run"
+	codeTimeout       reasonCode = "timeout"
+	codePermission    reasonCode = "permission"
+	codeNetwork       reasonCode = "network"
+	codeTLS           reasonCode = "tls"
+	codeBrewBusy      reasonCode = "brew_busy"
+	// codeExitStatus is an unclassified failure; the exit status is in result.ExitCode.
+	codeExitStatus reasonCode = "exit_status"
+	// codeWouldFail is a dry-run command that could not run (its executable is not on PATH).
+	codeWouldFail reasonCode = "would_fail"
+	// codeUninstalled is a recovery that uninstalled the package and then failed to install it
+	// again, so the agent is no longer installed.
+	codeUninstalled reasonCode = "removed_not_reinstalled"
 )
 
+// reasonLabels maps each code to the label shown in result lines and the summary.
+var reasonLabels = map[reasonCode]string{
+	codeMissing:       "missing",
+	codeMissingBun:    "missing bun",
+	codeMissingVSCode: "missing vscode",
+	codeManualInstall: "manual install",
+	codeBrokenInstall: "broken install",
+	codeDuplicate:     "duplicate",
+	codeBroken:        "broken",
+	codeRolledBack:    "rolled back",
+	codeDetectTimeout: "detection timed out",
+	codeInstalled:     "installed",
+	codeReinstalled:   "reinstalled",
+	codeBatchPartial:  "batch partial",
+	codeCanceled:      "canceled",
+	codeLockTimeout:   "lock timeout",
+	codeCurrent:       "current",
+	codeMajorUpgrade:  "major upgrade",
+	codeAuth:          "auth",
+	codeQuota:         "quota",
+	codeNpmNotEmpty:   "npm ENOTEMPTY",
+	codeDepConflict:   "dependency conflict",
+	codePnpmIntegrity: "pnpm integrity",
+	codePnpmStore:     "pnpm store",
+	codePnpmLockfile:  "pnpm lockfile",
+	codeDryRun:        "dry-run",
+	codeTimeout:       "timeout",
+	codePermission:    "permission",
+	codeNetwork:       "network",
+	codeTLS:           "tls",
+	codeBrewBusy:      "brew busy",
+	codeExitStatus:    "exit status",
+	codeWouldFail:     "would fail",
+	codeUninstalled:   "removed, reinstall failed",
+}
+
+func (c reasonCode) label() string {
+	return reasonLabels[c]
+}
+
+// dryRun reports whether the code marks a dry-run preview, including one whose command would not run.
+func (c reasonCode) dryRun() bool {
+	return c == codeDryRun || c == codeWouldFail
+}
+
+// Reason is the human label for the result's reason: ReasonDetail when set, else the code's label ("" when
+// there is no reason). It is a method so --format templates can use {{.Reason}}.
+func (res result) Reason() string {
+	if res.ReasonDetail != "" {
+		return res.ReasonDetail
+	}
+	return res.ReasonCode.label()
+}
+
 func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
+	start := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
 
 	opts := parseFlags()
 	if opts.Help {
 		usage()
 		return
 	}
+	if err := validateOptions(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+		os.Exit(2)
+	}
 	if opts.Version {
+		if opts.JSON {
+			data, _ := json.MarshalIndent(buildMetadata(), "", "  ")
+			fmt.Fprintln(os.Stdout, string(data))
+			return
+		}
+		// Plain --version stays the bare version string for scripts.
 		fmt.Fprintln(os.Stdout, version)
 		return
 	}
 
-	all := agents.Default()
+	all, err := loadAgents(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+		os.Exit(2)
+	}
+	pins, err := parseAssignments("pin", opts.Pins)
+	if err == nil {
+		all, err = applyPins(all, pins)
+	}
+	if err == nil {
+		opts.agentTimeouts, err = parseAgentTimeouts(all, opts.AgentTimeouts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+		os.Exit(2)
+	}
+	opts.resultFormat, _ = parseResultFormat(opts.Format) // validated above
+	opts.webhookHeaders, _ = parseWebhookHeaders(opts.WebhookHeaders)
+	opts.gate = newConfirmGate(opts)
+	if warning := shortTimeoutWarning(opts); warning != "" && !opts.DryRun {
+		fmt.Fprintf(os.Stderr, "uca: warning: %s\n", warning)
+	}
+	statePath := resolveStatePath(opts.StateFile)
+	state, err := loadState(statePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "uca: warning: %v (ignoring)\n", err)
+		if _, statErr := os.Stat(statePath); statErr == nil {
+			// The unreadable file is still in place; don't overwrite it with this run's state.
+			statePath = ""
+		}
+	}
+	if err := readStdinLists(&opts, os.Stdin); errors.Is(err, errNoStdinAgents) {
+		fmt.Fprintf(os.Stderr, "uca: %v; nothing to do\n", err)
+		return
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+		os.Exit(2)
+	}
+	if opts.RetryFailed {
+		if len(state.Failed) == 0 {
+			fmt.Fprintln(os.Stderr, "uca: no failed agents recorded; nothing to retry")
+			return
+		}
+		opts.Only = strings.Join(state.Failed, ",")
+	}
+	if opts.AgentsFile != "" {
+		names, err := readAgentsFile(opts.AgentsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+			os.Exit(2)
+		}
+		opts.Only = joinList(opts.Only, names)
+	}
 	selected, unknown := filterAgents(all, opts.Only, opts.Skip)
+	if opts.PrintConfig {
+		if err := printConfig(os.Stdout, selected); err != nil {
+			fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if opts.List {
+		if err := printCatalog(os.Stdout, selected, opts.JSON); err != nil {
+			fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.ListManagers {
+		if err := printManagers(os.Stdout, buildManagerReports(newRunEnv(ctx, opts)), opts.JSON); err != nil {
+			fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if opts.Detect || opts.ExplainJSON {
+		report := buildDetectReport(newRunEnv(ctx, opts), selected, unknown, opts.ExplainJSON)
+		if err := printDetectReport(os.Stdout, report, opts.JSON || opts.ExplainJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if opts.Watch > 0 {
+		watchLoop(ctx, opts.Watch, func() {
+			results, err := runOnce(ctx, selected, unknown, opts, &state, statePath, time.Now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+			}
+			if ctx.Err() != nil {
+				fmt.Fprintln(os.Stderr, formatInterrupted(results))
+			}
+		})
+		return
+	}
+	results, err := runOnce(ctx, selected, unknown, opts, &state, statePath, start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "uca: %v\n", err)
+		os.Exit(1)
+	}
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, formatInterrupted(results))
+		os.Exit(exitCodeCanceled)
+	}
+	stuck := []string{}
+	if opts.RequireUpdate && !opts.DryRun {
+		stuck = unmovedAgents(results)
+		if len(stuck) > 0 {
+			fmt.Fprintf(os.Stderr, "uca: --require-update: no new version for %s\n", strings.Join(stuck, ", "))
+		}
+	}
+	if hasFailures(results) || len(stuck) > 0 {
+		os.Exit(1)
+	}
+}
 
+// newRunEnv builds a fresh detection environment. Every run (and every --watch cycle) gets its own, so
+// tools installed or removed since the last run are picked up.
+func newRunEnv(ctx context.Context, opts options) *envState {
 	env := newEnv(ctx)
+	env.managerPriority = splitList(opts.ManagerPriority)
+	env.prefer = opts.Prefer
+	env.detectTimeout = opts.DetectTimeout
+	return env
+}
+
+// runOnce detects and updates (or checks) the selected agents, prints the results, and records them in
+// state. The returned error is only for --output; everything else is reported in the results.
+func runOnce(ctx context.Context, selected []agents.Agent, unknown []string, opts options, state *runState, statePath string, start time.Time) ([]result, error) {
+	opts.lastUpdated = state.Agents
+	env := newRunEnv(ctx, opts)
 	uiEnabled := shouldShowUI(opts)
+	if uiEnabled {
+		// Only bun's stale-global reinstall can still ask here, and its prompt would be drawn over; that
+		// repair proceeds on its own under the dashboard, as it did before there was a gate.
+		opts.gate = nil
+	}
 	results := runAll(ctx, selected, env, opts, uiEnabled)
+	if opts.Audit && !opts.DryRun {
+		auditNodeAgents(results)
+	}
 
-	if !uiEnabled {
-		printResults(results, opts)
-	} else {
-		fmt.Fprintln(os.Stdout)
+	if err := printRunOutput(os.Stdout, os.Stderr, results, unknown, time.Since(start), opts, *state, uiEnabled); err != nil {
+		return results, err
+	}
+	if opts.GitHub {
+		for _, line := range formatAnnotations(results) {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+
+	if !opts.DryRun {
+		recordResults(state, results, time.Now())
+		if err := saveState(statePath, *state); err != nil {
+			fmt.Fprintf(os.Stderr, "uca: warning: %v\n", err)
+		}
+	}
+	if opts.Webhook != "" {
+		report := buildWebhookReport(results, unknown, time.Since(start), opts.DryRun)
+		if err := postWebhook(opts.Webhook, opts.webhookHeaders, report); err != nil {
+			fmt.Fprintf(os.Stderr, "uca: warning: %v\n", err)
+		}
+	}
+	if opts.Output != "" {
+		if err := writeReport(opts.Output, results, unknown, time.Since(start), opts); err != nil {
+			return results, err
+		}
+	}
+	if opts.DumpOutput != "" {
+		if err := writeOutputDump(opts.DumpOutput, results); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// printRunOutput prints what follows a run: logs, the summary, versions changed since the last run, and
+// --check staleness. With a machine --format, stdout carries only the formatted results, so a pipe into jq
+// always gets one parseable document; everything meant for humans goes to stderr (logs are dropped under --quiet).
+func printRunOutput(stdout, stderr io.Writer, results []result, unknown []string, elapsed time.Duration, opts options, state runState, uiEnabled bool) error {
+	if isMachineFormat(opts.Format) {
+		if err := printFormatted(stdout, results, unknown, elapsed, opts); err != nil {
+			return err
+		}
+		if !opts.Quiet {
+			printLogs(stderr, results, opts)
+		}
+		printSummary(stderr, results, unknown, elapsed, opts)
+		if !opts.ErrorsOnly {
+			fmt.Fprint(stderr, formatSinceLastRun(results, state, opts.DryRun, time.Now()))
+		}
+		if opts.Check && opts.ChangedSince > 0 {
+			fmt.Fprint(stderr, formatStale(results, state, time.Now(), opts.ChangedSince))
+		}
+		return nil
+	}
+	if opts.BeforeAfterOnly {
+		printBeforeAfter(stdout, results)
+		return nil
+	}
+	// Without the UI, result lines were already streamed as each agent finished.
+	if uiEnabled {
+		fmt.Fprintln(stdout)
 		if opts.Explain && !opts.Quiet {
-			printExplainDetails(results)
+			printExplainDetails(stdout, results)
+		}
+	}
+	if uiEnabled && streamsLogs(opts) {
+		// The dashboard already printed each log above itself as the agent finished.
+		if opts.GroupFailures {
+			fmt.Fprint(stdout, formatFailureClasses(results))
 		}
+	} else {
+		printLogs(stdout, results, opts)
+	}
+	printSummary(stdout, results, unknown, elapsed, opts)
+	if !opts.ErrorsOnly {
+		fmt.Fprint(stdout, formatSinceLastRun(results, state, opts.DryRun, time.Now()))
+	}
+	if opts.Check && opts.ChangedSince > 0 {
+		fmt.Fprint(stdout, formatStale(results, state, time.Now(), opts.ChangedSince))
 	}
-	printLogs(results, opts)
-	printSummary(results, unknown)
+	return nil
+}
 
-	if hasFailures(results) {
-		os.Exit(1)
+// minWatchInterval keeps --watch from hammering registries and package managers.
+const minWatchInterval = time.Minute
+
+// watchLoop runs cycle, then again every interval, until ctx is canceled (Ctrl-C).
+func watchLoop(ctx context.Context, interval time.Duration, cycle func()) {
+	for {
+		cycle()
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "uca: next run at %s (Ctrl-C to stop)\n", time.Now().Add(interval).Format("15:04"))
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
 	}
 }
 
@@ -118,21 +610,107 @@ func parseFlags() options {
 	flag.BoolVar(&opts.Parallel, "parallel", false, "run updates in parallel")
 	flag.BoolVar(&opts.Serial, "serial", false, "run updates sequentially")
 	flag.BoolVar(&opts.Safe, "safe", false, "use safer execution (limits concurrency)")
+	flag.IntVar(&opts.SafeConcurrency, "safe-concurrency", defaultSafeConcurrency, "concurrency cap applied by --safe")
 	flag.DurationVar(&opts.Timeout, "timeout", 15*time.Minute, "timeout per update command (0 disables)")
-	flag.IntVar(&opts.Concurrency, "concurrency", 0, "max concurrent update commands (0 disables)")
+	flag.DurationVar(&opts.LockTimeout, "lock-timeout", 0, "give up on a task whose manager or conflict-group lock isn't free within this long (0 waits)")
+	flag.Var(&opts.AgentTimeouts, "timeout-agent", "per-agent timeout override, e.g. claude=30m (repeatable)")
+	flag.Var((*concurrencyFlag)(&opts.Concurrency), "concurrency", "max concurrent update commands, or auto (0 disables)")
+	flag.Var((*concurrencyFlag)(&opts.Concurrency), "j", "max concurrent update commands (alias for --concurrency)")
+	flag.Var((*concurrencyFlag)(&opts.Concurrency), "jobs", "max concurrent update commands (alias for --concurrency)")
+	flag.IntVar(&opts.MaxNetwork, "max-network", 0, "max concurrent download-heavy updates (node, brew, pip, uv, ...; 0 disables)")
+	flag.IntVar(&opts.BatchSize, "batch-size", 0, "max packages per node, brew, or uv batch update (0 disables)")
+	flag.BoolVar(&opts.NoBatch, "no-batch", false, "update node, brew, and uv agents one package at a time")
+	flag.BoolVar(&opts.RefreshFirst, "refresh-first", false, "refresh manager indexes (brew update, ...) before updating")
+	flag.Var(&opts.Pins, "pin", "install a node dist-tag for an agent, e.g. codex=beta (repeatable)")
+	flag.StringVar(&opts.ManagerPriority, "manager-priority", "", "node manager tie-break order, e.g. pnpm,npm,yarn,bun")
+	flag.StringVar(&opts.Prefer, "prefer", "", "try native updaters or package managers first: native or package")
 	flag.BoolVar(&opts.Verbose, "v", false, "show update command output")
 	flag.BoolVar(&opts.Verbose, "verbose", false, "show update command output")
 	flag.BoolVar(&opts.Quiet, "q", false, "summary only")
 	flag.BoolVar(&opts.Quiet, "quiet", false, "summary only")
+	flag.BoolVar(&opts.ErrorsOnly, "quiet-success", false, "only show failures and their logs")
+	flag.BoolVar(&opts.ErrorsOnly, "errors-only", false, "only show failures and their logs")
+	flag.BoolVar(&opts.InstallMissing, "install-missing", false, "install missing agents named in --only")
+	flag.BoolVar(&opts.InstallAllMissing, "install-all-missing", false, "install every missing agent")
+	flag.BoolVar(&opts.CleanReinstall, "clean-reinstall", false, "uninstall then reinstall an npm package whose install keeps failing")
+	flag.BoolVar(&opts.UpdateAllCopies, "update-all-copies", false, "also update an agent's installs through other managers")
+	flag.BoolVar(&opts.LegacyPeerDeps, "legacy-peer-deps", false, "pass --legacy-peer-deps to npm installs")
+	flag.BoolVar(&opts.Fast, "fast", false, "pass --no-fund --no-audit to npm installs")
+	flag.BoolVar(&opts.Reinstall, "reinstall", false, "force a reinstall even when the agent is already current")
+	flag.BoolVar(&opts.Audit, "audit", false, "report high/critical npm advisories for updated agents")
+	flag.BoolVar(&opts.Verify, "verify", false, "fail updates after which the agent no longer launches")
+	flag.BoolVar(&opts.Rollback, "rollback", false, "reinstall the previous version when --verify finds an update broken")
+	flag.BoolVar(&opts.AssumeYes, "y", false, "proceed with destructive actions without asking")
+	flag.BoolVar(&opts.AssumeYes, "yes", false, "proceed with destructive actions without asking")
+	flag.BoolVar(&opts.AssumeYes, "assume-yes", false, "proceed with destructive actions without asking")
 	flag.BoolVar(&opts.DryRun, "n", false, "print commands without executing")
 	flag.BoolVar(&opts.DryRun, "dry-run", false, "print commands without executing")
 	flag.BoolVar(&opts.Explain, "explain", false, "explain detection and update method")
-	flag.StringVar(&opts.Only, "only", "", "comma-separated agent list")
-	flag.StringVar(&opts.Skip, "skip", "", "comma-separated agent list to exclude")
+	flag.BoolVar(&opts.Check, "check", false, "report what would be updated without executing")
+	flag.DurationVar(&opts.ChangedSince, "changed-since", 0, "with --check, list agents not updated within this duration")
+	flag.StringVar(&opts.StateFile, "state-file", "", "file recording when each agent was last updated")
+	flag.BoolVar(&opts.RetryFailed, "retry-failed", false, "only run agents whose update failed last time")
+	flag.BoolVar(&opts.List, "list", false, "print the agent catalog and exit")
+	flag.BoolVar(&opts.ListManagers, "list-managers", false, "print each package manager's detection state and exit")
+	flag.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective agent definitions as JSON and exit")
+	flag.BoolVar(&opts.ExplainJSON, "explain-json", false, "print detection decisions as JSON (no updates)")
+	flag.BoolVar(&opts.GroupFailures, "group-failures", false, "group failure logs by failure class")
+	flag.StringVar(&opts.Only, "only", "", "comma-separated agent list (- reads it from stdin)")
+	flag.StringVar(&opts.Skip, "skip", "", "comma-separated agent list to exclude (- reads it from stdin)")
 	flag.BoolVar(&opts.Help, "h", false, "show help")
 	flag.BoolVar(&opts.Help, "help", false, "show help")
 	flag.BoolVar(&opts.Version, "version", false, "show version")
-	flag.Parse()
+	flag.BoolVar(&opts.JSON, "json", false, "machine-readable JSON output (detect report, --list, --output)")
+	flag.BoolVar(&opts.GuardMajor, "guard-major", false, "ask before (or, without a TTY, skip) major version upgrades")
+	flag.BoolVar(&opts.AllowMajor, "allow-major", false, "apply major version upgrades despite --guard-major")
+	flag.DurationVar(&opts.Watch, "watch", 0, "re-run on this interval until interrupted (e.g. 6h)")
+	flag.BoolVar(&opts.OnlyOutdated, "only-outdated", false, "skip agents already at the latest version")
+	flag.BoolVar(&opts.RequireUpdate, "require-update", false, "exit non-zero if an updated agent's version did not change")
+	flag.BoolVar(&opts.GitHub, "github", false, "emit GitHub Actions annotations on stderr")
+	flag.BoolVar(&opts.GitHub, "annotations", false, "emit GitHub Actions annotations on stderr")
+	flag.StringVar(&opts.Output, "output", "", "also write per-agent results and the summary to FILE")
+	flag.StringVar(&opts.Webhook, "webhook", "", "POST the JSON run report to URL after the run")
+	flag.Var(&opts.WebhookHeaders, "webhook-header", "extra 'Name: value' header for --webhook (repeatable)")
+	flag.StringVar(&opts.DumpOutput, "dump-output", "", "write each agent's raw update output to DIR/<agent>.log")
+	flag.StringVar(&opts.Profile, "profile", "", "write per-task timings (worker, lock waits) as JSON to FILE")
+	flag.StringVar(&opts.Format, "format", formatText, "stdout format: text, json, tsv, csv, or a Go template per result")
+	csvOut := false
+	flag.BoolVar(&csvOut, "csv", false, "shorthand for --format csv")
+	flag.BoolVar(&opts.Header, "header", false, "print a column header row with --format tsv/csv")
+	flag.BoolVar(&opts.BeforeAfterOnly, "before-after-only", false, "print only changed agents as name: before -> after")
+	flag.StringVar(&opts.Config, "config", "", "JSON file with custom agent definitions")
+	flag.StringVar(&opts.Color, "color", modeAuto, "colorize output: auto, always, never")
+	flag.StringVar(&opts.Unicode, "unicode", modeAuto, "use unicode glyphs: auto, always, never")
+	flag.DurationVar(&opts.RefreshInterval, "refresh-interval", 120*time.Millisecond, "dashboard redraw interval")
+	flag.DurationVar(&opts.DetectTimeout, "detect-timeout", defaultDetectTimeout, "timeout per detection command")
+	flag.DurationVar(&opts.DetectTimeout, "parallel-detect-timeout", defaultDetectTimeout, "timeout per detection command")
+	flag.BoolVar(&opts.NoSpinner, "no-spinner", false, "redraw the dashboard only when an agent changes state")
+	flag.BoolVar(&opts.KeepDashboard, "keep-dashboard", false, "reprint the final dashboard as static text when done")
+	flag.BoolVar(&opts.Progress, "progress", false, "print periodic status lines to stderr (non-TTY)")
+	flag.StringVar(&opts.AgentsFile, "agents-file", "", "file listing agents to include (# comments allowed)")
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "detect" {
+		opts.Detect = true
+		args = args[1:]
+	} else if len(args) > 0 && args[0] == "version" {
+		opts.Version = true
+		args = args[1:]
+	}
+	// ExitOnError: Parse never returns an error here.
+	_ = flag.CommandLine.Parse(args)
+	if opts.Check {
+		opts.DryRun = true
+	}
+	if opts.Quiet && opts.JSON && opts.Format == formatText {
+		// --quiet --json: the JSON report is the only thing on stdout.
+		opts.Format = formatJSON
+	}
+	if opts.Rollback {
+		opts.Verify = true
+	}
+	if csvOut {
+		opts.Format = formatCSV
+	}
 	return opts
 }
 
@@ -141,44 +719,261 @@ func usage() {
 
 Usage:
   uca [options]
+  uca detect [--json] [options]   report detected managers and agents without updating
+  uca version [--json]            print uca's version (--json adds commit, Go version, OS/arch)
 
 Options:
   -p, --parallel    run updates in parallel (default)
       --serial      run updates sequentially
-      --safe        safer execution (limits concurrency)
-      --timeout D   timeout per update command (0 disables, default 15m)
-      --concurrency N max concurrent update commands (0 disables)
-  -v, --verbose     show update command output for each agent
+      --safe        safer execution: at most --safe-concurrency updates at once (default 2)
+      --safe-concurrency N
+                    concurrency cap used by --safe (1 is fully serial)
+      --timeout D   timeout per update command (0 disables, default 15m; values under 1m warn)
+      --timeout-agent AGENT=D
+                    override --timeout for one agent (repeatable; a node batch uses its members' max)
+      --detect-timeout D
+                    timeout per detection command such as npm list -g (default 30s)
+      --lock-timeout D
+                    skip a task that waits longer than D for another update of the same manager or
+                    conflict group (reported as "skipped (lock timeout)" and kept for --retry-failed;
+                    0 waits)
+  -j, --jobs, --concurrency N|auto
+                    max concurrent update commands (0 disables; overrides --safe). auto sizes it
+                    from the CPU count and caps download-heavy updates unless --max-network is set
+      --max-network N
+                    max concurrent download-heavy updates (node, brew, pip, uv, VS Code, asdf) while
+                    native updaters run freely (0 disables)
+      --batch-size N  max packages per node, brew, or uv batch update (0 disables)
+      --no-batch    update node, brew, and uv agents one package at a time (no batching)
+      --refresh-first
+                    run brew update / asdf plugin update --all once before updating agents that use them
+      --pin AGENT=TAG
+                    install a node dist-tag (e.g. beta, next) instead of latest (repeatable)
+      --manager-priority LIST
+                    node manager order used when an agent matches several (e.g. pnpm,npm,yarn,bun)
+      --prefer native|package
+                    for agents with both, try the native updater or the package manager first
+  -v, --verbose     show update command output for each agent (above the dashboard as each finishes)
   -q, --quiet       suppress per-agent version lines (summary only)
+      --quiet-success, --errors-only
+                    only show failures, their logs, and failed/skipped summary lines
+      --clean-reinstall
+                    if an npm global install still fails after the ENOTEMPTY retry, npm uninstall -g
+                    then install again
+      --update-all-copies
+                    when an agent is installed through several managers (e.g. brew and npm), update
+                    every copy, not just the one uca resolved
+      --legacy-peer-deps
+                    pass --legacy-peer-deps to every npm update and install command (use it when
+                    one fails with an ERESOLVE peer conflict)
+      --fast        pass --no-fund --no-audit to npm installs (skips funding notices and the audit
+                    request); a failed install whose output warns npm doesn't know them is
+                    retried without them
+      --reinstall   force a reinstall of the current version to repair a broken install (npm/pnpm/yarn/bun
+                    --force, brew reinstall, pip --force-reinstall); reported as "reinstalled".
+                    Also repairs a "broken install" (dangling symlink in a node global bin)
+      --verify      after updating, run each agent's version command again and fail agents that launched
+                    before the update but not after it as "broken" (a bad release)
+      --rollback    with --verify (implied), reinstall the previous version of a broken npm/pnpm/yarn/bun,
+                    pip, or uv agent; reported as "rolled back" and still a failure
+  -y, --yes, --assume-yes
+                    don't ask before destructive actions (--clean-reinstall, bun's remove and add of a
+                    stale global, --rollback, installing missing agents, --guard-major upgrades);
+                    without a TTY uca never asks
+      --audit       after updating, report high/critical advisories per npm agent from npm's summary
+                    in its own install output, e.g. "advisories: gemini (2 high)"
   -n, --dry-run     print commands that would run, do not execute
-      --explain     show detection details and chosen update method
-      --only LIST   comma-separated agent list to include
-      --skip LIST   comma-separated agent list to exclude
-      --version     show version
+      --install-missing
+                    install missing agents listed in --only/--agents-file
+      --install-all-missing
+                    install every missing agent with a known install method
+      --explain     show detection details, chosen update method, and when uca last updated the agent
+      --check       report what would be updated without executing (like --dry-run); marks
+                    outdated agents, "[target unknown]" when the method has no latest lookup
+      --changed-since D
+                    with --check, list agents uca has not updated within D (e.g. 168h)
+      --state-file FILE
+                    where last-updated records are kept (default $XDG_STATE_HOME/uca/state.json)
+      --retry-failed
+                    only run the agents that failed in earlier runs (like --only with their names)
+      --explain-json
+                    print every strategy considered per agent and why it won or lost, as JSON (no updates)
+      --group-failures
+                    group failure logs by class (network, permission, ...) with per-agent tails
+      --only LIST   comma-separated agent list to include; globs like 'c*' match names and aliases;
+                    - reads the names from stdin (whitespace or comma separated)
+      --skip LIST   comma-separated agent list to exclude (globs allowed; - reads stdin)
+      --agents-file FILE
+                    read agents to include from FILE (like --only; # comments allowed)
+      --before-after-only
+                    print only changed agents (and failures) as "name: before -> after"
+      --config FILE JSON file with custom agent definitions (merged over built-ins)
+      --color WHEN  colorize output: auto (default), always, never
+      --unicode WHEN
+                    use unicode spinner/icons: auto (default, from locale), always, never
+      --refresh-interval D
+                    dashboard redraw interval (default 120ms; raise it over slow SSH)
+      --no-spinner  redraw the dashboard only when an agent changes state
+      --keep-dashboard
+                    when done, reprint the final dashboard (versions, timings) as static text above the
+                    summary so it stays in scrollback
+      --progress    print a status line to stderr every 30s when not a TTY
+      --list        print known agents (name, binary, extension, strategy kinds) without detecting
+      --list-managers
+                    print each package manager: found or not, global bin dir, package count, and
+                    detection commands that failed or timed out
+      --print-config
+                    print the effective agents (built-ins + --config + --pin, filtered by --only/--skip)
+                    as a JSON file usable with --config
+      --guard-major check the latest version first and ask before a major upgrade (e.g. 1.x -> 2.x);
+                    without a TTY such agents are skipped as "major upgrade"
+      --allow-major apply major upgrades despite --guard-major
+      --watch D     keep running and repeat every D (at least 1m); with --check it is a monitor
+      --only-outdated
+                    skip agents already at latest (npm/pnpm/yarn/bun, brew, uv/pip, VS Code
+                    marketplace); other methods still run their update
+      --require-update
+                    exit 1 if any agent ends up "unchanged" (or batch partial), e.g. a registry serving
+                    a stale version; with --only-outdated, only agents that were behind count
+      --github, --annotations
+                    emit GitHub Actions ::error/::warning lines on stderr for failures and skips
+      --output FILE also write per-agent results and the summary to FILE (stdout is unchanged)
+      --dump-output DIR
+                    write DIR/<agent>.log for every agent with update output (successes too): the
+                    command, status, versions, timing, and the raw output, ungrouped
+      --webhook URL POST the JSON report (with host and uca version) to URL after each run; a failed
+                    delivery only warns
+      --webhook-header 'NAME: VALUE'
+                    extra header for --webhook, e.g. 'Authorization: Bearer ...' (repeatable)
+      --profile FILE
+                    write per-task timings as JSON: worker, start/end, time waiting on manager locks and
+                    --max-network vs running, plus wall-clock vs summed durations
+      --format F    stdout format: text (default), json, tsv, csv, or a Go text/template applied to
+                    each result, e.g. '{{.Agent.Name}}\t{{.Status}}\t{{.After}}'; logs and the summary
+                    move to stderr
+      --csv         shorthand for --format csv
+      --header      with --format tsv/csv, print a header row (name, status, before, after, method,
+                    duration_s, reason)
+      --json        JSON output for the detect report and --list; with --output, the file is JSON;
+                    with --quiet, stdout is only the JSON run report (--format json, summary on stderr)
+      --version     show version (with --json: version, commit, Go version, OS, arch)
   -h, --help        show usage
 `)
 }
 
-func filterAgents(all []agents.Agent, onlyRaw, skipRaw string) ([]agents.Agent, []string) {
-	only := parseList(onlyRaw)
-	skip := parseList(skipRaw)
+const (
+	modeAuto   = "auto"
+	modeAlways = "always"
+	modeNever  = "never"
+)
 
-	known := make(map[string]bool, len(all))
-	for _, agent := range all {
-		known[agent.Name] = true
+func validateOptions(opts options) error {
+	if opts.RefreshInterval <= 0 {
+		return fmt.Errorf("invalid --refresh-interval %s (must be > 0)", opts.RefreshInterval)
 	}
-
-	unknownSet := map[string]bool{}
-	for name := range only {
-		if !known[name] {
-			unknownSet[name] = true
+	if opts.InstallMissing && !opts.InstallAllMissing && strings.TrimSpace(opts.Only) == "" && opts.AgentsFile == "" {
+		return fmt.Errorf("--install-missing only installs agents named in --only or --agents-file; use --install-all-missing to install every missing agent")
+	}
+	if opts.Watch < 0 || (opts.Watch > 0 && opts.Watch < minWatchInterval) {
+		return fmt.Errorf("invalid --watch %s (must be at least %s)", opts.Watch, minWatchInterval)
+	}
+	if opts.Reinstall && opts.OnlyOutdated {
+		return fmt.Errorf("--reinstall and --only-outdated conflict (--only-outdated skips the agents --reinstall would repair)")
+	}
+	if opts.ChangedSince < 0 {
+		return fmt.Errorf("invalid --changed-since %s (must be >= 0)", opts.ChangedSince)
+	}
+	if opts.RetryFailed && (strings.TrimSpace(opts.Only) != "" || opts.AgentsFile != "") {
+		return fmt.Errorf("--retry-failed picks the agents itself; drop --only/--agents-file")
+	}
+	if opts.ChangedSince > 0 && !opts.Check {
+		return fmt.Errorf("--changed-since requires --check")
+	}
+	if opts.Timeout < 0 {
+		return fmt.Errorf("invalid --timeout %s (must be >= 0; 0 disables it)", opts.Timeout)
+	}
+	if opts.LockTimeout < 0 {
+		return fmt.Errorf("invalid --lock-timeout %s (must be >= 0; 0 disables it)", opts.LockTimeout)
+	}
+	if opts.DetectTimeout <= 0 {
+		return fmt.Errorf("invalid --detect-timeout %s (must be > 0)", opts.DetectTimeout)
+	}
+	if opts.Concurrency < 0 && opts.Concurrency != concurrencyAuto {
+		return fmt.Errorf("invalid --concurrency %d (must be >= 0)", opts.Concurrency)
+	}
+	if opts.SafeConcurrency < 0 {
+		return fmt.Errorf("invalid --safe-concurrency %d (must be >= 0; 0 uses the default)", opts.SafeConcurrency)
+	}
+	if opts.MaxNetwork < 0 {
+		return fmt.Errorf("invalid --max-network %d (must be >= 0)", opts.MaxNetwork)
+	}
+	if opts.BatchSize < 0 {
+		return fmt.Errorf("invalid --batch-size %d (must be >= 0)", opts.BatchSize)
+	}
+	if opts.Prefer != "" && opts.Prefer != preferNative && opts.Prefer != preferPackage {
+		return fmt.Errorf("invalid --prefer %q (want native or package)", opts.Prefer)
+	}
+	for _, kind := range splitList(opts.ManagerPriority) {
+		if !isNodeKind(kind) {
+			return fmt.Errorf("invalid --manager-priority entry %q (want npm, pnpm, yarn, or bun)", kind)
 		}
 	}
-	for name := range skip {
-		if !known[name] {
-			unknownSet[name] = true
+	if _, err := parseResultFormat(opts.Format); err != nil {
+		return fmt.Errorf("invalid --format: %w", err)
+	}
+	if opts.Header && opts.Format != formatTSV && opts.Format != formatCSV {
+		return fmt.Errorf("--header requires --format tsv or csv")
+	}
+	if opts.Webhook != "" {
+		if err := validateWebhookURL(opts.Webhook); err != nil {
+			return err
 		}
+	} else if len(opts.WebhookHeaders) > 0 {
+		return fmt.Errorf("--webhook-header requires --webhook")
+	}
+	if _, err := parseWebhookHeaders(opts.WebhookHeaders); err != nil {
+		return err
+	}
+	if !isValidMode(opts.Color) {
+		return fmt.Errorf("invalid --color %q (want auto, always, or never)", opts.Color)
+	}
+	if !isValidMode(opts.Unicode) {
+		return fmt.Errorf("invalid --unicode %q (want auto, always, or never)", opts.Unicode)
+	}
+	return nil
+}
+
+func isValidMode(mode string) bool {
+	switch mode {
+	case modeAuto, modeAlways, modeNever:
+		return true
+	default:
+		return false
+	}
+}
+
+// loadAgents returns the built-in agents merged with any config-defined agents.
+func loadAgents(opts options) ([]agents.Agent, error) {
+	all := agents.Default()
+	if strings.TrimSpace(opts.Config) == "" {
+		return all, nil
+	}
+	cfg, err := agents.LoadConfig(opts.Config)
+	if err != nil {
+		return nil, err
 	}
+	return agents.Merge(all, cfg.Agents), nil
+}
+
+func filterAgents(all []agents.Agent, onlyRaw, skipRaw string) ([]agents.Agent, []string) {
+	only := parseList(onlyRaw)
+	skip := parseList(skipRaw)
+
+	known := agentNameIndex(all)
+
+	unknownSet := map[string]bool{}
+	only = canonicalNames(only, known, unknownSet)
+	skip = canonicalNames(skip, known, unknownSet)
 
 	selected := make([]agents.Agent, 0, len(all))
 	for _, agent := range all {
@@ -200,146 +995,1364 @@ func filterAgents(all []agents.Agent, onlyRaw, skipRaw string) ([]agents.Agent,
 	return selected, unknown
 }
 
-func parseList(raw string) map[string]bool {
-	items := map[string]bool{}
-	if strings.TrimSpace(raw) == "" {
-		return items
+// agentNameIndex maps canonical names and aliases to the canonical agent name.
+func agentNameIndex(all []agents.Agent) map[string]string {
+	known := make(map[string]string, len(all))
+	for _, agent := range all {
+		for _, alias := range agent.Aliases {
+			alias = strings.ToLower(strings.TrimSpace(alias))
+			if alias != "" {
+				known[alias] = agent.Name
+			}
+		}
 	}
-	parts := strings.Split(raw, ",")
-	for _, part := range parts {
-		name := strings.ToLower(strings.TrimSpace(part))
-		if name == "" {
+	// Canonical names win over aliases of other agents.
+	for _, agent := range all {
+		known[agent.Name] = agent.Name
+	}
+	return known
+}
+
+// listFlag is a repeatable string flag; each occurrence may also hold comma-separated values.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+// concurrencyFlag is --concurrency: a number, or "auto" (concurrencyAuto).
+type concurrencyFlag int
+
+func (c *concurrencyFlag) String() string {
+	switch {
+	case c == nil:
+		return "0"
+	case int(*c) == concurrencyAuto:
+		return "auto"
+	default:
+		return strconv.Itoa(int(*c))
+	}
+}
+
+func (c *concurrencyFlag) Set(value string) error {
+	if strings.EqualFold(strings.TrimSpace(value), "auto") {
+		*c = concurrencyFlag(concurrencyAuto)
+		return nil
+	}
+	// A negative number is rejected rather than stored, since concurrencyAuto is itself negative.
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return fmt.Errorf("want a number >= 0 or auto")
+	}
+	*c = concurrencyFlag(n)
+	return nil
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*l = append(*l, part)
+		}
+	}
+	return nil
+}
+
+// parseAssignments parses "name=value" entries into a map keyed by lowercased name.
+func parseAssignments(flagName string, entries []string) (map[string]string, error) {
+	out := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("invalid --%s %q (want agent=value)", flagName, entry)
+		}
+		out[name] = value
+	}
+	return out, nil
+}
+
+// parseAgentTimeouts resolves "agent=duration" entries to canonical agent names.
+func parseAgentTimeouts(all []agents.Agent, entries []string) (map[string]time.Duration, error) {
+	raw, err := parseAssignments("timeout-agent", entries)
+	if err != nil || len(raw) == 0 {
+		return nil, err
+	}
+	known := agentNameIndex(all)
+	out := make(map[string]time.Duration, len(raw))
+	for name, value := range raw {
+		canonical, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("--timeout-agent: unknown agent %q", name)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("--timeout-agent: invalid duration %q for %s", value, canonical)
+		}
+		out[canonical] = d
+	}
+	return out, nil
+}
+
+// minPlausibleTimeout is the shortest update timeout uca accepts without a warning: even a no-op npm
+// install or native self-update routinely takes longer than a few seconds.
+const minPlausibleTimeout = time.Minute
+
+// shortTimeoutWarning flags a --timeout or --timeout-agent value so short that updates would fail as
+// timeouts, or "" when every timeout is plausible.
+func shortTimeoutWarning(opts options) string {
+	short := []string{}
+	if opts.Timeout > 0 && opts.Timeout < minPlausibleTimeout {
+		short = append(short, "--timeout "+opts.Timeout.String())
+	}
+	names := make([]string, 0, len(opts.agentTimeouts))
+	for name, d := range opts.agentTimeouts {
+		if d > 0 && d < minPlausibleTimeout {
+			names = append(names, fmt.Sprintf("--timeout-agent %s=%s", name, d))
+		}
+	}
+	sort.Strings(names)
+	short = append(short, names...)
+	if len(short) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s is shorter than a typical update (%s); those updates will likely fail as timeouts (0 disables the timeout)", strings.Join(short, ", "), minPlausibleTimeout)
+}
+
+// agentTimeout returns the update timeout for an agent: its --timeout-agent override, else --timeout.
+func agentTimeout(opts options, name string) time.Duration {
+	if d, ok := opts.agentTimeouts[name]; ok {
+		return d
+	}
+	return opts.Timeout
+}
+
+// taskTimeout is the longest timeout among a task's agents; 0 (no timeout) wins over any duration.
+func taskTimeout(works []agentWork) time.Duration {
+	var longest time.Duration
+	for i, work := range works {
+		if work.timeout == 0 {
+			return 0
+		}
+		if i == 0 || work.timeout > longest {
+			longest = work.timeout
+		}
+	}
+	return longest
+}
+
+// applyPins sets a dist-tag on the node strategies of pinned agents, returning a new agent list.
+func applyPins(all []agents.Agent, pins map[string]string) ([]agents.Agent, error) {
+	if len(pins) == 0 {
+		return all, nil
+	}
+	known := agentNameIndex(all)
+	byName := make(map[string]string, len(pins))
+	for name, tag := range pins {
+		canonical, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("--pin: unknown agent %q", name)
+		}
+		if strings.ContainsAny(tag, " \t@") {
+			return nil, fmt.Errorf("--pin: invalid dist-tag %q for %s", tag, canonical)
+		}
+		byName[canonical] = tag
+	}
+	out := make([]agents.Agent, len(all))
+	copy(out, all)
+	for i, agent := range out {
+		tag, ok := byName[agent.Name]
+		if !ok {
 			continue
 		}
-		items[name] = true
+		strategies := make([]agents.UpdateStrategy, len(agent.Strategies))
+		copy(strategies, agent.Strategies)
+		pinned := false
+		for j := range strategies {
+			if isNodeKind(strategies[j].Kind) {
+				strategies[j].Tag = tag
+				pinned = true
+			}
+		}
+		if !pinned {
+			return nil, fmt.Errorf("--pin: %s has no npm/pnpm/yarn/bun strategy", agent.Name)
+		}
+		out[i].Strategies = strategies
 	}
-	return items
+	return out, nil
 }
 
-func shouldShowUI(opts options) bool {
-	if opts.Quiet {
-		return false
+// canonicalNames resolves aliases to canonical agent names, recording names that match nothing in unknown.
+func canonicalNames(names map[string]bool, known map[string]string, unknown map[string]bool) map[string]bool {
+	resolved := make(map[string]bool, len(names))
+	for name := range names {
+		if isNamePattern(name) {
+			matched := false
+			for candidate, canonical := range known {
+				// A malformed pattern matches nothing and is reported as unknown.
+				if ok, _ := path.Match(name, candidate); ok {
+					resolved[canonical] = true
+					matched = true
+				}
+			}
+			if !matched {
+				unknown[name] = true
+				resolved[name] = true
+			}
+			continue
+		}
+		canonical, ok := known[name]
+		if !ok {
+			unknown[name] = true
+			// Keep the raw name so an unknown-only --only still selects nothing.
+			resolved[name] = true
+			continue
+		}
+		resolved[canonical] = true
 	}
-	if !isTTY(os.Stdout) {
-		return false
+	return resolved
+}
+
+// isNamePattern reports whether an --only/--skip entry is a shell-style glob such as c* or co?ex,
+// matched against agent names and aliases.
+func isNamePattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// readAgentsFile reads agent names from a file: whitespace or comma separated, with # comments.
+func readAgentsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
-	return true
+	defer file.Close()
+	names, err := parseAgentsFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if len(names) == 0 {
+		// An empty allowlist would otherwise silently select every agent.
+		return nil, fmt.Errorf("%s lists no agents", path)
+	}
+	return names, nil
 }
 
-func isTTY(file *os.File) bool {
-	stat, err := file.Stat()
+// stdinList is the --only/--skip value that reads the list from stdin.
+const stdinList = "-"
+
+// errNoStdinAgents is `--only -` with nothing on stdin: an empty selection, not every agent.
+var errNoStdinAgents = errors.New("--only -: no agent names on stdin")
+
+// readStdinLists replaces a "-" --only or --skip with the names read from stdin, in the --agents-file
+// format (whitespace or comma separated, # comments). An empty stdin skips nothing for --skip, but
+// returns errNoStdinAgents for --only.
+func readStdinLists(opts *options, stdin io.Reader) error {
+	only := strings.TrimSpace(opts.Only) == stdinList
+	skip := strings.TrimSpace(opts.Skip) == stdinList
+	if !only && !skip {
+		return nil
+	}
+	if only && skip {
+		return fmt.Errorf("--only - and --skip - can't both read stdin")
+	}
+	names, err := parseAgentsFile(stdin)
 	if err != nil {
-		return false
+		return fmt.Errorf("read stdin: %w", err)
 	}
-	return (stat.Mode() & os.ModeCharDevice) != 0
+	if skip {
+		opts.Skip = strings.Join(names, ",")
+		return nil
+	}
+	if len(names) == 0 {
+		return errNoStdinAgents
+	}
+	opts.Only = strings.Join(names, ",")
+	return nil
+}
+
+func parseAgentsFile(r io.Reader) ([]string, error) {
+	names := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			names = append(names, field)
+		}
+	}
+	return names, scanner.Err()
+}
+
+func joinList(raw string, extra []string) string {
+	parts := []string{}
+	if strings.TrimSpace(raw) != "" {
+		parts = append(parts, raw)
+	}
+	parts = append(parts, extra...)
+	return strings.Join(parts, ",")
+}
+
+// splitList returns the non-empty, lowercased, comma-separated entries of raw in order.
+func splitList(raw string) []string {
+	items := []string{}
+	for _, part := range strings.Split(raw, ",") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if name != "" {
+			items = append(items, name)
+		}
+	}
+	return items
+}
+
+func parseList(raw string) map[string]bool {
+	items := map[string]bool{}
+	for _, name := range splitList(raw) {
+		items[name] = true
+	}
+	return items
+}
+
+func shouldShowUI(opts options) bool {
+	if opts.Quiet || opts.BeforeAfterOnly || opts.ErrorsOnly || isMachineFormat(opts.Format) {
+		return false
+	}
+	if opts.GuardMajor && !opts.AllowMajor && !opts.DryRun && isTTY(os.Stdin) {
+		// Major-upgrade prompts need the terminal before any update starts.
+		return false
+	}
+	if opts.gate != nil && (opts.CleanReinstall || opts.Rollback || shouldInstallMissing(opts)) {
+		// Confirmations can come up mid-run and would be drawn over by the dashboard.
+		return false
+	}
+	if !isTTY(os.Stdout) {
+		return false
+	}
+	return true
+}
+
+func isTTY(file *os.File) bool {
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return (stat.Mode() & os.ModeCharDevice) != 0
+}
+
+func runAll(ctx context.Context, selected []agents.Agent, env *envState, opts options, uiEnabled bool) []result {
+	if uiEnabled {
+		return runAllWithUI(ctx, selected, env, opts)
+	}
+	if shouldStreamResults(opts) || shouldPrintProgress(opts) {
+		return runAllWithStream(ctx, selected, env, opts)
+	}
+	return runAllWithEvents(ctx, selected, env, opts, nil)
+}
+
+func shouldStreamResults(opts options) bool {
+	return !opts.Quiet && !opts.BeforeAfterOnly && !isMachineFormat(opts.Format)
+}
+
+func shouldPrintProgress(opts options) bool {
+	return opts.Progress && !opts.Quiet
+}
+
+const progressInterval = 30 * time.Second
+
+// runAllWithStream prints each agent's result line as soon as it finishes (non-TTY mode), and with
+// --progress a periodic status line on stderr so CI logs don't look hung.
+func runAllWithStream(ctx context.Context, selected []agents.Agent, env *envState, opts options) []result {
+	events := make(chan updateEvent, len(selected)*4)
+	done := make(chan struct{})
+	progress := progressTracker{total: len(selected), start: time.Now()}
+	go func() {
+		defer close(done)
+		var tick <-chan time.Time
+		if shouldPrintProgress(opts) {
+			ticker := time.NewTicker(progressInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				progress.apply(ev)
+				if ev.Phase == phaseFinish && shouldStreamResults(opts) && (!opts.ErrorsOnly || ev.Result.Status == statusFailed) {
+					printResult(ev.Result, opts)
+				}
+			case <-tick:
+				fmt.Fprintln(os.Stderr, progress.line(time.Now()))
+			}
+		}
+	}()
+	results := runAllWithEvents(ctx, selected, env, opts, events)
+	close(events)
+	<-done
+	return results
+}
+
+type progressTracker struct {
+	total   int
+	done    int
+	running map[int]bool
+	start   time.Time
+}
+
+func (p *progressTracker) apply(ev updateEvent) {
+	switch ev.Phase {
+	case phaseStart:
+		if p.running == nil {
+			p.running = map[int]bool{}
+		}
+		p.running[ev.Index] = true
+	case phaseFinish:
+		p.done++
+		delete(p.running, ev.Index)
+	}
+}
+
+func (p *progressTracker) line(now time.Time) string {
+	return fmt.Sprintf("uca: %d/%d done, %d in progress, %s elapsed", p.done, p.total, len(p.running), fmtElapsed(now.Sub(p.start)))
+}
+
+type agentWork struct {
+	agent           agents.Agent
+	index           int
+	show            bool
+	method          string
+	explain         string
+	reason          reasonCode
+	nodePackageName string
+	// nodeTag is the dist-tag to install ("" means latest).
+	nodeTag string
+	// updateCmd is the final command to run (may be a batch command).
+	updateCmd []string
+	// updateCmdSingle is the per-agent command (used for fallback when batch updates fail).
+	updateCmdSingle []string
+	// batched is set when updateCmd is shared with other agents.
+	batched bool
+	// install is set when the agent was missing and updateCmd installs it (--install-missing).
+	install bool
+	// timeout is the agent's update timeout (--timeout-agent override or --timeout).
+	timeout time.Duration
+	// reinstall is set when updateCmd was rewritten to force a reinstall (--reinstall).
+	reinstall bool
+	// fallbackCmd/fallbackMethod are the node update a native agent falls through to when its updater
+	// reports it can't self-update (e.g. `claude update` on an npm install).
+	fallbackCmd    []string
+	fallbackMethod string
+	// copies are other managers' installs of the agent (updated too with --update-all-copies).
+	copies []installCopy
+}
+
+type updateTask struct {
+	kind    string
+	cmd     []string
+	agents  []agentWork
+	timeout time.Duration
+}
+
+type managerLocker struct {
+	mu sync.Mutex
+	// locks are one-slot semaphores rather than mutexes, so a waiter can give up (--lock-timeout).
+	locks map[string]chan struct{}
+}
+
+func newManagerLocker() *managerLocker {
+	return &managerLocker{locks: map[string]chan struct{}{}}
+}
+
+// lockContext takes the lock for kind, or gives up when ctx is done; ok is false then, and the returned
+// func is a no-op.
+func (l *managerLocker) lockContext(ctx context.Context, kind string) (unlock func(), ok bool) {
+	if kind == "" {
+		return func() {}, true
+	}
+	l.mu.Lock()
+	sem, found := l.locks[kind]
+	if !found {
+		sem = make(chan struct{}, 1)
+		l.locks[kind] = sem
+	}
+	l.mu.Unlock()
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, true
+	case <-ctx.Done():
+		return func() {}, false
+	}
+}
+
+// taskLocks takes every lock task needs, in the order that rules out deadlocks: the manager kind, a
+// native updater's fallback manager, then conflict groups sorted. With --lock-timeout, waiting for all of
+// them together is bounded; on a timeout (or cancellation) nothing stays held and blocked names the lock
+// uca gave up on.
+func taskLocks(ctx context.Context, task updateTask, locker *managerLocker, timeout time.Duration) (unlock func(), blocked string) {
+	names := []string{}
+	if shouldLockKind(task.kind) {
+		names = append(names, task.kind)
+	}
+	if task.kind == agents.KindNative && len(task.agents) == 1 && shouldLockKind(task.agents[0].fallbackMethod) {
+		// A possible fall-through mutates node globals, so hold that manager's lock up front: taking it
+		// after the conflict groups below could deadlock against a node task waiting on the same group.
+		names = append(names, task.agents[0].fallbackMethod)
+	}
+	for _, group := range taskConflictGroups(task) {
+		names = append(names, conflictLockPrefix+group)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	held := []func(){}
+	release := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i]()
+		}
+	}
+	for _, name := range names {
+		unlockOne, ok := locker.lockContext(ctx, name)
+		if !ok {
+			release()
+			return func() {}, name
+		}
+		held = append(held, unlockOne)
+	}
+	return release, ""
+}
+
+// lockTimeoutHint explains a --lock-timeout skip; blocked is a manager kind or a prefixed conflict group.
+func lockTimeoutHint(blocked string, timeout time.Duration) string {
+	holder := fmt.Sprintf("another %s update", blocked)
+	if group, ok := strings.CutPrefix(blocked, conflictLockPrefix); ok {
+		holder = fmt.Sprintf("another agent in conflict group %q", group)
+	}
+	return fmt.Sprintf("%s was still running after --lock-timeout %s, so this one did not start; it may be hung. Rerun with --retry-failed once it finishes", holder, timeout)
+}
+
+// conflictLockPrefix keeps conflict-group names from colliding with manager kinds in managerLocker.
+const conflictLockPrefix = "group:"
+
+// taskConflictGroups returns the sorted, de-duplicated conflict groups of every agent in the task.
+func taskConflictGroups(task updateTask) []string {
+	seen := map[string]bool{}
+	groups := []string{}
+	for _, work := range task.agents {
+		for _, group := range work.agent.ConflictGroups {
+			group = strings.TrimSpace(group)
+			if group == "" || seen[group] {
+				continue
+			}
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func shouldLockKind(kind string) bool {
+	switch kind {
+	case agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun, agents.KindBrew, agents.KindPip, agents.KindUv, agents.KindVSCode, agents.KindAsdf:
+		return true
+	default:
+		return false
+	}
+}
+
+func isNodeKind(kind string) bool {
+	switch kind {
+	case agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun:
+		return true
+	default:
+		return false
+	}
+}
+
+// defaultSafeConcurrency keeps --safe parallel enough that independent managers don't wait on each other.
+const defaultSafeConcurrency = 2
+
+// networkLimiter is a semaphore over network-heavy tasks (--max-network). A nil limiter never blocks.
+type networkLimiter chan struct{}
+
+func newNetworkLimiter(n int) networkLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(networkLimiter, n)
+}
+
+// acquire takes a slot for a network-heavy task kind and returns its release; other kinds pass through.
+func (l networkLimiter) acquire(kind string) func() {
+	if l == nil || !isNetworkKind(kind) {
+		return func() {}
+	}
+	l <- struct{}{}
+	return func() { <-l }
+}
+
+// isNetworkKind reports whether a task kind mostly downloads packages. Native updaters and exec commands
+// are left out: their cost is usually local, and uca can't tell what they fetch.
+func isNetworkKind(kind string) bool {
+	switch kind {
+	case agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun, agents.KindBrew, agents.KindPip, agents.KindUv, agents.KindVSCode, agents.KindAsdf:
+		return true
+	default:
+		return false
+	}
+}
+
+// concurrencyAuto is --concurrency auto.
+const concurrencyAuto = -1
+
+// maxAutoNetwork caps the download-heavy updates --concurrency auto runs at once; past a few, parallel
+// installs mostly split the same bandwidth and contend for the managers' caches.
+const maxAutoNetwork = 4
+
+// autoNetworkSlots is how many download-heavy tasks --concurrency auto lets run together: half the CPUs,
+// between 2 and maxAutoNetwork.
+func autoNetworkSlots(cpus int) int {
+	return min(max(cpus/2, 2), maxAutoNetwork)
+}
+
+// autoConcurrency sizes the worker pool for --concurrency auto: every local task (native updaters, exec
+// commands) plus the network slots, and never more workers than CPUs (at least 2).
+func autoConcurrency(numTasks, networkTasks, cpus int) int {
+	n := min(networkTasks, autoNetworkSlots(cpus)) + numTasks - networkTasks
+	return max(min(n, max(cpus, 2)), 1)
+}
+
+// effectiveMaxNetwork is the --max-network cap, or the auto slots under --concurrency auto.
+func effectiveMaxNetwork(opts options) int {
+	if opts.MaxNetwork == 0 && opts.Concurrency == concurrencyAuto && !opts.Serial {
+		return autoNetworkSlots(runtime.NumCPU())
+	}
+	return opts.MaxNetwork
+}
+
+// effectiveConcurrency is the number of workers for numTasks tasks, networkTasks of them download-heavy.
+func effectiveConcurrency(opts options, numTasks, networkTasks int) int {
+	if opts.Serial {
+		return 1
+	}
+	if opts.Concurrency == concurrencyAuto {
+		return autoConcurrency(numTasks, networkTasks, runtime.NumCPU())
+	}
+	if opts.Safe && opts.Concurrency == 0 {
+		if opts.SafeConcurrency > 0 {
+			return opts.SafeConcurrency
+		}
+		return defaultSafeConcurrency
+	}
+	if opts.Concurrency > 0 {
+		return opts.Concurrency
+	}
+	if numTasks <= 0 {
+		return 1
+	}
+	return numTasks
+}
+
+// nodeBatchUpdateCommand builds one install command for pkgs. tags maps a package to a dist-tag; packages
+// without an entry use "latest".
+func nodeBatchUpdateCommand(kind string, pkgs []string, tags map[string]string) []string {
+	args := []string{}
+	switch kind {
+	case agents.KindNpm:
+		args = append(args, "npm", "install", "-g")
+	case agents.KindPnpm:
+		args = append(args, "pnpm", "add", "-g")
+	case agents.KindYarn:
+		args = append(args, "yarn", "global", "add")
+	case agents.KindBun:
+		args = append(args, "bun", "add", "-g")
+	default:
+		return nil
+	}
+	for _, pkg := range pkgs {
+		if strings.TrimSpace(pkg) == "" {
+			continue
+		}
+		args = append(args, pkg+"@"+distTagOrLatest(tags[pkg]))
+	}
+	return args
+}
+
+// reinstallCommand rewrites an update command so it reinstalls even when the version is current. ok is
+// false for methods with no such mode (native updaters, asdf, exec), which keep their command.
+func reinstallCommand(kind string, cmd []string) ([]string, bool) {
+	switch kind {
+	case agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun:
+		return appendMissingArg(cmd, "--force"), true
+	case agents.KindPip:
+		return appendMissingArg(cmd, "--force-reinstall"), true
+	case agents.KindBrew:
+		if len(cmd) == 3 && cmd[0] == "brew" && cmd[1] == "upgrade" {
+			return []string{"brew", "reinstall", cmd[2]}, true
+		}
+		return cmd, false
+	case agents.KindUv:
+		// `uv tool upgrade` becomes a forced reinstall; `uv tool install --force` already is one.
+		if pkg, ok := uvToolUpgradeTarget(cmd); ok {
+			return uvToolInstallCommand(pkg), true
+		}
+		return cmd, true
+	case agents.KindVSCode:
+		// `code --install-extension --force` already reinstalls.
+		return cmd, true
+	default:
+		return cmd, false
+	}
+}
+
+// withLegacyPeerDeps adds --legacy-peer-deps to an npm command (--legacy-peer-deps); other commands are
+// returned unchanged.
+func withLegacyPeerDeps(cmd []string) []string {
+	if len(cmd) == 0 || cmd[0] != "npm" {
+		return cmd
+	}
+	return appendMissingArg(cmd, "--legacy-peer-deps")
+}
+
+// npmFastFlags are the npm flags --fast adds: no funding notices, no advisory request to the registry.
+var npmFastFlags = []string{"--no-fund", "--no-audit"}
+
+// withNpmFlags applies the npm-only flags (--legacy-peer-deps, --fast) to cmd.
+func withNpmFlags(cmd []string, opts options) []string {
+	if opts.LegacyPeerDeps {
+		cmd = withLegacyPeerDeps(cmd)
+	}
+	if opts.Fast && isNpmGlobalMutate(cmd) {
+		for _, arg := range npmFastFlags {
+			cmd = appendMissingArg(cmd, arg)
+		}
+	}
+	return cmd
+}
+
+// rejectedNpmFastFlags reports whether a failed npm install may be down to the --fast flags, so the caller
+// reruns it without them. npm doesn't reject flags it doesn't know: it warns (`npm WARN Unknown cli config
+// "--no-fund"`) and goes on, so only a non-zero exit with that warning in the output counts.
+func rejectedNpmFastFlags(args []string, exitCode int, output string) bool {
+	if exitCode == 0 || exitCode == exitCodeTimeout || exitCode == exitCodeCanceled {
+		return false
+	}
+	if !isNpmGlobalMutate(args) || !hasAnyArg(args, npmFastFlags) {
+		return false
+	}
+	for _, line := range strings.Split(strings.ToLower(output), "\n") {
+		if strings.Contains(line, "warn") && strings.Contains(line, "unknown") && strings.Contains(line, "config") &&
+			(strings.Contains(line, "fund") || strings.Contains(line, "audit")) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyArg(cmd []string, args []string) bool {
+	for _, existing := range cmd {
+		for _, arg := range args {
+			if existing == arg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func withoutArgs(cmd []string, args []string) []string {
+	out := make([]string, 0, len(cmd))
+	for _, existing := range cmd {
+		if !hasAnyArg([]string{existing}, args) {
+			out = append(out, existing)
+		}
+	}
+	return out
+}
+
+func appendMissingArg(cmd []string, arg string) []string {
+	for _, existing := range cmd {
+		if existing == arg {
+			return cmd
+		}
+	}
+	return append(append([]string{}, cmd...), arg)
+}
+
+// markReinstalled reports a forced reinstall that kept the same version as reinstalled, not unchanged.
+func markReinstalled(res *result, work agentWork) {
+	if work.reinstall && res.Status == statusUnchanged {
+		res.Status = statusUpdated
+		res.ReasonCode = codeReinstalled
+	}
+}
+
+func distTagOrLatest(tag string) string {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return "latest"
+	}
+	return tag
+}
+
+// chunkStrings splits items into consecutive chunks of at most size items. size <= 0 means one chunk.
+func chunkStrings(items []string, size int) [][]string {
+	if len(items) == 0 {
+		return nil
+	}
+	if size <= 0 || size >= len(items) {
+		return [][]string{items}
+	}
+	chunks := make([][]string, 0, (len(items)+size-1)/size)
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		chunks = append(chunks, items[start:end])
+	}
+	return chunks
+}
+
+// skipCurrentAgents drops the update command of agents whose installed version already matches the
+// latest one (--only-outdated). Agents whose manager has no latest-version query keep their update.
+// Lookups run concurrently since each one is a network round trip.
+func skipCurrentAgents(ctx context.Context, env *envState, works []agentWork) {
+	var wg sync.WaitGroup
+	for i := range works {
+		work := &works[i]
+		if work.updateCmdSingle == nil || work.install {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latest := latestVersion(ctx, env, *work)
+			if latest == "" {
+				return
+			}
+			installed := getVersion(ctx, work.agent, env, work.method)
+			if !sameVersionToken(installed, latest) {
+				return
+			}
+			work.updateCmdSingle = nil
+			work.reason = codeCurrent
+			work.explain = appendHint(work.explain, fmt.Sprintf("already at latest %s; skipped by --only-outdated", latest))
+		}()
+	}
+	wg.Wait()
+}
+
+// skipDuplicateAgents coalesces selected agents that resolved to the same install: the same method and
+// update command, e.g. two config entries for one npm package. The first keeps the update; the others
+// are skipped as "duplicate" so the install isn't version-checked, updated, and reported twice.
+func skipDuplicateAgents(works []agentWork) {
+	first := map[string]string{}
+	for i := range works {
+		work := &works[i]
+		if work.updateCmdSingle == nil {
+			continue
+		}
+		key := work.method + "\x00" + cmdString(work.updateCmdSingle)
+		owner, ok := first[key]
+		if !ok {
+			first[key] = work.agent.Name
+			continue
+		}
+		work.explain = appendHint(work.explain, fmt.Sprintf("same install as %s (`%s`); see its result", owner, cmdString(work.updateCmdSingle)))
+		work.updateCmdSingle = nil
+		work.reason = codeDuplicate
+	}
+}
+
+// guardMajorUpgrades holds back agents whose latest version has a higher major than the installed one
+// (--guard-major). ask confirms each one interactively; nil (no TTY, dry-run) skips them all.
+func guardMajorUpgrades(ctx context.Context, env *envState, works []agentWork, ask func(string) bool) {
+	type upgrade struct{ installed, latest string }
+	found := make([]*upgrade, len(works))
+	var wg sync.WaitGroup
+	for i := range works {
+		work := works[i]
+		if work.updateCmdSingle == nil || work.install {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			latest := latestVersion(ctx, env, work)
+			if latest == "" {
+				return
+			}
+			installed := getVersion(ctx, work.agent, env, work.method)
+			if isMajorUpgrade(installed, latest) {
+				found[i] = &upgrade{installed: installed, latest: latest}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Prompt in agent order, after every lookup, so questions don't interleave.
+	for i, up := range found {
+		if up == nil {
+			continue
+		}
+		work := &works[i]
+		question := fmt.Sprintf("%s: %s -> %s is a major upgrade. Update anyway?", work.agent.Name, up.installed, up.latest)
+		if ask != nil && ask(question) {
+			continue
+		}
+		work.updateCmdSingle = nil
+		work.reason = codeMajorUpgrade
+		work.explain = appendHint(work.explain, fmt.Sprintf("%s -> %s is a major upgrade; rerun with --allow-major to apply it", up.installed, up.latest))
+	}
+}
+
+// isMajorUpgrade reports whether latest's major version is higher than installed's.
+func isMajorUpgrade(installed, latest string) bool {
+	a, okA := versionMajor(installed)
+	b, okB := versionMajor(latest)
+	return okA && okB && b > a
+}
+
+// compareVersions orders the first version tokens of a and b by their numeric parts, then by prerelease
+// (a release sorts after its prereleases). ok is false when either side has no token.
+func compareVersions(a, b string) (cmp int, ok bool) {
+	ta, okA := extractVersionToken(a)
+	tb, okB := extractVersionToken(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	coreA, preA, okA := splitVersionToken(ta)
+	coreB, preB, okB := splitVersionToken(tb)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := 0; i < max(len(coreA), len(coreB)); i++ {
+		var x, y int
+		if i < len(coreA) {
+			x = coreA[i]
+		}
+		if i < len(coreB) {
+			y = coreB[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	switch {
+	case preA == preB:
+		return 0, true
+	case preA == "":
+		return 1, true
+	case preB == "":
+		return -1, true
+	default:
+		return comparePrerelease(preA, preB), true
+	}
+}
+
+// comparePrerelease orders two prerelease strings the way semver does: identifier by identifier,
+// numerically when both are numbers, numbers before words, and a shorter list first when it is a prefix.
+func comparePrerelease(a, b string) int {
+	idsA, idsB := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < min(len(idsA), len(idsB)); i++ {
+		x, y := idsA[i], idsB[i]
+		nx, errX := strconv.Atoi(x)
+		ny, errY := strconv.Atoi(y)
+		switch {
+		case errX == nil && errY == nil:
+			if nx != ny {
+				if nx < ny {
+					return -1
+				}
+				return 1
+			}
+		case errX == nil:
+			return -1
+		case errY == nil:
+			return 1
+		default:
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(idsA) < len(idsB):
+		return -1
+	case len(idsA) > len(idsB):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// splitVersionToken splits "v1.2.3-beta.1+build" into [1 2 3] and "beta.1"; build metadata is dropped.
+// ok is false when a core part isn't a number.
+func splitVersionToken(token string) (core []int, pre string, ok bool) {
+	token = strings.TrimPrefix(strings.ToLower(token), "v")
+	token, _, _ = strings.Cut(token, "+")
+	coreStr, pre, _ := strings.Cut(token, "-")
+	for _, part := range strings.Split(coreStr, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, "", false
+		}
+		core = append(core, n)
+	}
+	return core, pre, true
+}
+
+// versionCore returns the numeric parts of the first version token in s.
+func versionCore(s string) ([]int, bool) {
+	token, ok := extractVersionToken(s)
+	if !ok {
+		return nil, false
+	}
+	core, _, ok := splitVersionToken(token)
+	return core, ok
+}
+
+func versionMajor(s string) (int, bool) {
+	core, ok := versionCore(s)
+	if !ok {
+		return 0, false
+	}
+	return core[0], true
+}
+
+// confirm asks a yes/no question on out and reads the answer from in. Anything but y/yes is no.
+func confirm(in *bufio.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// confirmGate is the single place destructive actions (clean reinstall, bun's remove and add of a stale
+// global, rollback, installing a missing agent, a major upgrade) ask for confirmation. A nil gate proceeds
+// without asking: --assume-yes, --dry-run, or no TTY to ask on.
+type confirmGate struct {
+	// mu serializes questions from concurrent tasks so prompts and answers don't interleave.
+	mu  sync.Mutex
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// newConfirmGate returns the gate for a run, or nil when uca must not (or cannot) ask.
+func newConfirmGate(opts options) *confirmGate {
+	if opts.AssumeYes || opts.DryRun || !isTTY(os.Stdin) || !isTTY(os.Stderr) {
+		return nil
+	}
+	return &confirmGate{in: bufio.NewReader(os.Stdin), out: os.Stderr}
 }
 
-func runAll(ctx context.Context, selected []agents.Agent, env *envState, opts options, uiEnabled bool) []result {
-	if uiEnabled {
-		return runAllWithUI(ctx, selected, env, opts)
+// allow asks question and reports whether the action may go ahead.
+func (g *confirmGate) allow(question string) bool {
+	if g == nil {
+		return true
 	}
-	return runAllWithEvents(ctx, selected, env, opts, nil)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return confirm(g.in, g.out, question)
 }
 
-type agentWork struct {
-	agent           agents.Agent
-	index           int
-	show            bool
-	method          string
-	explain         string
-	reason          string
-	nodePackageName string
-	// updateCmd is the final command to run (may be a batch command).
-	updateCmd []string
-	// updateCmdSingle is the per-agent command (used for fallback when batch updates fail).
-	updateCmdSingle []string
+// latestVersion returns the newest available version for the agent's resolved method, or "" when the
+// manager has no latest-version query or the query failed.
+func latestVersion(ctx context.Context, env *envState, work agentWork) string {
+	switch {
+	case isNodeKind(work.method):
+		return nodeLatestVersion(ctx, env.commands(), work.method, work.nodePackageName, work.nodeTag)
+	case work.method == agents.KindVSCode:
+		return marketplaceLatestVersion(ctx, work.agent.ExtensionID)
+	case work.method == agents.KindBrew:
+		return brewLatestVersion(ctx, env.commands(), strategyPackage(work.agent, agents.KindBrew))
+	case work.method == agents.KindPip, work.method == agents.KindUv:
+		return pypiLatestVersion(ctx, strategyPackage(work.agent, work.method))
+	}
+	return ""
 }
 
-type updateTask struct {
-	kind   string
-	cmd    []string
-	agents []agentWork
+// hasLatestQuery reports whether latestVersion can look up a target version for the method. Native
+// updaters, asdf, and exec strategies decide their own target.
+func hasLatestQuery(method string) bool {
+	switch method {
+	case agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun, agents.KindVSCode, agents.KindBrew, agents.KindPip, agents.KindUv:
+		return true
+	default:
+		return false
+	}
 }
 
-type managerLocker struct {
-	mu    sync.Mutex
-	locks map[string]*sync.Mutex
+func strategyPackage(agent agents.Agent, kind string) string {
+	strat, _ := strategyFor(agent, kind)
+	return strat.Package
 }
 
-func newManagerLocker() *managerLocker {
-	return &managerLocker{locks: map[string]*sync.Mutex{}}
+// strategyFor returns the agent's first strategy of kind.
+func strategyFor(agent agents.Agent, kind string) (agents.UpdateStrategy, bool) {
+	for _, strat := range agent.Strategies {
+		if strat.Kind == kind {
+			return strat, true
+		}
+	}
+	return agents.UpdateStrategy{}, false
 }
 
-func (l *managerLocker) lock(kind string) func() {
-	if kind == "" {
-		return func() {}
+// pypiJSONURL is the PyPI JSON API; %s is the project name.
+var pypiJSONURL = "https://pypi.org/pypi/%s/json"
+
+// pypiLatestVersion returns the latest release of a PyPI project (used for pip and uv tools), or "".
+func pypiLatestVersion(ctx context.Context, pkg string) string {
+	pkg = strings.TrimSpace(pkg)
+	if pkg == "" {
+		return ""
 	}
-	l.mu.Lock()
-	m, ok := l.locks[kind]
-	if !ok {
-		m = &sync.Mutex{}
-		l.locks[kind] = m
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	l.mu.Unlock()
-	m.Lock()
-	return func() { m.Unlock() }
+	ctx, cancel := context.WithTimeout(ctx, latestVersionCmdTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(pypiJSONURL, url.PathEscape(pkg)), nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	var payload struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return ""
+	}
+	return payload.Info.Version
 }
 
-func shouldLockKind(kind string) bool {
-	switch kind {
-	case agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun, agents.KindBrew, agents.KindPip, agents.KindUv, agents.KindVSCode:
-		return true
-	default:
-		return false
+// brewLatestVersion reads a formula's stable version from `brew info --json=v2`, which uses the local
+// index (see --refresh-first).
+func brewLatestVersion(ctx context.Context, runner commandRunner, formula string) string {
+	if strings.TrimSpace(formula) == "" {
+		return ""
+	}
+	out, exitCode, _, _ := runner.Output(ctx, []string{"brew", "info", "--json=v2", formula}, latestVersionCmdTimeout)
+	if exitCode != 0 {
+		return ""
+	}
+	var payload struct {
+		Formulae []struct {
+			Versions struct {
+				Stable string `json:"stable"`
+			} `json:"versions"`
+		} `json:"formulae"`
 	}
+	if err := json.Unmarshal([]byte(out), &payload); err != nil || len(payload.Formulae) == 0 {
+		return ""
+	}
+	return payload.Formulae[0].Versions.Stable
 }
 
-func isNodeKind(kind string) bool {
-	switch kind {
-	case agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun:
-		return true
-	default:
-		return false
+// buildTasks groups resolved work into update tasks, batching node updates by manager kind unless
+// --no-batch is set. It records the final command (and batching) on works.
+func buildTasks(works []agentWork, opts options) []updateTask {
+	tasks := []updateTask{}
+	nodeGroups := map[string][]int{}
+	// packageGroups batches brew and uv agents by command prefix (e.g. "brew upgrade"), which the members
+	// must share.
+	packageGroups := map[string][]int{}
+	for i := range works {
+		work := &works[i]
+		if work.updateCmdSingle == nil {
+			continue
+		}
+		if isNodeKind(work.method) && !opts.NoBatch {
+			nodeGroups[work.method] = append(nodeGroups[work.method], i)
+			continue
+		}
+		if prefix, _, ok := singlePackageCommand(work.method, work.updateCmdSingle); ok && !opts.NoBatch {
+			key := cmdString(prefix)
+			packageGroups[key] = append(packageGroups[key], i)
+			continue
+		}
+		work.updateCmd = work.updateCmdSingle
+		tasks = append(tasks, updateTask{kind: work.method, cmd: work.updateCmd, agents: []agentWork{*work}, timeout: work.timeout})
+	}
+	nodeKinds := make([]string, 0, len(nodeGroups))
+	for kind := range nodeGroups {
+		nodeKinds = append(nodeKinds, kind)
 	}
+	sort.Strings(nodeKinds)
+	for _, kind := range nodeKinds {
+		indexes := nodeGroups[kind]
+		tags := map[string]string{}
+		batchIndexes := make([]int, 0, len(indexes))
+		for _, idx := range indexes {
+			pkg := strings.TrimSpace(works[idx].nodePackageName)
+			if pkg == "" {
+				works[idx].updateCmd = works[idx].updateCmdSingle
+				tasks = append(tasks, updateTask{kind: kind, cmd: works[idx].updateCmd, agents: []agentWork{works[idx]}, timeout: works[idx].timeout})
+				continue
+			}
+			if works[idx].nodeTag != "" {
+				tags[pkg] = works[idx].nodeTag
+			}
+			batchIndexes = append(batchIndexes, idx)
+		}
+		pkgOf := func(work agentWork) string { return strings.TrimSpace(work.nodePackageName) }
+		tasks = appendBatchTasks(tasks, works, kind, batchIndexes, pkgOf, opts.BatchSize, func(chunk []string) []string {
+			cmd := nodeBatchUpdateCommand(kind, chunk, tags)
+			if opts.Reinstall {
+				cmd, _ = reinstallCommand(kind, cmd)
+			}
+			return withNpmFlags(cmd, opts)
+		})
+	}
+	keys := make([]string, 0, len(packageGroups))
+	for key := range packageGroups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pkgOf := func(work agentWork) string {
+		_, pkg, _ := singlePackageCommand(work.method, work.updateCmdSingle)
+		return pkg
+	}
+	for _, key := range keys {
+		first := works[packageGroups[key][0]]
+		prefix, _, _ := singlePackageCommand(first.method, first.updateCmdSingle)
+		tasks = appendBatchTasks(tasks, works, first.method, packageGroups[key], pkgOf, opts.BatchSize, func(chunk []string) []string {
+			return append(append([]string{}, prefix...), chunk...)
+		})
+	}
+	return tasks
 }
 
-func effectiveConcurrency(opts options, numTasks int) int {
-	if opts.Serial {
-		return 1
-	}
-	if opts.Safe && opts.Concurrency == 0 {
-		return 1
+// appendBatchTasks groups the works at indexes into shared update commands, at most batchSize packages
+// each, and notes the batch in every member's --explain. command builds a chunk's command from its
+// sorted package names.
+func appendBatchTasks(tasks []updateTask, works []agentWork, kind string, indexes []int, pkgOf func(agentWork) string, batchSize int, command func(chunk []string) []string) []updateTask {
+	if len(indexes) == 0 {
+		return tasks
+	}
+	pkgSet := map[string]bool{}
+	pkgs := make([]string, 0, len(indexes))
+	for _, idx := range indexes {
+		if pkg := pkgOf(works[idx]); !pkgSet[pkg] {
+			pkgSet[pkg] = true
+			pkgs = append(pkgs, pkg)
+		}
 	}
-	if opts.Concurrency > 0 {
-		return opts.Concurrency
+	sort.Strings(pkgs)
+	// Split large batches so a stuck package only blocks its own chunk.
+	for _, chunk := range chunkStrings(pkgs, batchSize) {
+		inChunk := make(map[string]bool, len(chunk))
+		for _, pkg := range chunk {
+			inChunk[pkg] = true
+		}
+		cmd := command(chunk)
+		group := make([]agentWork, 0, len(chunk))
+		for _, idx := range indexes {
+			if !inChunk[pkgOf(works[idx])] {
+				continue
+			}
+			works[idx].updateCmd = cmd
+			group = append(group, works[idx])
+		}
+		if len(group) > 1 {
+			note := fmt.Sprintf("batched with %d packages: %s", len(chunk), strings.Join(chunk, " "))
+			for i := range group {
+				group[i].batched = true
+				group[i].explain = appendNote(group[i].explain, note)
+				works[group[i].index].batched = true
+				works[group[i].index].explain = group[i].explain
+			}
+		}
+		tasks = append(tasks, updateTask{kind: kind, cmd: cmd, agents: group, timeout: taskTimeout(group)})
 	}
-	if numTasks <= 0 {
-		return 1
+	return tasks
+}
+
+// singlePackageCommand splits a brew or uv command that takes more names after a fixed prefix
+// (`brew upgrade gh`, `uv tool upgrade aider-chat`) into that prefix and its one package. ok is false
+// for anything else, which runs on its own.
+func singlePackageCommand(kind string, cmd []string) (prefix []string, pkg string, ok bool) {
+	switch {
+	case kind == agents.KindBrew && len(cmd) == 3 && cmd[0] == "brew":
+		switch cmd[1] {
+		case "upgrade", "reinstall", "install":
+			return cmd[:2], cmd[2], true
+		}
+	case kind == agents.KindUv:
+		if pkg, ok := uvToolUpgradeTarget(cmd); ok {
+			return cmd[:3], pkg, true
+		}
 	}
-	return numTasks
+	return nil, "", false
 }
 
-func nodeBatchUpdateCommand(kind string, pkgs []string) []string {
-	args := []string{}
-	switch kind {
-	case agents.KindNpm:
-		args = append(args, "npm", "install", "-g")
-	case agents.KindPnpm:
-		args = append(args, "pnpm", "add", "-g")
-	case agents.KindYarn:
-		args = append(args, "yarn", "global", "add")
-	case agents.KindBun:
-		args = append(args, "bun", "add", "-g")
-	default:
-		return nil
+// isBatchKind reports whether a task of this kind may update several agents with one command.
+func isBatchKind(kind string) bool {
+	return isNodeKind(kind) || kind == agents.KindBrew || kind == agents.KindUv
+}
+
+// uvToolUpgradeTarget returns the tool name when cmd is `uv tool upgrade <one name>`.
+func uvToolUpgradeTarget(cmd []string) (string, bool) {
+	if len(cmd) != 4 || cmd[0] != "uv" || cmd[1] != "tool" || cmd[2] != "upgrade" || strings.HasPrefix(cmd[3], "-") {
+		return "", false
 	}
-	for _, pkg := range pkgs {
-		if strings.TrimSpace(pkg) == "" {
+	return cmd[3], true
+}
+
+// useUvUpgradeAll turns a uv batch that covers every installed uv tool into `uv tool upgrade --all`, which
+// upgrades exactly the same tools. Batches that leave some tool out keep naming their tools.
+func useUvUpgradeAll(tasks []updateTask, works []agentWork, env *envState) {
+	installed := env.uvToolList()
+	for i := range tasks {
+		task := &tasks[i]
+		if task.kind != agents.KindUv || len(task.agents) < 2 || len(task.cmd) < 4 || cmdString(task.cmd[:3]) != "uv tool upgrade" {
+			continue
+		}
+		covered := map[string]bool{}
+		for _, name := range task.cmd[3:] {
+			covered[name] = true
+		}
+		all := len(installed) > 0
+		for name := range installed {
+			if !covered[name] {
+				all = false
+				break
+			}
+		}
+		if !all {
 			continue
 		}
-		args = append(args, pkg+"@latest")
+		task.cmd = []string{"uv", "tool", "upgrade", "--all"}
+		for j := range task.agents {
+			task.agents[j].updateCmd = task.cmd
+			task.agents[j].explain = appendNote(task.agents[j].explain, "every installed uv tool is selected; using `uv tool upgrade --all`")
+			works[task.agents[j].index].updateCmd = task.cmd
+			works[task.agents[j].index].explain = task.agents[j].explain
+		}
 	}
-	return args
 }
 
 func runAllWithEvents(ctx context.Context, selected []agents.Agent, env *envState, opts options, events chan<- updateEvent) []result {
@@ -348,7 +2361,27 @@ func runAllWithEvents(ctx context.Context, selected []agents.Agent, env *envStat
 
 	for i, agent := range selected {
 		updateCmd, reason, method, detail := resolveUpdate(agent, env)
-		show := updateCmd != nil || reason == reasonManualInstall
+		install := false
+		if reason == codeMissing && shouldInstallMissing(opts) {
+			if cmd, kind, installDetail := installCommand(agent, env); cmd != nil {
+				if opts.gate.allow(fmt.Sprintf("%s is not installed. Install it with `%s`?", agent.Name, cmdString(cmd))) {
+					updateCmd, reason, method, detail = cmd, "", kind, installDetail
+					install = true
+				} else {
+					detail = appendNote(detail, "install declined")
+				}
+			}
+		}
+		if reason == codeBrokenInstall && opts.Reinstall {
+			// The package is still listed but its bin link is gone; the forced reinstall below recreates it.
+			if strat, ok := strategyFor(agent, method); ok {
+				updateCmd, reason = nodeUpdateCommand(strat), ""
+			}
+		}
+		show := updateCmd != nil || reason == codeManualInstall || reason == codeBrokenInstall || reason == codeDetectTimeout
+		if opts.Explain {
+			detail = withLastUpdated(detail, opts.lastUpdated, agent.Name, time.Now())
+		}
 		work := agentWork{
 			agent:           agent,
 			index:           i,
@@ -357,58 +2390,56 @@ func runAllWithEvents(ctx context.Context, selected []agents.Agent, env *envStat
 			explain:         detail,
 			reason:          reason,
 			updateCmdSingle: updateCmd,
+			install:         install,
+			timeout:         agentTimeout(opts, agent.Name),
 		}
 		if isNodeKind(method) {
 			work.nodePackageName = nodePackageName(agent.Strategies)
+			work.nodeTag = nodePackageTag(agent.Strategies)
 		}
-		works[i] = work
-	}
-
-	// Build tasks (batch node updates by manager kind).
-	tasks := []updateTask{}
-	nodeGroups := map[string][]int{}
-	for i := range works {
-		work := &works[i]
-		if work.updateCmdSingle == nil {
-			continue
+		if opts.Reinstall && updateCmd != nil && !install {
+			if forced, ok := reinstallCommand(method, updateCmd); ok {
+				work.updateCmdSingle = forced
+				work.reinstall = true
+			} else {
+				work.explain = appendNote(work.explain, fmt.Sprintf("%s has no forced reinstall; running the normal update", method))
+			}
 		}
-		if isNodeKind(work.method) {
-			nodeGroups[work.method] = append(nodeGroups[work.method], i)
-			continue
+		if method == agents.KindNative && !install {
+			work.fallbackCmd, work.fallbackMethod = nativeFallback(agent, env)
+			work.fallbackCmd = withNpmFlags(work.fallbackCmd, opts)
+			if work.fallbackCmd != nil {
+				work.explain = appendNote(work.explain, fmt.Sprintf("falls back to `%s` if the built-in updater can't self-update", cmdString(work.fallbackCmd)))
+			}
 		}
-		work.updateCmd = work.updateCmdSingle
-		tasks = append(tasks, updateTask{kind: work.method, cmd: work.updateCmd, agents: []agentWork{*work}})
-	}
-	for kind, indexes := range nodeGroups {
-		pkgSet := map[string]bool{}
-		pkgs := make([]string, 0, len(indexes))
-		batchIndexes := make([]int, 0, len(indexes))
-		for _, idx := range indexes {
-			pkg := strings.TrimSpace(works[idx].nodePackageName)
-			if pkg == "" {
-				works[idx].updateCmd = works[idx].updateCmdSingle
-				tasks = append(tasks, updateTask{kind: kind, cmd: works[idx].updateCmd, agents: []agentWork{works[idx]}})
-				continue
+		if updateCmd != nil && !install {
+			work.copies = env.otherInstalls(agent, method)
+			for j := range work.copies {
+				work.copies[j].cmd = withNpmFlags(work.copies[j].cmd, opts)
 			}
-			if !pkgSet[pkg] {
-				pkgSet[pkg] = true
-				pkgs = append(pkgs, pkg)
+			if len(work.copies) > 0 {
+				work.explain = appendNote(work.explain, otherInstallsNote(work.copies, opts.UpdateAllCopies))
 			}
-			batchIndexes = append(batchIndexes, idx)
-		}
-		if len(batchIndexes) == 0 {
-			continue
 		}
-		sort.Strings(pkgs)
-		cmd := nodeBatchUpdateCommand(kind, pkgs)
-		group := make([]agentWork, 0, len(indexes))
-		for _, idx := range batchIndexes {
-			works[idx].updateCmd = cmd
-			group = append(group, works[idx])
+		work.updateCmdSingle = withNpmFlags(work.updateCmdSingle, opts)
+		works[i] = work
+	}
+	skipDuplicateAgents(works)
+	if opts.OnlyOutdated {
+		skipCurrentAgents(ctx, env, works)
+	}
+	if opts.GuardMajor && !opts.AllowMajor && !opts.AssumeYes {
+		// Without a TTY (nil gate) --guard-major still skips major upgrades; that is what it is for.
+		var ask func(string) bool
+		if opts.gate != nil {
+			ask = opts.gate.allow
 		}
-		tasks = append(tasks, updateTask{kind: kind, cmd: cmd, agents: group})
+		guardMajorUpgrades(ctx, env, works, ask)
 	}
 
+	tasks := buildTasks(works, opts)
+	useUvUpgradeAll(tasks, works, env)
+
 	// Emit detect events and handle skipped/dry-run results.
 	now := time.Now()
 	for _, work := range works {
@@ -417,14 +2448,15 @@ func runAllWithEvents(ctx context.Context, selected []agents.Agent, env *envStat
 			Method:    work.method,
 			Explain:   work.explain,
 			UpdateCmd: cmdString(work.updateCmd),
+			Batched:   work.batched,
 		}
 
 		if work.updateCmdSingle == nil {
 			res.Status = statusSkipped
 			if work.reason == "" {
-				res.Reason = reasonMissing
+				res.ReasonCode = codeMissing
 			} else {
-				res.Reason = work.reason
+				res.ReasonCode = work.reason
 			}
 			results[work.index] = res
 			if events != nil {
@@ -441,11 +2473,18 @@ func runAllWithEvents(ctx context.Context, selected []agents.Agent, env *envStat
 			}
 
 			res.Status = statusUpdated
-			res.Reason = "dry-run"
+			res.ReasonCode = codeDryRun
+			if reason := dryRunCommandProblem(env, work.updateCmd); reason != "" {
+				// The preview would not actually run; say so, but a dry run still changes nothing and does not fail.
+				res.ReasonCode, res.ReasonDetail = codeWouldFail, reason
+				res.Explain = appendHint(res.Explain, fmt.Sprintf("%s is not on PATH; install it or fix the configured command", work.updateCmd[0]))
+			}
 			res.Before = getVersion(ctx, work.agent, env, work.method)
 			res.After = res.Before
-			if isNodeKind(work.method) {
-				if latest := nodeLatestVersion(ctx, work.method, work.nodePackageName); latest != "" {
+			if hasLatestQuery(work.method) {
+				// A failed lookup (offline, proxy) means the target is unknown, not that the agent is current.
+				res.After = "unknown"
+				if latest := latestVersion(ctx, env, work); latest != "" {
 					if formatted := formatVersionWithToken(res.Before, latest); formatted != "" {
 						res.After = formatted
 					} else {
@@ -466,13 +2505,30 @@ func runAllWithEvents(ctx context.Context, selected []agents.Agent, env *envStat
 	}
 
 	if opts.DryRun {
+		updateOtherCopies(ctx, env, works, results, opts)
 		return results
 	}
 
+	var refreshes map[string]managerRefresh
+	if opts.RefreshFirst {
+		refreshes = refreshManagers(ctx, env, tasks, opts.Timeout)
+	}
+
 	locker := newManagerLocker()
+	network := newNetworkLimiter(effectiveMaxNetwork(opts))
+	var prof *profiler
+	if opts.Profile != "" {
+		prof = newProfiler()
+	}
 	taskCh := make(chan updateTask)
 	var wg sync.WaitGroup
-	workerCount := effectiveConcurrency(opts, len(tasks))
+	networkTasks := 0
+	for _, task := range tasks {
+		if isNetworkKind(task.kind) {
+			networkTasks++
+		}
+	}
+	workerCount := effectiveConcurrency(opts, len(tasks), networkTasks)
 	if workerCount > len(tasks) {
 		workerCount = len(tasks)
 	}
@@ -481,44 +2537,207 @@ func runAllWithEvents(ctx context.Context, selected []agents.Agent, env *envStat
 	}
 	wg.Add(workerCount)
 	for i := 0; i < workerCount; i++ {
-		go func() {
+		go func(worker int) {
+			defer restoreTerminalOnPanic()
 			defer wg.Done()
 			for task := range taskCh {
-				runTask(ctx, task, env, opts, locker, events, results)
+				start := time.Now()
+				release := network.acquire(task.kind)
+				networkWait := time.Since(start)
+				lockWait := runTask(ctx, task, env, opts, locker, events, results)
+				release()
+				prof.record(worker, task, start, time.Now(), networkWait, lockWait)
 			}
-		}()
+		}(i + 1)
 	}
 	for _, task := range tasks {
 		taskCh <- task
 	}
 	close(taskCh)
 	wg.Wait()
+	if err := prof.write(opts.Profile, workerCount); err != nil {
+		fmt.Fprintf(os.Stderr, "uca: warning: %v\n", err)
+	}
 
+	attachRefreshLogs(results, refreshes)
+	updateOtherCopies(ctx, env, works, results, opts)
 	return results
 }
 
-func runTask(ctx context.Context, task updateTask, env *envState, opts options, locker *managerLocker, events chan<- updateEvent, results []result) {
+// managerRefresh is the outcome of a --refresh-first index update for one manager.
+type managerRefresh struct {
+	cmd      []string
+	out      string
+	exitCode int
+}
+
+// refreshCommand returns the command that refreshes a manager's local package index, or nil when the
+// manager always queries its registry live (npm, pnpm, yarn, bun, uv, pip).
+func refreshCommand(kind string) []string {
+	switch kind {
+	case agents.KindBrew:
+		return []string{"brew", "update"}
+	case agents.KindAsdf:
+		return []string{"asdf", "plugin", "update", "--all"}
+	default:
+		return nil
+	}
+}
+
+// refreshManagers runs each needed refresh command once, in task order, before any update starts.
+func refreshManagers(ctx context.Context, env *envState, tasks []updateTask, timeout time.Duration) map[string]managerRefresh {
+	refreshes := map[string]managerRefresh{}
+	for _, task := range tasks {
+		cmd := refreshCommand(task.kind)
+		if cmd == nil || ctx.Err() != nil {
+			continue
+		}
+		if _, done := refreshes[task.kind]; done {
+			continue
+		}
+		out, exitCode, _, _ := env.commands().Run(ctx, cmd, timeout)
+		refreshes[task.kind] = managerRefresh{cmd: cmd, out: out, exitCode: exitCode}
+	}
+	return refreshes
+}
+
+// attachRefreshLogs prepends the refresh output to the log of every agent updated through that manager,
+// so --verbose shows it; a failed refresh also adds a hint since versions may be stale.
+func attachRefreshLogs(results []result, refreshes map[string]managerRefresh) {
+	for i := range results {
+		res := &results[i]
+		refresh, ok := refreshes[res.Method]
+		if !ok || res.Status == statusSkipped {
+			continue
+		}
+		var b strings.Builder
+		b.WriteString("(uca) " + cmdString(refresh.cmd) + " (--refresh-first)\n")
+		if out := strings.TrimSpace(refresh.out); out != "" {
+			b.WriteString(out + "\n")
+		}
+		if refresh.exitCode != 0 {
+			fmt.Fprintf(&b, "(uca) refresh failed (exit %d); continuing with the existing index\n", refresh.exitCode)
+			res.Explain = appendHint(res.Explain, fmt.Sprintf("%s failed; the update may have used a stale index", cmdString(refresh.cmd)))
+		}
+		if res.Log != "" {
+			b.WriteString("\n" + res.Log)
+		}
+		res.Log = b.String()
+	}
+}
+
+// dryRunCommandProblem reports why a resolved command could not run, or "" when its executable resolves.
+func dryRunCommandProblem(env *envState, cmd []string) string {
+	if len(cmd) == 0 || strings.TrimSpace(cmd[0]) == "" {
+		return codeWouldFail.label() + ": empty command"
+	}
+	if env.hasBinary(cmd[0]) {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s not found", codeWouldFail.label(), cmd[0])
+}
+
+// runTask runs one update task and stores its agents' results. It returns how long the task waited for
+// manager and conflict-group locks (--profile).
+func runTask(ctx context.Context, task updateTask, env *envState, opts options, locker *managerLocker, events chan<- updateEvent, results []result) (lockWait time.Duration) {
 	if len(task.agents) == 0 {
 		return
 	}
 
 	kind := task.kind
-	unlock := func() {}
-	if shouldLockKind(kind) {
-		unlock = locker.lock(kind)
+	waitStart := time.Now()
+	unlock, blocked := taskLocks(ctx, task, locker, opts.LockTimeout)
+	var unlockOnce sync.Once
+	release := func() { unlockOnce.Do(unlock) }
+	defer release()
+	lockWait = time.Since(waitStart)
+	// releaseForRecheck lets other tasks of the same manager start before recheckUnknownVersions waits out
+	// versionSettleDelay; only a --rollback after it still needs the locks.
+	releaseForRecheck := func() {
+		if !opts.Rollback {
+			release()
+		}
+	}
+
+	if blocked != "" && ctx.Err() == nil {
+		now := time.Now()
+		for _, work := range task.agents {
+			res := result{
+				Agent:      work.agent,
+				Method:     work.method,
+				Explain:    appendHint(work.explain, lockTimeoutHint(blocked, opts.LockTimeout)),
+				UpdateCmd:  cmdString(work.updateCmd),
+				Batched:    work.batched,
+				Status:     statusSkipped,
+				ReasonCode: codeLockTimeout,
+			}
+			results[work.index] = res
+			if events != nil {
+				events <- updateEvent{Index: work.index, Phase: phaseFinish, Result: res, Time: now, Show: work.show}
+			}
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		// Canceled before this task started: record its agents instead of letting them vanish.
+		now := time.Now()
+		for _, work := range task.agents {
+			res := result{
+				Agent:      work.agent,
+				Method:     work.method,
+				Explain:    work.explain,
+				UpdateCmd:  cmdString(work.updateCmd),
+				Batched:    work.batched,
+				Status:     statusSkipped,
+				ReasonCode: codeCanceled,
+			}
+			results[work.index] = res
+			if events != nil {
+				events <- updateEvent{Index: work.index, Phase: phaseFinish, Result: res, Time: now, Show: work.show}
+			}
+		}
+		return
+	}
+
+	if kind == agents.KindNative && len(task.agents) == 1 {
+		work := task.agents[0]
+		if reason, hint := authPrecheck(ctx, env.commands(), work.agent); reason != "" {
+			res := result{
+				Agent:      work.agent,
+				Method:     work.method,
+				Explain:    appendHint(work.explain, hint),
+				UpdateCmd:  cmdString(work.updateCmd),
+				Status:     statusSkipped,
+				ReasonCode: reason,
+			}
+			results[work.index] = res
+			if events != nil {
+				events <- updateEvent{Index: work.index, Phase: phaseFinish, Result: res, Time: time.Now(), Show: work.show}
+			}
+			return
+		}
 	}
-	defer unlock()
 
 	// Prepare results and emit start events.
 	prepared := make([]result, len(task.agents))
+	// launched records, for --verify, which agents started cleanly before the update.
+	launched := make([]bool, len(task.agents))
 	for i, work := range task.agents {
 		res := result{
 			Agent:     work.agent,
 			Method:    work.method,
 			Explain:   work.explain,
 			UpdateCmd: cmdString(work.updateCmd),
+			Batched:   work.batched,
+		}
+		if work.install {
+			res.ReasonCode = codeInstalled
 		}
 		res.Before = getVersion(ctx, work.agent, env, work.method)
+		if opts.Verify && !work.install {
+			launched[i], _ = launchCheck(ctx, env, work.agent)
+		}
 		prepared[i] = res
 	}
 	if events != nil && isNodeKind(kind) {
@@ -531,10 +2750,11 @@ func runTask(ctx context.Context, task updateTask, env *envState, opts options,
 				continue
 			}
 			before := prepared[i].Before
+			tag := work.nodeTag
 			wg.Add(1)
 			go func(i int, before, pkg string) {
 				defer wg.Done()
-				latest := nodeLatestVersion(previewCtx, kind, pkg)
+				latest := nodeLatestVersion(previewCtx, env.commands(), kind, pkg, tag)
 				if latest == "" {
 					return
 				}
@@ -555,16 +2775,46 @@ func runTask(ctx context.Context, task updateTask, env *envState, opts options,
 		}
 	}
 
-	out, classifyOut, exitCode, duration, _ := runUpdateCmd(ctx, task.cmd, opts.Timeout)
+	out, classifyOut, exitCode, duration, runErr := runUpdateCmd(ctx, env.commands(), task.cmd, task.timeout, opts.CleanReinstall, opts.gate)
+	if kind == agents.KindVSCode {
+		// `--list-extensions` was cached before the install; force a re-query for the After version.
+		for _, work := range task.agents {
+			env.invalidateCodeExtension(work.agent.ExtensionID)
+		}
+	}
+	if exitCode == 0 && isNodeKind(kind) {
+		env.refreshNodePackages(kind, taskBinaries(task))
+	} else if exitCode == 0 {
+		// A fresh install puts a new binary on PATH; the earlier miss is cached.
+		env.forgetBinaries(taskBinaries(task))
+	}
 
-	// If a batched node update fails, fall back to per-package updates so we can still make progress and
-	// attribute failures precisely.
-	if exitCode != 0 && len(task.agents) > 1 && isNodeKind(kind) {
+	// If a batched node or brew update fails, fall back to per-package updates so we can still make
+	// progress and attribute failures precisely.
+	if exitCode != 0 && len(task.agents) > 1 && isBatchKind(kind) {
+		finish := func(i int) {
+			work := task.agents[i]
+			if opts.Verify {
+				verifyLaunch(ctx, env, &prepared[i], work, launched[i], opts)
+			}
+			results[work.index] = prepared[i]
+			if events != nil {
+				events <- updateEvent{Index: work.index, Phase: phaseFinish, Result: prepared[i], Time: time.Now(), Show: work.show}
+			}
+		}
+		// Members whose version came back unknown are re-read together after the loop, so the settle delay
+		// is paid once rather than per member.
+		recheck := []int{}
 		for i, work := range task.agents {
 			res := prepared[i]
 			res.Explain = appendHint(res.Explain, "batch update failed; retrying individually")
 
-			indOut, indClassifyOut, indExitCode, indDuration, _ := runUpdateCmd(ctx, work.updateCmdSingle, opts.Timeout)
+			indOut, indClassifyOut, indExitCode, indDuration, indErr := runUpdateCmd(ctx, env.commands(), work.updateCmdSingle, work.timeout, opts.CleanReinstall, opts.gate)
+			if indExitCode == 0 && isNodeKind(kind) {
+				env.refreshNodePackages(kind, []string{work.agent.Binary})
+			} else if indExitCode == 0 {
+				env.forgetBinaries([]string{work.agent.Binary})
+			}
 			res.Duration = indDuration
 			res.Log = strings.TrimRight(out, "\n")
 			if strings.TrimSpace(res.Log) != "" && strings.TrimSpace(indOut) != "" {
@@ -576,39 +2826,491 @@ func runTask(ctx context.Context, task updateTask, env *envState, opts options,
 			res.After = getVersion(ctx, work.agent, env, work.method)
 
 			if indExitCode != 0 {
-				setFailureResult(&res, indExitCode, work.updateCmdSingle, indClassifyOut, opts.Timeout)
+				setFailureResult(&res, indExitCode, work.updateCmdSingle, indClassifyOut, work.timeout)
+				if errors.Is(indErr, errRemovedNotReinstalled) {
+					markRemovedNotReinstalled(&res, work.updateCmdSingle)
+				}
 			} else if res.Before != "" && res.After != "" && res.Before == res.After && res.Before != "unknown" {
 				res.Status = statusUnchanged
 			} else {
 				res.Status = statusUpdated
 			}
-			results[work.index] = res
-			if events != nil {
-				events <- updateEvent{Index: work.index, Phase: phaseFinish, Result: res, Time: time.Now(), Show: work.show}
+			markReinstalled(&res, work)
+			prepared[i] = res
+			if res.Status == statusUpdated && res.After == "unknown" {
+				recheck = append(recheck, i)
+				continue
+			}
+			finish(i)
+		}
+		if len(recheck) > 0 {
+			releaseForRecheck()
+			recheckUnknownVersions(ctx, env, prepared)
+			for _, i := range recheck {
+				finish(i)
 			}
 		}
 		return
 	}
 
 	// Batch success or non-batch failure path.
+	expected := make([]string, len(prepared))
+	for i := range prepared {
+		// The latest-version preview (when fetched) is what the batch should have installed.
+		expected[i] = prepared[i].After
+	}
 	for i, work := range task.agents {
-		res := prepared[i]
+		res := &prepared[i]
 		res.Duration = duration
 		res.Log = out
 		res.After = getVersion(ctx, work.agent, env, work.method)
 
 		if exitCode != 0 {
-			setFailureResult(&res, exitCode, task.cmd, classifyOut, opts.Timeout)
+			setFailureResult(res, exitCode, task.cmd, classifyOut, task.timeout)
+			if errors.Is(runErr, errRemovedNotReinstalled) {
+				markRemovedNotReinstalled(res, task.cmd)
+			}
 		} else if res.Before != "" && res.After != "" && res.Before == res.After && res.Before != "unknown" {
 			res.Status = statusUnchanged
 		} else {
 			res.Status = statusUpdated
 		}
-		results[work.index] = res
+		markReinstalled(res, work)
+	}
+	if exitCode == 0 && kind == agents.KindBun {
+		reinstallStaleBun(ctx, env, task, prepared, opts.gate)
+	}
+	if kind == agents.KindNative && len(task.agents) == 1 {
+		fallThroughNative(ctx, env, task.agents[0], &prepared[0], out, opts)
+	}
+	if exitCode == 0 && isNodeKind(kind) {
+		if counts := summarizeNodeInstall(kind, out); counts != "" {
+			for i := range prepared {
+				prepared[i].Explain = appendNote(prepared[i].Explain, counts)
+			}
+		}
+	}
+	releaseForRecheck()
+	recheckUnknownVersions(ctx, env, prepared)
+	if exitCode == 0 && len(task.agents) > 1 && isNodeKind(kind) {
+		// Only node batches have a latest-version preview to tell a stuck member from a current one.
+		flagPartialBatch(prepared, expected)
+	}
+	if opts.Verify {
+		for i, work := range task.agents {
+			verifyLaunch(ctx, env, &prepared[i], work, launched[i], opts)
+		}
+	}
+	for i, work := range task.agents {
+		results[work.index] = prepared[i]
 		if events != nil {
-			events <- updateEvent{Index: work.index, Phase: phaseFinish, Result: res, Time: time.Now(), Show: work.show}
+			events <- updateEvent{Index: work.index, Phase: phaseFinish, Result: prepared[i], Time: time.Now(), Show: work.show}
+		}
+	}
+	return
+}
+
+// nativeNoSelfUpdateMarkers are phrases native updaters print when they can't update this install, usually
+// because a package manager owns it.
+var nativeNoSelfUpdateMarkers = []string{
+	"cannot self-update",
+	"can't self-update",
+	"self-update is not supported",
+	"auto-update is not supported",
+	"installed via npm",
+	"installed with npm",
+	"installed globally with npm",
+	"managed by a package manager",
+	"could not determine installation type",
+	"update using your package manager",
+}
+
+func nativeCannotSelfUpdate(output string) bool {
+	lower := strings.ToLower(output)
+	for _, marker := range nativeNoSelfUpdateMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// nativeFallback resolves the agent's non-native strategies, returning the node update command to fall
+// through to when its native updater declines to run. Nothing is returned unless a node manager actually
+// owns the install.
+func nativeFallback(agent agents.Agent, env *envState) ([]string, string) {
+	fallback := agent
+	fallback.Strategies = nil
+	for _, strat := range agent.Strategies {
+		if isNodeKind(strat.Kind) {
+			fallback.Strategies = append(fallback.Strategies, strat)
+		}
+	}
+	if len(fallback.Strategies) == 0 {
+		return nil, ""
+	}
+	cmd, _, method, _ := resolveUpdate(fallback, env)
+	if cmd == nil || !isNodeKind(method) {
+		return nil, ""
+	}
+	return cmd, method
+}
+
+// fallThroughNative reruns a native agent's update through its node manager when the built-in updater
+// didn't move the version and said it can't self-update (e.g. `claude update` on an npm install).
+func fallThroughNative(ctx context.Context, env *envState, work agentWork, res *result, out string, opts options) {
+	if work.fallbackCmd == nil || ctx.Err() != nil {
+		return
+	}
+	if res.Status != statusUnchanged && res.Status != statusFailed {
+		return
+	}
+	if !nativeCannotSelfUpdate(out) {
+		return
+	}
+	nodeOut, nodeClassifyOut, nodeExitCode, nodeDuration, nodeErr := runUpdateCmd(ctx, env.commands(), work.fallbackCmd, work.timeout, opts.CleanReinstall, opts.gate)
+	if nodeExitCode == 0 {
+		env.refreshNodePackages(work.fallbackMethod, []string{work.agent.Binary})
+	}
+	res.Log = strings.TrimRight(res.Log, "\n") + fmt.Sprintf("\n\n(uca) built-in updater can't self-update; running %s\n", cmdString(work.fallbackCmd)) + strings.TrimSpace(nodeOut)
+	res.Duration += nodeDuration
+	res.Method = work.fallbackMethod
+	res.UpdateCmd = cmdString(work.fallbackCmd)
+	res.Explain = appendNote(res.Explain, fmt.Sprintf("built-in updater can't self-update; fell through to %s", work.fallbackMethod))
+	res.ReasonCode, res.ReasonDetail = "", ""
+	res.After = getVersion(ctx, work.agent, env, work.fallbackMethod)
+	switch {
+	case nodeExitCode != 0:
+		setFailureResult(res, nodeExitCode, work.fallbackCmd, nodeClassifyOut, work.timeout)
+		if errors.Is(nodeErr, errRemovedNotReinstalled) {
+			markRemovedNotReinstalled(res, work.fallbackCmd)
+		}
+	case res.Before != "" && res.After != "" && res.Before == res.After && res.Before != "unknown":
+		res.Status = statusUnchanged
+	default:
+		res.Status = statusUpdated
+	}
+}
+
+// reinstallStaleBun works around `bun add -g pkg@latest` exiting 0 without replacing an already-installed
+// global on some bun versions. For an unchanged agent that is still behind the registry's dist-tag it runs
+// `bun update -g` and re-reads the version; only if that didn't catch up, and gate allows it, is the global
+// removed and added again.
+func reinstallStaleBun(ctx context.Context, env *envState, task updateTask, results []result, gate *confirmGate) {
+	runner := env.commands()
+	for i, work := range task.agents {
+		res := &results[i]
+		pkg := strings.TrimSpace(work.nodePackageName)
+		if res.Status != statusUnchanged || pkg == "" || ctx.Err() != nil {
+			continue
+		}
+		latest := nodeLatestVersion(ctx, runner, agents.KindBun, pkg, work.nodeTag)
+		if latest == "" || sameVersionToken(res.After, latest) {
+			continue
+		}
+		res.Log = appendRecoveryStep(res.Log, fmt.Sprintf("bun add -g kept %s at %s (latest %s)", pkg, safeVersion(res.After), latest))
+		if work.nodeTag == "" {
+			// `bun update -g` follows latest only; a pinned dist-tag goes straight to the reinstall.
+			update := []string{"bun", "update", "-g", "--latest", pkg}
+			updateOut, updateCode, updateDuration, _ := runner.Run(ctx, update, work.timeout)
+			res.Log = appendRecoveryCommand(res.Log, update, updateOut)
+			res.Duration += updateDuration
+			if updateCode == 0 {
+				env.refreshNodePackages(agents.KindBun, []string{work.agent.Binary})
+				if after := getVersion(ctx, work.agent, env, work.method); sameVersionToken(after, latest) {
+					res.After, res.Status = after, statusUpdated
+					continue
+				}
+			}
+		}
+		remove := []string{"bun", "remove", "-g", pkg}
+		add := []string{"bun", "add", "-g", pkg + "@" + distTagOrLatest(work.nodeTag)}
+		if !gate.allow(fmt.Sprintf("bun kept %s at %s (latest %s). Remove it and add it again?", pkg, safeVersion(res.After), latest)) {
+			res.Explain = appendHint(res.Explain, fmt.Sprintf("bun left %s behind latest %s; rerun with --assume-yes to remove and add it again", res.After, latest))
+			continue
+		}
+		res.Log = appendRecoveryStep(res.Log, "still behind; reinstalling")
+		removeOut, removeCode, removeDuration, _ := runner.Run(ctx, remove, work.timeout)
+		res.Log = appendRecoveryCommand(res.Log, remove, removeOut)
+		res.Duration += removeDuration
+		if removeCode != 0 {
+			res.Log += fmt.Sprintf("\n(uca) bun remove failed (exit %d); not reinstalling", removeCode)
+			res.Explain = appendHint(res.Explain, fmt.Sprintf("bun left %s behind latest %s and `%s` failed", res.After, latest, cmdString(remove)))
+			continue
+		}
+		addOut, addCode, addDuration, _ := runner.Run(ctx, add, work.timeout)
+		res.Log = appendRecoveryCommand(res.Log, add, addOut)
+		res.Duration += addDuration
+		env.refreshNodePackages(agents.KindBun, []string{work.agent.Binary})
+		res.After = getVersion(ctx, work.agent, env, work.method)
+		switch {
+		case addCode != 0:
+			setFailureResult(res, addCode, add, addOut, work.timeout)
+			markRemovedNotReinstalled(res, add)
+		case res.After != res.Before || res.After == "unknown":
+			res.Status = statusUpdated
+		default:
+			res.Explain = appendHint(res.Explain, fmt.Sprintf("bun reinstall left %s behind latest %s", res.After, latest))
+		}
+	}
+}
+
+// appendRecoveryStep adds a "(uca)" note to an agent's log, after a blank line.
+func appendRecoveryStep(log, note string) string {
+	if log = strings.TrimRight(log, "\n"); log != "" {
+		log += "\n\n"
+	}
+	return log + "(uca) " + note
+}
+
+// appendRecoveryCommand adds a command uca ran on its own and that command's output to an agent's log.
+func appendRecoveryCommand(log string, cmd []string, out string) string {
+	log += "\n(uca) " + cmdString(cmd)
+	if out = strings.TrimSpace(out); out != "" {
+		log += "\n" + out
+	}
+	return log
+}
+
+var (
+	npmCountRe  = regexp.MustCompile(`\b(added|removed|changed) (\d+) packages?\b`)
+	pnpmCountRe = regexp.MustCompile(`(?m)^Packages: ((?:[+-]\d+ ?)+)$`)
+	bunCountRe  = regexp.MustCompile(`\b(\d+) packages? installed\b`)
+)
+
+// summarizeNodeInstall extracts the package counts a node manager prints after an install, e.g.
+// "npm packages: added 12, changed 4", so a long batch shows how much it actually installed.
+func summarizeNodeInstall(kind, out string) string {
+	parts := []string{}
+	switch kind {
+	case agents.KindNpm:
+		for _, m := range npmCountRe.FindAllStringSubmatch(out, -1) {
+			parts = append(parts, m[1]+" "+m[2])
+		}
+	case agents.KindPnpm:
+		if m := pnpmCountRe.FindStringSubmatch(out); m != nil {
+			for _, field := range strings.Fields(m[1]) {
+				if strings.HasPrefix(field, "+") {
+					parts = append(parts, "added "+field[1:])
+				} else {
+					parts = append(parts, "removed "+field[1:])
+				}
+			}
+		}
+	case agents.KindBun:
+		if m := bunCountRe.FindStringSubmatch(out); m != nil {
+			parts = append(parts, "installed "+m[1])
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s packages: %s", kind, strings.Join(parts, ", "))
+}
+
+const authCheckTimeout = 20 * time.Second
+
+var authFailureMarkers = []string{"not logged in", "not authenticated", "unauthorized", "unauthenticated", "please log in", "please login", "login required", "authentication required", "invalid api key"}
+
+// authPrecheck runs the agent's AuthCheckCmd and returns a skip reason and hint when it fails with a quota
+// or auth error. Other failures return "" so the update still runs; the check is advisory.
+func authPrecheck(ctx context.Context, runner commandRunner, agent agents.Agent) (reasonCode, string) {
+	if len(agent.AuthCheckCmd) == 0 {
+		return "", ""
+	}
+	out, exitCode, _, _ := runner.Run(ctx, agent.AuthCheckCmd, authCheckTimeout)
+	if exitCode == 0 || exitCode == exitCodeTimeout || exitCode == exitCodeCanceled {
+		return "", ""
+	}
+	check := cmdString(agent.AuthCheckCmd)
+	if code, hint := classifyUpdateFailure(agent.AuthCheckCmd, out); code == codeQuota {
+		return codeQuota, fmt.Sprintf("auth check `%s` reported %s; skipped the update", check, hint)
+	}
+	lower := strings.ToLower(out)
+	for _, marker := range authFailureMarkers {
+		if strings.Contains(lower, marker) {
+			return codeAuth, fmt.Sprintf("auth check `%s` failed: not logged in; log in to %s and rerun", check, agent.Name)
+		}
+	}
+	return "", ""
+}
+
+// flagPartialBatch marks members of a successful batch that did not visibly move while a sibling did:
+// npm can exit 0 after skipping a package with only a warning. An unchanged member is only flagged when its
+// expected (previewed) latest version is known and it isn't there; without a preview (--quiet, a machine
+// --format, offline) there is nothing to hold it to.
+func flagPartialBatch(results []result, expected []string) {
+	moved := false
+	for _, res := range results {
+		if res.Status == statusUpdated && res.Before != "unknown" && res.After != "unknown" && res.Before != res.After {
+			moved = true
+			break
+		}
+	}
+	if !moved {
+		return
+	}
+	for i := range results {
+		res := &results[i]
+		switch {
+		case res.Status == statusUpdated && res.After == "unknown":
+		case res.Status == statusUnchanged && hasVersionToken(expected[i]) && !sameVersionToken(res.After, expected[i]):
+		default:
+			continue
+		}
+		res.ReasonCode = codeBatchPartial
+		res.Explain = appendHint(res.Explain, "other packages in the batch updated but this one did not visibly move; rerun with --no-batch to check it")
+	}
+}
+
+func hasVersionToken(s string) bool {
+	_, ok := extractVersionToken(s)
+	return ok
+}
+
+func sameVersionToken(a, b string) bool {
+	ta, okA := extractVersionToken(a)
+	tb, okB := extractVersionToken(b)
+	return okA && okB && strings.TrimPrefix(ta, "v") == strings.TrimPrefix(tb, "v")
+}
+
+// launchCheck runs the agent's own version command, without the package-list fallback getVersion uses,
+// and reports whether the binary starts. problem describes a failure, e.g. "`x --version` exited 1: ...".
+func launchCheck(ctx context.Context, env *envState, agent agents.Agent) (ok bool, problem string) {
+	args := versionCommand(agent)
+	if len(args) == 0 {
+		return false, ""
+	}
+	out, exitCode, _, _ := env.commands().Run(ctx, args, versionCmdTimeout)
+	if exitCode == 0 || parseFailedVersionOutput(out) != "unknown" {
+		return true, ""
+	}
+	problem = fmt.Sprintf("`%s` exited %d", cmdString(args), exitCode)
+	if line := strings.TrimSpace(tailLines(out, 1)); line != "" {
+		problem += ": " + line
+	}
+	return false, problem
+}
+
+// verifyLaunch is --verify: an agent that launched before its update and no longer does is failed as
+// "broken" instead of being reported as a successful update. With rollback, the previous version is
+// reinstalled.
+func verifyLaunch(ctx context.Context, env *envState, res *result, work agentWork, launchedBefore bool, opts options) {
+	if !launchedBefore || (res.Status != statusUpdated && res.Status != statusUnchanged) || ctx.Err() != nil {
+		return
+	}
+	ok, problem := launchCheck(ctx, env, res.Agent)
+	if ok {
+		return
+	}
+	res.Status = statusFailed
+	res.ReasonCode = codeBroken
+	if !opts.Rollback {
+		res.Explain = appendHint(res.Explain, fmt.Sprintf("%s launched before the update (%s) but not after it: %s; the new release may be bad, so reinstall the previous version (or rerun with --rollback)", res.Agent.Name, safeVersion(res.Before), problem))
+		return
+	}
+	res.Explain = appendNote(res.Explain, fmt.Sprintf("%s launched before the update (%s) but not after it: %s", res.Agent.Name, safeVersion(res.Before), problem))
+	rollBack(ctx, env, res, work, opts.gate)
+}
+
+// rollbackCommand installs an exact earlier version of a package, or ok=false when the method can't pin one.
+func rollbackCommand(work agentWork, version string) ([]string, bool) {
+	switch {
+	case isNodeKind(work.method):
+		pkg := strings.TrimSpace(work.nodePackageName)
+		if pkg == "" {
+			return nil, false
+		}
+		return nodeBatchUpdateCommand(work.method, []string{pkg}, map[string]string{pkg: version}), true
+	case work.method == agents.KindPip:
+		return []string{"python3", "-m", "pip", "install", strategyPackage(work.agent, agents.KindPip) + "==" + version}, true
+	case work.method == agents.KindUv:
+		return []string{"uv", "tool", "install", "--force", "--python", "python3.12", "--with", "pip", strategyPackage(work.agent, agents.KindUv) + "==" + version}, true
+	}
+	return nil, false
+}
+
+// rollBack reinstalls the version the agent had before a broken update. It runs under the task's manager
+// lock. A successful rollback is reported as "rolled back" (still a failed update); otherwise the agent
+// stays "broken" with a hint.
+func rollBack(ctx context.Context, env *envState, res *result, work agentWork, gate *confirmGate) {
+	token, ok := extractVersionToken(res.Before)
+	if !ok {
+		res.Explain = appendHint(res.Explain, "can't roll back: the previous version is unknown; reinstall it manually")
+		return
+	}
+	before := strings.TrimPrefix(token, "v")
+	cmd, ok := rollbackCommand(work, before)
+	if !ok {
+		res.Explain = appendHint(res.Explain, fmt.Sprintf("--rollback can't pin a version for %s installs; reinstall %s manually", work.method, before))
+		return
+	}
+	if !gate.allow(fmt.Sprintf("%s no longer launches after its update. Roll back with `%s`?", work.agent.Name, cmdString(cmd))) {
+		res.Explain = appendHint(res.Explain, fmt.Sprintf("rollback declined; reinstall %s with `%s`", before, cmdString(cmd)))
+		return
+	}
+	out, _, exitCode, duration, _ := runUpdateCmd(ctx, env.commands(), cmd, work.timeout, false, nil)
+	res.Duration += duration
+	res.Log = strings.TrimRight(res.Log, "\n") + "\n\n(uca) rolling back: " + cmdString(cmd) + "\n" + strings.TrimSpace(out)
+	if exitCode != 0 {
+		res.Explain = appendHint(res.Explain, fmt.Sprintf("rollback `%s` failed (exit %d); reinstall %s manually", cmdString(cmd), exitCode, before))
+		return
+	}
+	if isNodeKind(work.method) {
+		env.refreshNodePackages(work.method, []string{work.agent.Binary})
+	}
+	res.After = getVersion(ctx, work.agent, env, work.method)
+	if launched, problem := launchCheck(ctx, env, work.agent); !launched {
+		res.Explain = appendHint(res.Explain, fmt.Sprintf("rolled back to %s with `%s`, but it still doesn't launch: %s", before, cmdString(cmd), problem))
+		return
+	}
+	res.ReasonCode = codeRolledBack
+	res.Explain = appendNote(res.Explain, fmt.Sprintf("rolled back to %s with `%s`", before, cmdString(cmd)))
+}
+
+// versionSettleDelay is how long to wait before re-reading a version that came back unknown right
+// after an update; some CLIs exit nonzero on --version while they finish replacing themselves.
+var versionSettleDelay = 2 * time.Second
+
+// recheckUnknownVersions re-runs the version lookup once, after versionSettleDelay, for updated results
+// whose After version is unknown. The delay is shared by all results in the slice.
+func recheckUnknownVersions(ctx context.Context, env *envState, results []result) {
+	pending := []int{}
+	for i := range results {
+		if results[i].Status == statusUpdated && results[i].After == "unknown" {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(versionSettleDelay):
+	}
+	for _, i := range pending {
+		res := &results[i]
+		after := getVersion(ctx, res.Agent, env, res.Method)
+		if after == "unknown" {
+			continue
+		}
+		res.After = after
+		res.Explain = appendHint(res.Explain, "version was unknown right after the update; re-checked it after a short delay")
+		if res.Before == after {
+			res.Status = statusUnchanged
+		}
+	}
+}
+
+func taskBinaries(task updateTask) []string {
+	binaries := make([]string, 0, len(task.agents))
+	for _, work := range task.agents {
+		if work.agent.Binary != "" {
+			binaries = append(binaries, work.agent.Binary)
 		}
 	}
+	return binaries
 }
 
 type updateEvent struct {
@@ -626,12 +3328,16 @@ const (
 )
 
 type uiRow struct {
-	name     string
-	status   string
-	before   string
-	after    string
-	reason   string
-	method   string
+	name   string
+	status string
+	before string
+	after  string
+	code   reasonCode
+	// reason is the result's reason label, shown in the info column.
+	reason string
+	method string
+	// manual is the agent's ManualInstructions, shown in the info column of a manual-install row.
+	manual   string
 	start    time.Time
 	duration time.Duration
 	visible  bool
@@ -639,29 +3345,146 @@ type uiRow struct {
 }
 
 type uiRenderer struct {
-	out        *os.File
-	lastLines  int
+	out *os.File
+	// lastFrame is the frame on screen, kept so Draw can tell how many rows it spans after a resize.
+	lastFrame  string
 	useColor   bool
 	useUnicode bool
 	width      int
+	// final renders the closing frame: "done" in place of the spinner.
+	final bool
+	// region is set while the frame sits in rows reserved at the bottom of the screen by a DECSTBM
+	// scroll region, so streamed logs scroll above it (--verbose). height is the terminal height and
+	// reserved the number of rows the frame occupies.
+	region   bool
+	height   int
+	reserved int
 }
 
-func newRenderer(out *os.File) *uiRenderer {
+func newRenderer(out *os.File, opts options) *uiRenderer {
 	return &uiRenderer{
 		out:        out,
-		useColor:   shouldUseColor(),
-		useUnicode: shouldUseUnicode(),
+		useColor:   resolveColor(opts.Color, true),
+		useUnicode: resolveUnicode(opts.Unicode),
 		width:      termWidth(out),
 	}
 }
 
 func (r *uiRenderer) Draw(content string) {
-	if r.lastLines > 0 {
-		fmt.Fprintf(r.out, "\x1b[%dA", r.lastLines)
+	if r.region && r.drawInRegion(content) {
+		return
 	}
-	fmt.Fprint(r.out, "\x1b[0G\x1b[0J")
+	r.Clear()
 	fmt.Fprint(r.out, content)
-	r.lastLines = countLines(content)
+	r.lastFrame = content
+}
+
+// openLogRegion switches the renderer to drawing the frame at the bottom of the screen, below a scroll
+// region for Log. Without a known terminal height the frame stays inline and Log prints above it.
+func (r *uiRenderer) openLogRegion() {
+	if height, ok := terminalRows(r.out); ok {
+		r.region, r.height, r.reserved = true, height, 0
+	}
+}
+
+// drawInRegion draws content in the reserved bottom rows, growing or shrinking the scroll region above
+// it to match. A frame too tall to leave room for logs closes the region and reports false.
+func (r *uiRenderer) drawInRegion(content string) bool {
+	content = strings.TrimSuffix(content, "\n")
+	rows := frameRows(content, r.width)
+	if height, ok := terminalRows(r.out); ok && height != r.height {
+		// After a resize the old margins are meaningless; set them again without scrolling.
+		r.height, r.reserved = height, -1
+	}
+	if rows > r.height-minLogRows {
+		r.closeLogRegion()
+		return false
+	}
+	if rows != r.reserved {
+		if r.reserved >= 0 && rows > r.reserved {
+			// Scroll the logs up to make room, so the taller frame doesn't overwrite them.
+			fmt.Fprintf(r.out, "\x1b[%d;1H%s", r.height-r.reserved, strings.Repeat("\n", rows-r.reserved))
+		}
+		fmt.Fprintf(r.out, "\x1b[1;%dr", r.height-rows)
+		scrollRegionSet.Store(true)
+		r.reserved = rows
+	}
+	fmt.Fprintf(r.out, "\x1b[%d;1H\x1b[0J%s", r.height-rows+1, content)
+	r.lastFrame = content + "\n"
+	return true
+}
+
+// closeLogRegion resets the scroll region and leaves the cursor on a fresh line below the frame, the
+// same place an inline Draw leaves it.
+func (r *uiRenderer) closeLogRegion() {
+	if !r.region {
+		return
+	}
+	r.region = false
+	fmt.Fprintf(r.out, "\x1b[r\x1b[%d;1H\n", r.height)
+	scrollRegionSet.Store(false)
+	r.reserved = 0
+}
+
+// Log prints text above the live frame: at the bottom of the scroll region, or inline by clearing the
+// frame, printing, and drawing it again.
+func (r *uiRenderer) Log(text string) {
+	text = strings.TrimSuffix(text, "\n")
+	if r.region {
+		fmt.Fprintf(r.out, "\x1b[%d;1H", r.height-r.reserved)
+		for _, line := range strings.Split(text, "\n") {
+			fmt.Fprint(r.out, "\n"+line)
+		}
+		return
+	}
+	frame := r.lastFrame
+	r.Clear()
+	fmt.Fprintln(r.out, text)
+	r.Draw(frame)
+}
+
+// minLogRows is the least scroll region worth keeping for logs above the frame.
+const minLogRows = 3
+
+// Clear erases the live frame and forgets it, leaving the cursor where the frame started.
+func (r *uiRenderer) Clear() {
+	if rows := frameRows(r.lastFrame, r.width); rows > 0 {
+		fmt.Fprintf(r.out, "\x1b[%dA", rows)
+	}
+	fmt.Fprint(r.out, "\x1b[0G\x1b[0J")
+	r.lastFrame = ""
+}
+
+// refreshWidth re-reads the terminal width before a frame is rendered, so rows follow a resize. When the
+// query fails mid-run the previous width is kept.
+func (r *uiRenderer) refreshWidth() {
+	if width, ok := terminalColumns(r.out); ok {
+		r.width = width
+	}
+}
+
+// ansiEscapeRe matches the color and cursor sequences the dashboard emits.
+var ansiEscapeRe = regexp.MustCompile("\x1b\\[[0-9;?]*[A-Za-z]")
+
+// frameRows counts the terminal rows content occupies at width. A frame fitted to a wider terminal
+// wraps (terminals reflow on resize), so it spans more rows than lines.
+func frameRows(content string, width int) int {
+	if content == "" {
+		return 0
+	}
+	if width <= 0 {
+		return countLines(content)
+	}
+	rows := 0
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		w := runewidth.StringWidth(ansiEscapeRe.ReplaceAllString(line, ""))
+		if w <= width {
+			rows++
+			continue
+		}
+		rows += (w + width - 1) / width
+	}
+	return rows
 }
 
 func countLines(s string) int {
@@ -675,16 +3498,77 @@ func countLines(s string) int {
 	return lines
 }
 
+// cursorHidden tracks whether the dashboard hid the cursor, so exit paths know to restore it.
+var cursorHidden atomic.Bool
+
+// scrollRegionSet tracks whether the dashboard narrowed the scroll region, so exit paths reset it.
+var scrollRegionSet atomic.Bool
+
 func hideCursor(out *os.File) {
 	if out != nil {
 		fmt.Fprint(out, "\x1b[?25l")
+		cursorHidden.Store(true)
 	}
 }
 
 func showCursor(out *os.File) {
 	if out != nil {
 		fmt.Fprint(out, "\x1b[?25h")
+		cursorHidden.Store(false)
+	}
+}
+
+// restoreTerminal resets attributes, ends the partial line, and shows the cursor again if the dashboard
+// left it hidden.
+func restoreTerminal() {
+	if scrollRegionSet.Load() {
+		fmt.Fprint(os.Stdout, "\x1b[r")
+		scrollRegionSet.Store(false)
+	}
+	if cursorHidden.Load() {
+		fmt.Fprint(os.Stdout, "\x1b[0m\r\n")
+		showCursor(os.Stdout)
+	}
+}
+
+// restoreTerminalOnPanic is deferred in every goroutine that can run while the dashboard is up: a panic
+// anywhere would otherwise leave the user's terminal with a hidden cursor. It re-panics after cleanup.
+func restoreTerminalOnPanic() {
+	if r := recover(); r != nil {
+		restoreTerminal()
+		panic(r)
+	}
+}
+
+// handleSignals cancels the run on the first Ctrl-C/SIGTERM so in-flight updates wind down and the
+// summary still prints; a second signal restores the terminal and exits immediately.
+func handleSignals(cancel context.CancelFunc) {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	<-sigCh
+	cancel()
+	<-sigCh
+	restoreTerminal()
+	fmt.Fprintln(os.Stderr, "uca: interrupted")
+	os.Exit(exitCodeCanceled)
+}
+
+// formatInterrupted is the closing line after a canceled run.
+func formatInterrupted(results []result) string {
+	notStarted := 0
+	for _, res := range results {
+		if res.Status == statusSkipped && res.ReasonCode == codeCanceled {
+			notStarted++
+		}
+	}
+	if notStarted == 0 {
+		return "uca: interrupted"
 	}
+	noun := "agents"
+	if notStarted == 1 {
+		noun = "agent"
+	}
+	return fmt.Sprintf("uca: interrupted (%d %s not started)", notStarted, noun)
 }
 
 func shouldUseColor() bool {
@@ -698,17 +3582,37 @@ func shouldUseColor() bool {
 	return true
 }
 
+// resolveColor applies a --color mode. In auto mode, color requires a TTY and a color-capable environment.
+func resolveColor(mode string, tty bool) bool {
+	switch mode {
+	case modeAlways:
+		return true
+	case modeNever:
+		return false
+	default:
+		return tty && shouldUseColor()
+	}
+}
+
+// resolveUnicode applies a --unicode mode, falling back to the locale guess in auto mode.
+func resolveUnicode(mode string) bool {
+	switch mode {
+	case modeAlways:
+		return true
+	case modeNever:
+		return false
+	default:
+		return shouldUseUnicode()
+	}
+}
+
 func shouldUseUnicode() bool {
 	locale := strings.ToUpper(os.Getenv("LC_ALL") + os.Getenv("LC_CTYPE") + os.Getenv("LANG"))
 	return strings.Contains(locale, "UTF-8")
 }
 
 func termWidth(out *os.File) int {
-	if out == nil {
-		return 80
-	}
-	width, _, err := term.GetSize(int(out.Fd()))
-	if err == nil && width > 0 {
+	if width, ok := terminalColumns(out); ok {
 		return width
 	}
 	if cols := strings.TrimSpace(os.Getenv("COLUMNS")); cols != "" {
@@ -719,6 +3623,24 @@ func termWidth(out *os.File) int {
 	return 80
 }
 
+// terminalRows asks the terminal for its current height.
+func terminalRows(out *os.File) (int, bool) {
+	if out == nil {
+		return 0, false
+	}
+	_, height, err := term.GetSize(int(out.Fd()))
+	return height, err == nil && height > 0
+}
+
+// terminalColumns asks the terminal for its current width.
+func terminalColumns(out *os.File) (int, bool) {
+	if out == nil {
+		return 0, false
+	}
+	width, _, err := term.GetSize(int(out.Fd()))
+	return width, err == nil && width > 0
+}
+
 func runAllWithUI(ctx context.Context, selected []agents.Agent, env *envState, opts options) []result {
 	events := make(chan updateEvent, len(selected)*4)
 	done := make(chan struct{})
@@ -732,21 +3654,33 @@ func runAllWithUI(ctx context.Context, selected []agents.Agent, env *envState, o
 		}
 	}
 
-	renderer := newRenderer(os.Stdout)
+	renderer := newRenderer(os.Stdout, opts)
 	start := time.Now()
+	defer restoreTerminalOnPanic()
 	hideCursor(renderer.out)
+	streamLogs := streamsLogs(opts)
+	if streamLogs {
+		renderer.openLogRegion()
+	}
+	stream := newLogStream()
 	totalAgents := len(selected)
 	detectedCount := 0
 	renderer.Draw(renderFrame(rows, nameWidth, start, opts, renderer, detectedCount, totalAgents))
 
-	ticker := time.NewTicker(120 * time.Millisecond)
+	// With --no-spinner the tick channel stays nil, so frames are drawn only when an event arrives.
+	var tick <-chan time.Time
+	if !opts.NoSpinner {
+		ticker := time.NewTicker(opts.RefreshInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
 	go func() {
+		defer restoreTerminalOnPanic()
 		defer close(done)
 		for {
 			select {
 			case ev, ok := <-events:
 				if !ok {
-					ticker.Stop()
 					renderer.Draw(renderFrame(rows, nameWidth, start, opts, renderer, detectedCount, totalAgents))
 					return
 				}
@@ -755,8 +3689,13 @@ func runAllWithUI(ctx context.Context, selected []agents.Agent, env *envState, o
 					detectedCount++
 				}
 				applyEvent(&rows[ev.Index], ev)
+				if streamLogs {
+					if text := stream.add(ev, opts); text != "" {
+						renderer.Log(text)
+					}
+				}
 				renderer.Draw(renderFrame(rows, nameWidth, start, opts, renderer, detectedCount, totalAgents))
-			case <-ticker.C:
+			case <-tick:
 				renderer.Draw(renderFrame(rows, nameWidth, start, opts, renderer, detectedCount, totalAgents))
 			}
 		}
@@ -796,6 +3735,15 @@ func runAllWithUI(ctx context.Context, selected []agents.Agent, env *envState, o
 	results := runAllWithEvents(ctx, selected, env, opts, events)
 	close(events)
 	<-done
+	renderer.closeLogRegion()
+	if opts.KeepDashboard {
+		// Replace the live frame with a plain copy: a frame taller than the terminal can't be redrawn in
+		// place, so only a fresh print is guaranteed to land intact in scrollback.
+		renderer.refreshWidth()
+		renderer.Clear()
+		renderer.final = true
+		fmt.Fprint(renderer.out, renderDashboard(rows, nameWidth, start, opts, renderer, totalAgents, totalAgents))
+	}
 	showCursor(renderer.out)
 	return results
 }
@@ -806,11 +3754,12 @@ func applyEvent(row *uiRow, ev updateEvent) {
 	case phaseDetect:
 		row.visible = ev.Show
 		row.status = "pending"
-		row.reason = res.Reason
+		row.code, row.reason = res.ReasonCode, res.Reason()
 		row.method = res.Method
 		row.before = res.Before
-		if res.Status == statusSkipped && res.Reason == reasonManualInstall {
+		if res.Status == statusSkipped && res.ReasonCode == codeManualInstall {
 			row.status = statusSkipped
+			row.manual = res.Agent.ManualInstructions
 		}
 	case phaseStart:
 		row.status = "updating"
@@ -822,7 +3771,7 @@ func applyEvent(row *uiRow, ev updateEvent) {
 		row.status = res.Status
 		row.before = res.Before
 		row.after = res.After
-		row.reason = res.Reason
+		row.code, row.reason = res.ReasonCode, res.Reason()
 		row.method = res.Method
 		row.duration = res.Duration
 	}
@@ -853,7 +3802,11 @@ func renderDashboard(rows []uiRow, nameWidth int, start time.Time, opts options,
 			failed++
 		}
 	}
-	header := fmt.Sprintf("uca  %s  %d/%d  ok:%d same:%d fail:%d  %s", spinnerGlyph(time.Since(start), r.useUnicode), completed, visibleTotal, updated, unchanged, failed, fmtElapsed(time.Since(start)))
+	glyph := spinnerGlyph(time.Since(start), r.useUnicode)
+	if r.final {
+		glyph = "done"
+	}
+	header := fmt.Sprintf("uca  %s  %d/%d  ok:%d same:%d fail:%d  %s", glyph, completed, visibleTotal, updated, unchanged, failed, fmtElapsed(time.Since(start)))
 	if detected < total {
 		header = fmt.Sprintf("%s  detecting %d/%d", header, detected, total)
 	}
@@ -871,6 +3824,7 @@ func renderBoot(start time.Time, detected, total int, r *uiRenderer) string {
 }
 
 func renderFrame(rows []uiRow, nameWidth int, start time.Time, opts options, r *uiRenderer, detected, total int) string {
+	r.refreshWidth()
 	if detected < total {
 		for _, row := range rows {
 			if row.visible {
@@ -905,27 +3859,30 @@ func formatRow(row uiRow, nameWidth int, opts options, r *uiRenderer) string {
 	case "updating":
 		statusLabel = statusLabelFor(row)
 		if strings.TrimSpace(row.after) != "" {
-			version = fmt.Sprintf("%s → %s", safeVersion(row.before), safeVersion(row.after))
+			version = formatVersionPair(row.before, row.after)
 		} else {
-			version = fmt.Sprintf("%s → …", safeVersion(row.before))
+			before, _ := displayVersions(row.before, "")
+			version = fmt.Sprintf("%s → …", before)
 		}
 		if !row.start.IsZero() {
 			elapsed = fmtElapsed(time.Since(row.start))
 		}
 	case statusUpdated:
-		version = fmt.Sprintf("%s → %s", safeVersion(row.before), safeVersion(row.after))
+		version = formatVersionPair(row.before, row.after)
 		elapsed = fmtElapsed(row.duration)
 	case statusUnchanged:
-		version = fmt.Sprintf("%s → %s", safeVersion(row.before), safeVersion(row.after))
+		version = formatVersionPair(row.before, row.after)
 		elapsed = fmtElapsed(row.duration)
 	case statusFailed:
-		version = fmt.Sprintf("%s → %s", safeVersion(row.before), safeVersion(row.after))
+		version = formatVersionPair(row.before, row.after)
 		elapsed = fmtElapsed(row.duration)
 		if row.reason != "" {
 			info = row.reason
 		}
 	case statusSkipped:
-		if row.reason != "" && row.reason != reasonManualInstall {
+		if row.code == codeManualInstall {
+			info = row.manual
+		} else if row.reason != "" {
 			info = row.reason
 		}
 	}
@@ -936,6 +3893,9 @@ func formatRow(row uiRow, nameWidth int, opts options, r *uiRenderer) string {
 
 	if statusLabel == "dry-run" {
 		info = "preview"
+		if row.code == codeWouldFail {
+			info = row.reason
+		}
 	}
 
 	if info != "" {
@@ -951,15 +3911,39 @@ func formatRow(row uiRow, nameWidth int, opts options, r *uiRenderer) string {
 }
 
 func statusLabelFor(row uiRow) string {
-	if row.status == statusUpdated && row.reason == "dry-run" {
+	if row.status == statusUpdated && row.code.dryRun() {
 		return "dry-run"
 	}
 	if row.status == statusUnchanged {
 		return "same"
 	}
-	if row.status == statusSkipped && row.reason == reasonManualInstall {
+	if row.status == statusUpdated && row.code == codeInstalled {
+		return "installed"
+	}
+	if row.status == statusUpdated && row.code == codeReinstalled {
+		return "reinstalled"
+	}
+	if row.code == codeBatchPartial {
+		return "partial"
+	}
+	if row.status == statusFailed && row.code == codeRolledBack {
+		return "rollback"
+	}
+	if row.status == statusSkipped && row.code == codeManualInstall {
 		return "manual"
 	}
+	if row.status == statusSkipped && row.code == codeCurrent {
+		return "current"
+	}
+	if row.status == statusSkipped && row.code == codeDuplicate {
+		return "duplicate"
+	}
+	if row.status == statusSkipped && row.code == codeMajorUpgrade {
+		return "major"
+	}
+	if row.status == statusSkipped && (row.code == codeAuth || row.code == codeQuota) {
+		return "auth"
+	}
 	return row.status
 }
 
@@ -1019,10 +4003,10 @@ func fitLine(line string, width int, unicode bool) string {
 
 func statusIcon(row uiRow, unicode bool) string {
 	status := row.status
-	if status == statusUpdated && row.reason == "dry-run" {
+	if status == statusUpdated && row.code.dryRun() {
 		status = "dry-run"
 	}
-	if status == statusSkipped && row.reason == reasonManualInstall {
+	if status == statusSkipped && row.code == codeManualInstall {
 		if unicode {
 			return "○"
 		}
@@ -1086,6 +4070,10 @@ func methodLabel(method string) string {
 		return "uv"
 	case agents.KindVSCode:
 		return "vscode"
+	case agents.KindExec:
+		return "exec"
+	case agents.KindAsdf:
+		return "asdf"
 	default:
 		return method
 	}
@@ -1118,113 +4106,418 @@ func colorize(text, status string, enabled bool) string {
 	return "\x1b[" + code + "m" + text + "\x1b[0m"
 }
 
-func resolveUpdate(agent agents.Agent, env *envState) ([]string, string, string, string) {
-	codeMissing := false
+func resolveUpdate(agent agents.Agent, env *envState) ([]string, reasonCode, string, string) {
+	return resolveUpdateTrace(agent, env, nil)
+}
+
+// decisionStep records one strategy resolveUpdate considered and why it was selected or rejected.
+type decisionStep struct {
+	Kind    string `json:"kind"`
+	Target  string `json:"target,omitempty"`
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason"`
+}
+
+const (
+	outcomeSelected = "selected"
+	outcomeRejected = "rejected"
+)
+
+// decisionTrace collects decision steps; a nil trace records nothing.
+type decisionTrace struct {
+	steps []decisionStep
+}
+
+func (t *decisionTrace) add(strat agents.UpdateStrategy, outcome, reason string) {
+	if t == nil {
+		return
+	}
+	target := strat.Package
+	switch strat.Kind {
+	case agents.KindVSCode:
+		target = strat.ExtensionID
+	case agents.KindAsdf:
+		target = strat.Plugin
+	case agents.KindNative, agents.KindExec:
+		target = cmdString(strat.Command)
+	}
+	t.steps = append(t.steps, decisionStep{Kind: strat.Kind, Target: target, Outcome: outcome, Reason: reason})
+}
+
+func (t *decisionTrace) reject(strat agents.UpdateStrategy, reason string) {
+	t.add(strat, outcomeRejected, reason)
+}
+
+func (t *decisionTrace) selected(strat agents.UpdateStrategy, reason string) {
+	t.add(strat, outcomeSelected, reason)
+}
+
+// resolveUpdateTrace is resolveUpdate that also records each strategy decision in trace (may be nil).
+func resolveUpdateTrace(agent agents.Agent, env *envState, trace *decisionTrace) ([]string, reasonCode, string, string) {
+	reported, note := env.reportedInstallMethod(agent)
+	cmd, reason, method, detail := resolveStrategies(agent, env, trace, reported)
+	return cmd, reason, method, appendNote(detail, note)
+}
+
+// resolveStrategies picks the agent's update strategy. reported is the kind the agent's MethodCmd named
+// ("" for none): its strategies are tried first, and for a node kind it overrides the bin dir match.
+func resolveStrategies(agent agents.Agent, env *envState, trace *decisionTrace, reported string) ([]string, reasonCode, string, string) {
+	codeCLIMissing := false
 	detail := ""
+	// mismatch is set when a bin dir match is contradicted by that manager's package list.
+	mismatch := ""
+	// extMissing lists what the VS Code CLI reported when an extension strategy found nothing.
+	extMissing := ""
 	nodeManager := ""
 	if agent.Binary != "" {
 		nodeManager = env.nodeManagerForBinary(agent.Binary)
 	}
 	packageManager := ""
 	packageName := nodePackageName(agent.Strategies)
+	if isNodeKind(reported) {
+		nodeManager = reported
+	}
 	if nodeManager == "" && packageName != "" {
 		packageManager = env.nodeManagerForPackage(packageName)
 	}
 
-	for _, strat := range agent.Strategies {
+	for _, strat := range reportedStrategies(preferredStrategies(agent.Strategies, env.prefer), reported) {
 		switch strat.Kind {
 		case agents.KindNative:
 			if agent.Binary != "" && !env.hasBinary(agent.Binary) {
+				trace.reject(strat, fmt.Sprintf("binary %s not on PATH", agent.Binary))
 				continue
 			}
 			detail = fmt.Sprintf("binary %s found; using built-in update", agent.Binary)
+			if len(agent.AuthCheckCmd) > 0 {
+				detail += fmt.Sprintf(" after auth check `%s`", cmdString(agent.AuthCheckCmd))
+			}
+			trace.selected(strat, detail)
 			return strat.Command, "", strat.Kind, detail
 		case agents.KindBun, agents.KindNpm, agents.KindPnpm, agents.KindYarn:
 			if !env.hasNodeManager(strat.Kind) {
+				trace.reject(strat, fmt.Sprintf("%s not available", strat.Kind))
 				continue
 			}
 			if agent.Binary == "" || strat.Package == "" {
+				trace.reject(strat, "agent has no binary or package name")
 				continue
 			}
+			// exec.LookPath skips dangling symlinks, so a working copy elsewhere on PATH wins over the leftover.
+			if !env.hasBinary(agent.Binary) {
+				if link := env.nodeBinDanglingLink(strat.Kind, agent.Binary); link != "" {
+					detail = fmt.Sprintf("%s global bin has a dangling symlink %s (interrupted install?); repair with `uca --reinstall --only %s` or reinstall %s with %s", strat.Kind, link, agent.Name, strat.Package, strat.Kind)
+					trace.reject(strat, detail)
+					return nil, codeBrokenInstall, strat.Kind, detail
+				}
+			}
 			if nodeManager != "" {
 				if nodeManager != strat.Kind {
+					trace.reject(strat, fmt.Sprintf("bin dir of %s belongs to %s", agent.Binary, nodeManager))
+					continue
+				}
+				if env.nodePackageMismatch(strat.Kind, strat.Package) {
+					mismatch = binaryMismatchDetail(agent.Binary, strat)
+					trace.reject(strat, mismatch)
 					continue
 				}
 				detail = fmt.Sprintf("%s global bin has %s; matched by bin dir; updating via %s", strat.Kind, agent.Binary, strat.Kind)
-				return nodeUpdateCommand(strat), "", strat.Kind, detail
+				trace.selected(strat, detail)
+				return nodeUpdateCommand(strat), "", strat.Kind, env.withNodeVersionNote(agent.Binary, env.withCorepackNote(strat.Kind, detail))
 			}
 			if packageManager != "" {
 				if packageManager != strat.Kind {
+					trace.reject(strat, fmt.Sprintf("package %s listed by %s instead", strat.Package, packageManager))
 					continue
 				}
 				detail = fmt.Sprintf("%s global package %s installed; matched by package list; updating via %s", strat.Kind, strat.Package, strat.Kind)
-				return nodeUpdateCommand(strat), "", strat.Kind, detail
+				trace.selected(strat, detail)
+				return nodeUpdateCommand(strat), "", strat.Kind, env.withNodeVersionNote(agent.Binary, env.withCorepackNote(strat.Kind, detail))
 			}
 			if !env.nodeBinHasBinary(strat.Kind, agent.Binary) {
+				trace.reject(strat, fmt.Sprintf("%s not in %s global bin and package not in its list", agent.Binary, strat.Kind))
+				continue
+			}
+			if env.nodePackageMismatch(strat.Kind, strat.Package) {
+				mismatch = binaryMismatchDetail(agent.Binary, strat)
+				trace.reject(strat, mismatch)
 				continue
 			}
 			detail = fmt.Sprintf("%s global bin has %s; matched by bin dir; updating via %s", strat.Kind, agent.Binary, strat.Kind)
-			return nodeUpdateCommand(strat), "", strat.Kind, detail
+			trace.selected(strat, detail)
+			return nodeUpdateCommand(strat), "", strat.Kind, env.withNodeVersionNote(agent.Binary, env.withCorepackNote(strat.Kind, detail))
 		case agents.KindBrew:
 			if !env.hasBrew {
+				trace.reject(strat, "brew not available")
 				continue
 			}
 			if env.brewHas(strat.Package) {
 				detail = fmt.Sprintf("brew formula %s installed", strat.Package)
-				return []string{"brew", "upgrade", strat.Package}, "", strat.Kind, detail
+				trace.selected(strat, detail)
+				return packageUpdateCommand(strat), "", strat.Kind, detail
 			}
+			trace.reject(strat, fmt.Sprintf("brew formula %s not installed", strat.Package))
 		case agents.KindPip:
 			if !env.hasPython {
+				trace.reject(strat, "python3 not available")
 				continue
 			}
 			if env.pipHas(strat.Package) {
 				detail = fmt.Sprintf("pip package %s installed", strat.Package)
-				return []string{"python3", "-m", "pip", "install", "-U", "--upgrade-strategy", "only-if-needed", strat.Package}, "", strat.Kind, detail
+				trace.selected(strat, detail)
+				return packageUpdateCommand(strat), "", strat.Kind, detail
 			}
+			trace.reject(strat, fmt.Sprintf("pip package %s not installed", strat.Package))
 		case agents.KindUv:
 			if !env.hasUv {
+				trace.reject(strat, "uv not available")
 				continue
 			}
 			if env.uvHas(strat.Package) {
 				detail = fmt.Sprintf("uv tool %s installed", strat.Package)
-				return []string{"uv", "tool", "install", "--force", "--python", "python3.12", "--with", "pip", strat.Package + "@latest"}, "", strat.Kind, detail
+				if pin := env.uvPin(strat.Package); pin != "" {
+					// `uv tool upgrade` would keep the pin and report the tool unchanged forever.
+					detail += fmt.Sprintf(" (pinned to ==%s, e.g. by --rollback; reinstalling unpinned)", pin)
+					trace.selected(strat, detail)
+					return uvToolInstallCommand(strat.Package), "", strat.Kind, detail
+				}
+				trace.selected(strat, detail)
+				return packageUpdateCommand(strat), "", strat.Kind, detail
+			}
+			trace.reject(strat, fmt.Sprintf("uv tool %s not installed", strat.Package))
+		case agents.KindAsdf:
+			if !env.asdfShimHas(agent.Binary) {
+				trace.reject(strat, fmt.Sprintf("asdf not available or %s not an asdf shim", agent.Binary))
+				continue
+			}
+			detail = fmt.Sprintf("%s resolves to asdf shims; updating asdf plugin %s", agent.Binary, strat.Plugin)
+			trace.selected(strat, detail)
+			return asdfUpdateCommand(strat.Plugin, env.asdfLegacy()), "", strat.Kind, detail
+		case agents.KindExec:
+			if !env.execDetect(strat.DetectCommand) {
+				trace.reject(strat, fmt.Sprintf("detect command `%s` failed", cmdString(strat.DetectCommand)))
+				continue
 			}
+			detail = fmt.Sprintf("detect command `%s` succeeded; using configured update", cmdString(strat.DetectCommand))
+			trace.selected(strat, detail)
+			return strat.Command, "", strat.Kind, detail
 		case agents.KindVSCode:
 			if env.codeCmd == "" {
-				codeMissing = true
+				codeCLIMissing = true
+				trace.reject(strat, "VS Code CLI not found")
 				continue
 			}
 			if env.vscodeHas(strat.ExtensionID) {
 				detail = fmt.Sprintf("VS Code extension %s installed (via %s)", strat.ExtensionID, env.codeCmd)
+				trace.selected(strat, detail)
 				return []string{env.codeCmd, "--install-extension", strat.ExtensionID, "--force"}, "", strat.Kind, detail
 			}
+			extMissing = env.codeExtensionDiagnostic(strat.ExtensionID)
+			trace.reject(strat, extMissing)
+		default:
+			trace.reject(strat, "unsupported strategy kind")
 		}
 	}
 
-	if codeMissing {
-		return nil, reasonMissingCode, "", "VS Code CLI not found (code/codium/code-insiders)"
+	if warning := env.detectTimeoutWarning(agent); warning != "" {
+		return nil, codeDetectTimeout, "", warning
+	}
+	if codeCLIMissing {
+		return nil, codeMissingVSCode, "", "VS Code CLI not found (code/codium/code-insiders)"
+	}
+	if mismatch != "" {
+		return nil, codeManualInstall, "", withManualInstructions(agent, mismatch+"; skipped so uca doesn't update the wrong package")
 	}
 	if agent.Binary != "" && env.hasBinary(agent.Binary) {
-		return nil, reasonManualInstall, "", "binary found but no supported install method detected"
+		if hasStrategyKind(agent, agents.KindYarn) && env.yarnBerry() {
+			return nil, codeManualInstall, "", withManualInstructions(agent, fmt.Sprintf("binary found; yarn %s is Yarn Berry (2+), which has no `yarn global`, so the yarn strategy was skipped; reinstall with npm/pnpm/bun or update it manually", env.yarnVersion))
+		}
+		manual := env.withNodeVersionNote(agent.Binary, appendNote("binary found but no supported install method detected", extMissing))
+		return nil, codeManualInstall, "", withManualInstructions(agent, manual)
+	}
+	return nil, codeMissing, "", appendNote("no supported binary or install method detected", extMissing)
+}
+
+const (
+	preferNative  = "native"
+	preferPackage = "package"
+)
+
+// preferredStrategies reorders strategies for --prefer: native updaters first or last, keeping the
+// agent's order within each group. Anything other than a native updater counts as a package.
+func preferredStrategies(strategies []agents.UpdateStrategy, prefer string) []agents.UpdateStrategy {
+	if prefer != preferNative && prefer != preferPackage {
+		return strategies
+	}
+	first := []agents.UpdateStrategy{}
+	rest := []agents.UpdateStrategy{}
+	for _, strat := range strategies {
+		if (strat.Kind == agents.KindNative) == (prefer == preferNative) {
+			first = append(first, strat)
+		} else {
+			rest = append(rest, strat)
+		}
+	}
+	return append(first, rest...)
+}
+
+// reportedStrategies moves the strategies of kind (the agent's own MethodCmd answer) to the front, keeping
+// the order within each group.
+func reportedStrategies(strategies []agents.UpdateStrategy, kind string) []agents.UpdateStrategy {
+	if kind == "" {
+		return strategies
+	}
+	first := []agents.UpdateStrategy{}
+	rest := []agents.UpdateStrategy{}
+	for _, strat := range strategies {
+		if strat.Kind == kind {
+			first = append(first, strat)
+		} else {
+			rest = append(rest, strat)
+		}
+	}
+	return append(first, rest...)
+}
+
+// reportedInstallMethod runs the agent's MethodCmd and returns the strategy kind its output names, with a
+// note for --explain. A command that is missing, fails, or names none of the agent's kinds returns "".
+func (e *envState) reportedInstallMethod(agent agents.Agent) (string, string) {
+	if len(agent.MethodCmd) == 0 || !e.hasBinary(agent.MethodCmd[0]) {
+		return "", ""
+	}
+	// Timeouts are keyed by strategy kind; this command belongs to none, so its timeout isn't reported.
+	out, exitCode, _, _ := e.runDetect("", agent.MethodCmd)
+	if exitCode != 0 {
+		return "", fmt.Sprintf("`%s` failed (exit %d); using detection", cmdString(agent.MethodCmd), exitCode)
+	}
+	kinds := map[string]bool{}
+	for _, strat := range agent.Strategies {
+		kinds[strat.Kind] = true
+	}
+	kind := parseInstallMethod(out, kinds)
+	if kind == "" {
+		return "", fmt.Sprintf("`%s` named no known install method; using detection", cmdString(agent.MethodCmd))
+	}
+	return kind, fmt.Sprintf("`%s` reports install method %s", cmdString(agent.MethodCmd), kind)
+}
+
+// parseInstallMethod returns the first word of out that is one of kinds, e.g. "npm" from
+// "Installed via npm (global)". "homebrew" counts as brew.
+func parseInstallMethod(out string, kinds map[string]bool) string {
+	words := strings.FieldsFunc(strings.ToLower(out), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	for _, word := range words {
+		if word == "homebrew" {
+			word = agents.KindBrew
+		}
+		if kinds[word] {
+			return word
+		}
+	}
+	return ""
+}
+
+// withManualInstructions appends the agent's configured manual update instructions to a manual-install detail.
+func withManualInstructions(agent agents.Agent, detail string) string {
+	return appendHint(detail, agent.ManualInstructions)
+}
+
+func binaryMismatchDetail(binary string, strat agents.UpdateStrategy) string {
+	return fmt.Sprintf("%s is in the %s global bin, but %s's global packages don't include %s; it may be a different tool with the same name", binary, strat.Kind, strat.Kind, strat.Package)
+}
+
+func hasStrategyKind(agent agents.Agent, kind string) bool {
+	for _, strat := range agent.Strategies {
+		if strat.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func shouldInstallMissing(opts options) bool {
+	return opts.InstallAllMissing || (opts.InstallMissing && strings.TrimSpace(opts.Only) != "")
+}
+
+// installCommand picks an install command for an agent that is not present. Native strategies are
+// skipped because they update an existing binary. Node managers follow --manager-priority when set.
+func installCommand(agent agents.Agent, env *envState) ([]string, string, string) {
+	available := []string{}
+	for _, strat := range agent.Strategies {
+		if isNodeKind(strat.Kind) && strat.Package != "" && env.hasNodeManager(strat.Kind) {
+			available = append(available, strat.Kind)
+		}
+	}
+	preferred := pickByPriority(available, env.managerPriority)
+
+	for _, strat := range agent.Strategies {
+		switch strat.Kind {
+		case agents.KindBun, agents.KindNpm, agents.KindPnpm, agents.KindYarn:
+			if strat.Package == "" || !env.hasNodeManager(strat.Kind) {
+				continue
+			}
+			if preferred != "" && strat.Kind != preferred {
+				continue
+			}
+			return nodeUpdateCommand(strat), strat.Kind, fmt.Sprintf("not installed; installing %s via %s", strat.Package, strat.Kind)
+		case agents.KindBrew:
+			if env.hasBrew {
+				return []string{"brew", "install", strat.Package}, strat.Kind, fmt.Sprintf("not installed; installing brew formula %s", strat.Package)
+			}
+		case agents.KindUv:
+			if env.hasUv {
+				return uvToolInstallCommand(strat.Package), strat.Kind, fmt.Sprintf("not installed; installing uv tool %s", strat.Package)
+			}
+		case agents.KindPip:
+			if env.hasPython {
+				return []string{"python3", "-m", "pip", "install", strat.Package}, strat.Kind, fmt.Sprintf("not installed; installing pip package %s", strat.Package)
+			}
+		case agents.KindVSCode:
+			if env.codeCmd != "" {
+				return []string{env.codeCmd, "--install-extension", strat.ExtensionID}, strat.Kind, fmt.Sprintf("not installed; installing VS Code extension %s (via %s)", strat.ExtensionID, env.codeCmd)
+			}
+		}
+	}
+	return nil, "", ""
+}
+
+func uvToolInstallCommand(pkg string) []string {
+	return []string{"uv", "tool", "install", "--force", "--python", "python3.12", "--with", "pip", pkg + "@latest"}
+}
+
+// packageUpdateCommand is the update command for an installed brew, pip, uv, or node package strategy, or
+// nil for other kinds.
+func packageUpdateCommand(strat agents.UpdateStrategy) []string {
+	switch strat.Kind {
+	case agents.KindBrew:
+		return []string{"brew", "upgrade", strat.Package}
+	case agents.KindPip:
+		return []string{"python3", "-m", "pip", "install", "-U", "--upgrade-strategy", "only-if-needed", strat.Package}
+	case agents.KindUv:
+		return []string{"uv", "tool", "upgrade", strat.Package}
+	case agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun:
+		return nodeUpdateCommand(strat)
 	}
-	return nil, reasonMissing, "", "no supported binary or install method detected"
+	return nil
 }
 
 func nodeUpdateCommand(strat agents.UpdateStrategy) []string {
 	if len(strat.Command) > 0 {
 		return strat.Command
 	}
+	spec := strat.Package + "@" + distTagOrLatest(strat.Tag)
 	switch strat.Kind {
 	case agents.KindNpm:
 		// Force `@latest` to avoid getting stuck on old minor/prerelease versions (common for 0.x CLIs).
 		// `npm update -g` does not accept `pkg@latest` specs, so we use install.
-		return []string{"npm", "install", "-g", strat.Package + "@latest"}
+		return []string{"npm", "install", "-g", spec}
 	case agents.KindPnpm:
-		return []string{"pnpm", "add", "-g", strat.Package + "@latest"}
+		return []string{"pnpm", "add", "-g", spec}
 	case agents.KindYarn:
-		return []string{"yarn", "global", "add", strat.Package + "@latest"}
+		return []string{"yarn", "global", "add", spec}
 	case agents.KindBun:
-		return []string{"bun", "add", "-g", strat.Package + "@latest"}
+		return []string{"bun", "add", "-g", spec}
 	default:
 		return strat.Command
 	}
@@ -1242,6 +4535,16 @@ func nodePackageName(strategies []agents.UpdateStrategy) string {
 	return ""
 }
 
+// nodePackageTag returns the dist-tag configured on the agent's node strategies ("" means latest).
+func nodePackageTag(strategies []agents.UpdateStrategy) string {
+	for _, strat := range strategies {
+		if isNodeKind(strat.Kind) && strat.Package != "" {
+			return strat.Tag
+		}
+	}
+	return ""
+}
+
 const versionCmdTimeout = 10 * time.Second
 
 func getVersion(ctx context.Context, agent agents.Agent, env *envState, method string) string {
@@ -1252,7 +4555,22 @@ func getVersion(ctx context.Context, agent agents.Agent, env *envState, method s
 	}
 	if len(agent.VersionCmd) > 0 {
 		if agent.Binary == "" || env.hasBinary(agent.Binary) {
-			return runVersionCmd(ctx, agent.VersionCmd)
+			if version := runVersionCmd(ctx, env.commands(), versionCommand(agent)); version != "unknown" {
+				return version
+			}
+			// A version manager shim can fail outside a directory that pins a version; ask the manager
+			// to run the real binary instead.
+			if prefix := env.shimExecPrefix(agent.Binary); len(agent.VersionPrefix) == 0 && prefix != nil {
+				if version := runVersionCmd(ctx, env.commands(), append(prefix, agent.VersionCmd...)); version != "unknown" {
+					return version
+				}
+			}
+		}
+	}
+	if isNodeKind(method) {
+		// Fall back to the manager's package list when the CLI can't report its own version.
+		if version := env.nodePackageVersion(method, nodePackageName(agent.Strategies)); version != "" {
+			return version
 		}
 	}
 	if agent.ExtensionID != "" {
@@ -1263,6 +4581,14 @@ func getVersion(ctx context.Context, agent agents.Agent, env *envState, method s
 	return "unknown"
 }
 
+// versionCommand is the agent's version command with its configured prefix, e.g. `mise exec -- pi --version`.
+func versionCommand(agent agents.Agent) []string {
+	if len(agent.VersionPrefix) == 0 {
+		return agent.VersionCmd
+	}
+	return append(append([]string{}, agent.VersionPrefix...), agent.VersionCmd...)
+}
+
 const latestVersionCmdTimeout = 12 * time.Second
 
 var semverTokenRe = regexp.MustCompile(`(?i)\bv?\d+\.\d+(?:\.\d+)?(?:-[0-9a-z.-]+)?(?:\+[0-9a-z.-]+)?\b`)
@@ -1297,27 +4623,110 @@ func formatVersionWithToken(before, newVersion string) string {
 	return strings.Replace(before, token, newVersion, 1)
 }
 
-func nodeLatestVersion(ctx context.Context, kind, pkg string) string {
+// marketplaceQueryURL is the VS Code Marketplace gallery endpoint that `code --install-extension` uses.
+var marketplaceQueryURL = "https://marketplace.visualstudio.com/_apis/public/gallery/extensionquery"
+
+// marketplaceLatestVersion returns the newest stable version of a VS Code extension from the marketplace,
+// or "" when the query fails (offline, proxy, unknown id).
+func marketplaceLatestVersion(ctx context.Context, extID string) string {
+	extID = strings.TrimSpace(extID)
+	if extID == "" {
+		return ""
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, latestVersionCmdTimeout)
+	defer cancel()
+
+	// filterType 7 matches "publisher.name"; flags 0x11 include versions and their properties.
+	body, err := json.Marshal(map[string]any{
+		"filters": []map[string]any{{"criteria": []map[string]any{{"filterType": 7, "value": extID}}}},
+		"flags":   0x11,
+	})
+	if err != nil {
+		return ""
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, marketplaceQueryURL, bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json;api-version=3.0-preview.1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ""
+	}
+	return parseMarketplaceLatest(data)
+}
+
+// parseMarketplaceLatest picks the first (newest) version that is not flagged as a pre-release.
+func parseMarketplaceLatest(data []byte) string {
+	var payload struct {
+		Results []struct {
+			Extensions []struct {
+				Versions []struct {
+					Version    string `json:"version"`
+					Properties []struct {
+						Key   string `json:"key"`
+						Value string `json:"value"`
+					} `json:"properties"`
+				} `json:"versions"`
+			} `json:"extensions"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return ""
+	}
+	for _, res := range payload.Results {
+		for _, ext := range res.Extensions {
+			for _, v := range ext.Versions {
+				preRelease := false
+				for _, prop := range v.Properties {
+					if prop.Key == "Microsoft.VisualStudio.Code.PreRelease" && prop.Value == "true" {
+						preRelease = true
+					}
+				}
+				if !preRelease && v.Version != "" {
+					return v.Version
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// nodeLatestVersion queries the registry for the version behind a dist-tag ("" means latest).
+func nodeLatestVersion(ctx context.Context, runner commandRunner, kind, pkg, tag string) string {
 	pkg = strings.TrimSpace(pkg)
 	if pkg == "" {
 		return ""
 	}
+	tag = distTagOrLatest(tag)
 	args := []string{}
 	switch kind {
 	case agents.KindNpm:
-		args = []string{"npm", "view", pkg, "dist-tags.latest"}
+		args = []string{"npm", "view", pkg, "dist-tags." + tag}
 	case agents.KindPnpm:
-		args = []string{"pnpm", "view", pkg, "dist-tags.latest", "--silent"}
+		args = []string{"pnpm", "view", pkg, "dist-tags." + tag, "--silent"}
 	case agents.KindYarn:
-		args = []string{"yarn", "info", pkg, "dist-tags.latest", "--silent"}
+		args = []string{"yarn", "info", pkg, "dist-tags." + tag, "--silent"}
 	case agents.KindBun:
 		// `bun info` needs `-g` to work outside of a JS project.
-		args = []string{"bun", "info", "-g", pkg, "version", "--json"}
+		args = []string{"bun", "info", "-g", pkg + "@" + tag, "version", "--json"}
 	default:
 		return ""
 	}
 
-	out, exitCode, _, _ := runCmdStdout(ctx, args, latestVersionCmdTimeout)
+	out, exitCode, _, _ := runner.Output(ctx, args, latestVersionCmdTimeout)
 	if exitCode != 0 {
 		return ""
 	}
@@ -1326,34 +4735,75 @@ func nodeLatestVersion(ctx context.Context, kind, pkg string) string {
 	return strings.TrimSpace(trimmed)
 }
 
-func runVersionCmd(ctx context.Context, args []string) string {
+func runVersionCmd(ctx context.Context, runner commandRunner, args []string) string {
 	if len(args) == 0 {
 		return "unknown"
 	}
-	if ctx == nil {
-		ctx = context.Background()
+	out, exitCode, _, _ := runner.Run(ctx, args, versionCmdTimeout)
+	switch exitCode {
+	case 0:
+		return parseVersionOutput(out)
+	case exitCodeTimeout, exitCodeCanceled, 127:
+		return "unknown"
 	}
-	cmdCtx, cancel := context.WithTimeout(ctx, versionCmdTimeout)
-	defer cancel()
+	// Some CLIs exit non-zero from --version but still print it.
+	return parseFailedVersionOutput(out)
+}
 
-	cmd := exec.CommandContext(cmdCtx, args[0], args[1:]...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
+// versionErrorMarkers mark output lines that describe a failure rather than a version, e.g. a shim's
+// "No version is set for command pi" that happens to mention one.
+var versionErrorMarkers = []string{"error", "not found", "no version", "not installed", "unknown", "invalid", "usage", "failed"}
+
+// parseFailedVersionOutput is parseVersionOutput for a version command that exited non-zero: it accepts a
+// version-only line, or else the first line carrying a version token when no line reads like an error,
+// and otherwise gives up with "unknown".
+func parseFailedVersionOutput(out string) string {
+	candidate := ""
+	sawError := false
+	for _, line := range outputLines(out) {
+		if isVersionOnlyLine(line) {
+			return line
+		}
+		if looksLikeVersionError(line) {
+			sawError = true
+			continue
+		}
+		if _, ok := extractVersionToken(line); ok && candidate == "" {
+			candidate = line
+		}
+	}
+	if candidate == "" || sawError {
 		return "unknown"
 	}
-	return parseVersionOutput(string(out))
+	return candidate
 }
 
-func parseVersionOutput(out string) string {
-	trimmed := strings.TrimSpace(out)
-	if trimmed == "" {
-		return "unknown"
+func looksLikeVersionError(line string) bool {
+	lower := strings.ToLower(line)
+	for _, marker := range versionErrorMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// outputLines splits command output into trimmed lines. "\r\n" (Windows) and a bare "\r" (a progress line
+// redrawn in place) both end a line, so Windows output parses the same as Unix output.
+func outputLines(out string) []string {
+	out = strings.ReplaceAll(out, "\r\n", "\n")
+	out = strings.ReplaceAll(out, "\r", "\n")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
 	}
-	lines := strings.Split(trimmed, "\n")
+	return lines
+}
+
+func parseVersionOutput(out string) string {
 	first := ""
 	versionOnly := ""
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+	for _, line := range outputLines(out) {
 		if line == "" {
 			continue
 		}
@@ -1402,7 +4852,20 @@ const (
 	exitCodeCanceled = 130
 )
 
-func runCmd(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error) {
+// commandRunner executes external commands. envState holds one so tests can replace real binaries with
+// a fake; both methods return the output, exit code (exitCodeTimeout/exitCodeCanceled when the context
+// ends the command), and duration.
+type commandRunner interface {
+	// Run returns combined stdout and stderr.
+	Run(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error)
+	// Output returns stdout only, for commands whose output is parsed.
+	Output(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error)
+}
+
+// execRunner is the commandRunner backed by os/exec.
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
@@ -1415,6 +4878,7 @@ func runCmd(ctx context.Context, args []string, timeout time.Duration) (string,
 	defer cancel()
 
 	cmd := exec.CommandContext(cmdCtx, args[0], args[1:]...)
+	cmd.Dir = commandDir(args)
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = &buf
@@ -1433,69 +4897,260 @@ func runCmd(ctx context.Context, args []string, timeout time.Duration) (string,
 	if exitErr, ok := err.(*exec.ExitError); ok {
 		return buf.String(), exitErr.ExitCode(), duration, err
 	}
-	return buf.String(), 1, duration, err
+	return buf.String(), 1, duration, err
+}
+
+func (execRunner) Output(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	start := time.Now()
+	cmdCtx := ctx
+	cancel := func() {}
+	if timeout > 0 {
+		cmdCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	cmd := exec.CommandContext(cmdCtx, args[0], args[1:]...)
+	cmd.Dir = commandDir(args)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	duration := time.Since(start)
+	if err == nil {
+		return string(out), 0, duration, nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return string(out), exitCodeTimeout, duration, err
+	}
+	if errors.Is(err, context.Canceled) {
+		return string(out), exitCodeCanceled, duration, err
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), exitErr.ExitCode(), duration, err
+	}
+	return string(out), 1, duration, err
+}
+
+// nodeManagerCommands are executables that read a project .npmrc (or .yarnrc, bunfig.toml) from the
+// working directory, even for global operations.
+var nodeManagerCommands = map[string]bool{"npm": true, "npx": true, "pnpm": true, "yarn": true, "bun": true, "corepack": true}
+
+// commandDir is the working directory for args: node package managers run from the home directory (else
+// the temp dir) so a project config in the directory uca was launched from can't switch their registry
+// or prefix. "" keeps the current directory.
+func commandDir(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	name := strings.ToLower(filepath.Base(args[0]))
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if !nodeManagerCommands[name] {
+		return ""
+	}
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		return home
+	}
+	return os.TempDir()
 }
 
-func runUpdateCmd(ctx context.Context, args []string, timeout time.Duration) (string, string, int, time.Duration, error) {
-	out, exitCode, duration, err := runCmd(ctx, args, timeout)
+// runUpdateCmd runs an update command with uca's recovery steps (rerun without --fast flags npm warned it
+// doesn't know, npm ENOTEMPTY retry, uv force reinstall, --clean-reinstall). gate confirms the clean
+// reinstall, which uninstalls the package first; when the install after it fails too, the error is
+// errRemovedNotReinstalled.
+func runUpdateCmd(ctx context.Context, runner commandRunner, args []string, timeout time.Duration, cleanReinstall bool, gate *confirmGate) (string, string, int, time.Duration, error) {
+	out, exitCode, duration, err := runner.Run(ctx, args, timeout)
 	classifyOut := out
 	if exitCode == 0 {
 		return out, classifyOut, exitCode, duration, err
 	}
+	if rejectedNpmFastFlags(args, exitCode, out) {
+		args = withoutArgs(args, npmFastFlags)
+		retryOut, retryCode, retryDuration, retryErr := runner.Run(ctx, args, timeout)
+		combined := strings.TrimRight(out, "\n") + "\n\n(uca) npm failed and doesn't know --no-fund/--no-audit; retrying without them\n(uca) " + cmdString(args) + "\n" + strings.TrimSpace(retryOut)
+		classifyOut = retryOut
+		if strings.TrimSpace(classifyOut) == "" {
+			classifyOut = out
+		}
+		out, exitCode, duration, err = strings.TrimLeft(combined, "\n"), retryCode, duration+retryDuration, retryErr
+		if exitCode == 0 {
+			return out, classifyOut, exitCode, duration, err
+		}
+	}
+	retried := false
 	if shouldRetryNpm(args, out) {
+		retried = true
 		cleanupMsg := cleanupNpmENotEmpty(out)
-		retryOut, retryCode, retryDuration, retryErr := runCmd(ctx, args, timeout)
+		retryOut, retryCode, retryDuration, retryErr := runner.Run(ctx, args, timeout)
 		combined := formatRetryOutput(out, cleanupMsg, retryOut)
 		classifyOut = retryOut
 		if strings.TrimSpace(classifyOut) == "" {
 			classifyOut = out
 		}
-		return combined, classifyOut, retryCode, duration + retryDuration, retryErr
+		out, exitCode, duration, err = combined, retryCode, duration+retryDuration, retryErr
+	}
+	if pkg, ok := uvToolUpgradeTarget(args); ok && exitCode != exitCodeTimeout && exitCode != exitCodeCanceled {
+		// `uv tool upgrade` can't repair a tool whose environment broke (e.g. its Python was removed);
+		// a forced reinstall can.
+		install := uvToolInstallCommand(pkg)
+		installOut, installCode, installDuration, installErr := runner.Run(ctx, install, timeout)
+		combined := strings.TrimRight(out, "\n") + "\n\n(uca) uv tool upgrade failed; reinstalling with --force\n(uca) " + cmdString(install) + "\n" + strings.TrimSpace(installOut)
+		classifyOut = installOut
+		if strings.TrimSpace(classifyOut) == "" {
+			classifyOut = out
+		}
+		return strings.TrimLeft(combined, "\n"), classifyOut, installCode, duration + installDuration, installErr
+	}
+	// The clean reinstall is the last resort for an install the ENOTEMPTY retry could not fix, never a first
+	// response to a failure.
+	if cleanReinstall && retried && exitCode != 0 && exitCode != exitCodeTimeout && exitCode != exitCodeCanceled {
+		if pkg, ok := npmSingleGlobalInstall(args); ok && gate.allow(fmt.Sprintf("`%s` still fails. Uninstall %s and install it again?", cmdString(args), pkg)) {
+			uninstall := []string{"npm", "uninstall", "-g", pkg}
+			uninstallOut, uninstallCode, uninstallDuration, _ := runner.Run(ctx, uninstall, timeout)
+			duration += uninstallDuration
+			if uninstallCode != 0 {
+				// The package may be half removed; installing over it is no better than the retry was.
+				combined := formatCleanReinstallOutput(out, uninstall, uninstallOut, nil, "")
+				combined += fmt.Sprintf("\n(uca) npm uninstall failed (exit %d); not reinstalling", uninstallCode)
+				return combined, classifyOut, exitCode, duration, err
+			}
+			installOut, installCode, installDuration, installErr := runner.Run(ctx, args, timeout)
+			combined := formatCleanReinstallOutput(out, uninstall, uninstallOut, args, installOut)
+			classifyOut = installOut
+			if strings.TrimSpace(classifyOut) == "" {
+				classifyOut = out
+			}
+			if installCode != 0 {
+				combined += fmt.Sprintf("\n(uca) %s was uninstalled and the install failed; it is no longer installed", pkg)
+				installErr = errRemovedNotReinstalled
+			}
+			return combined, classifyOut, installCode, duration + installDuration, installErr
+		}
 	}
 	return out, classifyOut, exitCode, duration, err
 }
 
+// errRemovedNotReinstalled is runUpdateCmd's error when --clean-reinstall uninstalled the package and could
+// not install it again.
+var errRemovedNotReinstalled = errors.New("package removed and not reinstalled")
+
+// markRemovedNotReinstalled rewrites a failed result whose recovery uninstalled the package and could not
+// install it again: that the agent is gone matters more than why the install failed.
+func markRemovedNotReinstalled(res *result, install []string) {
+	cause := res.Reason()
+	res.ReasonCode, res.ReasonDetail = codeUninstalled, ""
+	res.Explain = appendHint(res.Explain, fmt.Sprintf("%s was uninstalled and reinstalling it failed (%s), so it is no longer installed; run `%s` once that is fixed", res.Agent.Name, cause, cmdString(install)))
+}
+
+// npmSingleGlobalInstall returns the package name when args is `npm install -g <one spec>`. Batch installs
+// are excluded so --clean-reinstall never uninstalls more than the failing package.
+func npmSingleGlobalInstall(args []string) (string, bool) {
+	if len(args) < 3 || args[0] != "npm" || args[1] != "install" {
+		return "", false
+	}
+	global := false
+	specs := []string{}
+	for _, arg := range args[2:] {
+		switch {
+		case arg == "-g" || arg == "--global":
+			global = true
+		case strings.HasPrefix(arg, "-"):
+		default:
+			specs = append(specs, arg)
+		}
+	}
+	if !global || len(specs) != 1 {
+		return "", false
+	}
+	spec := specs[0]
+	// Scoped packages start with "@"; the version separator is the last "@" after that.
+	if idx := strings.LastIndex(spec, "@"); idx > 0 {
+		spec = spec[:idx]
+	}
+	return spec, spec != ""
+}
+
+func formatCleanReinstallOutput(first string, uninstall []string, uninstallOut string, install []string, installOut string) string {
+	var b strings.Builder
+	if first = strings.TrimRight(first, "\n"); first != "" {
+		b.WriteString(first + "\n\n")
+	}
+	b.WriteString("(uca) npm install still failing; clean reinstall (--clean-reinstall)\n")
+	b.WriteString("(uca) " + cmdString(uninstall))
+	if uninstallOut = strings.TrimSpace(uninstallOut); uninstallOut != "" {
+		b.WriteString("\n" + uninstallOut)
+	}
+	if install == nil {
+		return b.String()
+	}
+	b.WriteString("\n(uca) " + cmdString(install))
+	if installOut = strings.TrimSpace(installOut); installOut != "" {
+		b.WriteString("\n" + installOut)
+	}
+	return b.String()
+}
+
 func setFailureResult(res *result, exitCode int, updateCmd []string, output string, timeout time.Duration) {
 	res.Status = statusFailed
 	switch exitCode {
 	case exitCodeTimeout:
-		res.Reason = "timeout"
-		if timeout > 0 {
-			res.Explain = appendHint(res.Explain, fmt.Sprintf("command timed out after %s; rerun with --timeout 0 or increase it", timeout.Round(time.Second)))
-		} else {
+		res.ReasonCode = codeTimeout
+		switch {
+		case timeout > 0 && timeout < minPlausibleTimeout:
+			res.Explain = appendHint(res.Explain, fmt.Sprintf("command timed out after %s, which is likely too short for an update; raise --timeout (0 disables it)", timeout.Round(time.Second)))
+		case timeout > 0:
+			res.Explain = appendHint(res.Explain, fmt.Sprintf("command timed out after %s and may be hung; rerun with --verbose to see where it stopped, or --timeout 0 to wait it out", timeout.Round(time.Second)))
+		default:
 			res.Explain = appendHint(res.Explain, "command timed out; rerun with a larger --timeout")
 		}
 		return
 	case exitCodeCanceled:
-		res.Reason = "canceled"
+		res.ReasonCode = codeCanceled
 		res.Explain = appendHint(res.Explain, "interrupted; retry the update")
 		return
 	}
-	reason, hint := classifyUpdateFailure(updateCmd, output)
-	if reason == "" {
-		res.Reason = fmt.Sprintf("exit %d", exitCode)
+	res.ExitCode = exitCode
+	code, hint := classifyUpdateFailure(updateCmd, output)
+	if code == "" {
+		res.ReasonCode, res.ReasonDetail = codeExitStatus, fmt.Sprintf("exit %d", exitCode)
 	} else {
-		res.Reason = reason
+		res.ReasonCode = code
 	}
 	if hint != "" {
 		res.Explain = appendHint(res.Explain, hint)
 	}
+	if res.Method == agents.KindNative {
+		// A broken self-updater is a dead end without the agent's own instructions.
+		res.Explain = appendHint(res.Explain, res.Agent.ManualInstructions)
+	}
 }
 
-func classifyUpdateFailure(updateCmd []string, output string) (string, string) {
+// classifyUpdateFailure maps a failed command's output to a reason code and a hint; "" means unrecognized.
+func classifyUpdateFailure(updateCmd []string, output string) (reasonCode, string) {
 	lower := strings.ToLower(output)
 	if strings.Contains(output, "TerminalQuotaError") ||
 		strings.Contains(lower, "exhausted your capacity") ||
 		strings.Contains(lower, "quota will reset") {
-		return reasonQuota, "quota exceeded; retry later or update via npm (@google/gemini-cli)"
+		return codeQuota, "quota exceeded; retry later or update via npm (@google/gemini-cli)"
 	}
 	if isNpmGlobalMutate(updateCmd) && (strings.Contains(output, "ENOTEMPTY") ||
 		strings.Contains(output, "errno -66") ||
 		strings.Contains(lower, "directory not empty")) {
-		return reasonNpmNotEmpty, "npm rename failed; retry or remove leftover temp directory under the global npm prefix"
+		return codeNpmNotEmpty, "npm rename failed; retry or remove leftover temp directory under the global npm prefix"
+	}
+	if strings.Contains(output, "ERESOLVE") ||
+		strings.Contains(lower, "could not resolve dependency") ||
+		strings.Contains(lower, "conflicting peer dependency") {
+		return codeDepConflict, dependencyConflictHint(updateCmd)
+	}
+	if len(updateCmd) > 0 && updateCmd[0] == "pnpm" {
+		if code, hint := classifyPnpmFailure(output); code != "" {
+			return code, hint
+		}
 	}
 	if strings.Contains(lower, "eacces") || strings.Contains(lower, "eperm") || strings.Contains(lower, "permission denied") {
-		return "permission", "permission error; check your global install prefix and file permissions"
+		return codePermission, "permission error; check your global install prefix and file permissions"
 	}
 	if strings.Contains(lower, "etimedout") ||
 		strings.Contains(lower, "timed out") ||
@@ -1504,24 +5159,67 @@ func classifyUpdateFailure(updateCmd []string, output string) (string, string) {
 		strings.Contains(lower, "eai_again") ||
 		strings.Contains(lower, "econnrefused") ||
 		strings.Contains(lower, "socket hang up") {
-		return "network", "network error; check connectivity/proxy/VPN and retry"
+		return codeNetwork, "network error; check connectivity/proxy/VPN and retry"
 	}
 	if strings.Contains(lower, "self signed certificate") ||
 		strings.Contains(lower, "unable to get local issuer certificate") ||
 		strings.Contains(lower, "cert has expired") ||
 		strings.Contains(lower, "ssl routines") ||
 		strings.Contains(lower, "tls") && strings.Contains(lower, "certificate") {
-		return "tls", "TLS/CA error; check corporate proxy settings or system certificates"
+		return codeTLS, "TLS/CA error; check corporate proxy settings or system certificates"
 	}
 	if len(updateCmd) > 0 && updateCmd[0] == "brew" &&
 		(strings.Contains(lower, "another active homebrew update process") ||
 			strings.Contains(lower, "homebrew is already updating") ||
 			strings.Contains(lower, "cannot install in homebrew prefix")) {
-		return "brew busy", "homebrew is locked/busy; wait for other brew process and retry"
+		return codeBrewBusy, "homebrew is locked/busy; wait for other brew process and retry"
+	}
+	return "", ""
+}
+
+// dependencyConflictHint suggests the way around a peer dependency conflict for the command that hit it.
+func dependencyConflictHint(updateCmd []string) string {
+	if len(updateCmd) == 0 || updateCmd[0] != "npm" {
+		return "peer dependency conflict; the release's dependencies don't resolve, so retry later or install a pinned version"
+	}
+	for _, arg := range updateCmd {
+		if arg == "--legacy-peer-deps" {
+			return "peer dependency conflict (ERESOLVE) even with --legacy-peer-deps; retry later or install with `--force`"
+		}
+	}
+	return "peer dependency conflict (ERESOLVE); rerun with --legacy-peer-deps to install despite it"
+}
+
+// classifyPnpmFailure recognizes pnpm's store and lockfile errors, which otherwise surface as a bare exit 1.
+func classifyPnpmFailure(output string) (reasonCode, string) {
+	lower := strings.ToLower(output)
+	switch {
+	case strings.Contains(output, "ERR_PNPM_TARBALL_INTEGRITY") ||
+		strings.Contains(output, "ERR_PNPM_BAD_TARBALL_SIZE"):
+		return codePnpmIntegrity, "pnpm store has a corrupted tarball; run `pnpm store prune` and retry"
+	case strings.Contains(output, "ERR_PNPM_UNEXPECTED_STORE") ||
+		strings.Contains(output, "ERR_PNPM_STORE"):
+		return codePnpmStore, "pnpm store conflict; run `pnpm store prune` (or check `pnpm store path` matches the global install) and retry"
+	case strings.Contains(output, "ERR_PNPM_OUTDATED_LOCKFILE") ||
+		strings.Contains(output, "ERR_PNPM_LOCKFILE_") ||
+		strings.Contains(lower, "lockfile") && (strings.Contains(lower, "mismatch") || strings.Contains(lower, "not up to date")):
+		return codePnpmLockfile, "pnpm global lockfile is out of date; run `pnpm install -g` in the global dir (`pnpm root -g`) or remove its lockfile and retry"
 	}
 	return "", ""
 }
 
+// appendNote adds a plain "; "-separated note to an --explain detail.
+func appendNote(detail, note string) string {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return detail
+	}
+	if strings.TrimSpace(detail) == "" {
+		return note
+	}
+	return detail + "; " + note
+}
+
 func appendHint(detail, hint string) string {
 	hint = strings.TrimSpace(hint)
 	if hint == "" {
@@ -1657,39 +5355,7 @@ func isSafeNpmRenameTarget(path, dest string) bool {
 	return true
 }
 
-const detectCmdTimeout = 30 * time.Second
-
-func runCmdStdout(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error) {
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	start := time.Now()
-	cmdCtx := ctx
-	cancel := func() {}
-	if timeout > 0 {
-		cmdCtx, cancel = context.WithTimeout(ctx, timeout)
-	}
-	defer cancel()
-
-	cmd := exec.CommandContext(cmdCtx, args[0], args[1:]...)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	out, err := cmd.Output()
-	duration := time.Since(start)
-	if err == nil {
-		return string(out), 0, duration, nil
-	}
-	if errors.Is(err, context.DeadlineExceeded) {
-		return string(out), exitCodeTimeout, duration, err
-	}
-	if errors.Is(err, context.Canceled) {
-		return string(out), exitCodeCanceled, duration, err
-	}
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		return string(out), exitErr.ExitCode(), duration, err
-	}
-	return string(out), 1, duration, err
-}
+const defaultDetectTimeout = 30 * time.Second
 
 func cmdString(args []string) string {
 	parts := make([]string, 0, len(args))
@@ -1706,162 +5372,772 @@ func quoteArg(arg string) string {
 	return fmt.Sprintf("%q", arg)
 }
 
-func printResults(results []result, opts options) {
-	if opts.Quiet {
-		return
-	}
-	for _, res := range results {
-		fmt.Fprintln(os.Stdout, formatResult(res, opts))
-		if opts.Explain {
-			if line := formatExplain(res); line != "" {
-				fmt.Fprintln(os.Stdout, line)
-			}
-		}
-	}
+func printResult(res result, opts options) {
+	line := formatResult(res, opts)
+	if resolveColor(opts.Color, isTTY(os.Stdout)) {
+		line = colorizeResultName(line, res)
+	}
+	fmt.Fprintln(os.Stdout, line)
+	if opts.Explain {
+		fmt.Fprintln(os.Stdout, formatExplain(res))
+	}
+}
+
+// printBeforeAfter prints one line per changed agent and a distinct line per failure.
+func printBeforeAfter(w io.Writer, results []result) {
+	for _, res := range results {
+		if line := formatBeforeAfter(res); line != "" {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func formatBeforeAfter(res result) string {
+	name := res.Agent.Name
+	switch res.Status {
+	case statusFailed:
+		reason := strings.TrimSpace(res.Reason())
+		if reason == "" {
+			reason = "unknown error"
+		}
+		return fmt.Sprintf("%s: FAILED (%s)", name, reason)
+	case statusUpdated:
+		if safeVersion(res.Before) == safeVersion(res.After) {
+			return ""
+		}
+		return fmt.Sprintf("%s: %s -> %s", name, safeVersion(res.Before), safeVersion(res.After))
+	default:
+		return ""
+	}
+}
+
+// printExplainDetails prints one --explain line per agent after the dashboard, including the agents it
+// never showed (missing ones, most skips), so their detection detail is not lost.
+func printExplainDetails(w io.Writer, results []result) {
+	for _, res := range results {
+		detail := explainDetail(res)
+		if res.Status == statusSkipped {
+			detail = fmt.Sprintf("skipped (%s); %s", res.Reason(), detail)
+		}
+		fmt.Fprintf(w, "%s: %s\n", res.Agent.Name, detail)
+	}
+}
+
+// explainDetail is the agent's --explain text, or a placeholder saying there is none.
+func explainDetail(res result) string {
+	if detail := strings.TrimSpace(res.Explain); detail != "" {
+		return detail
+	}
+	return "no detection detail recorded"
+}
+
+func formatResult(res result, opts options) string {
+	name := res.Agent.Name
+	switch res.Status {
+	case statusSkipped:
+		if res.ReasonCode == codeManualInstall && res.Agent.ManualInstructions != "" {
+			return fmt.Sprintf("%s: skipped (%s; %s)", name, res.Reason(), res.Agent.ManualInstructions)
+		}
+		return fmt.Sprintf("%s: skipped (%s)", name, res.Reason())
+	case statusFailed:
+		reason := strings.TrimSpace(res.Reason())
+		if reason != "" {
+			return fmt.Sprintf("%s: failed (%s; %s -> %s (%s))", name, reason, safeVersion(res.Before), safeVersion(res.After), fmtDuration(res.Duration))
+		}
+		return fmt.Sprintf("%s: failed (%s -> %s (%s))", name, safeVersion(res.Before), safeVersion(res.After), fmtDuration(res.Duration))
+	case statusUpdated:
+		if opts.DryRun {
+			if res.ReasonCode == codeWouldFail {
+				return fmt.Sprintf("%s: %s [%s]", name, res.UpdateCmd, res.Reason())
+			}
+			return fmt.Sprintf("%s: %s%s", name, res.UpdateCmd, dryRunVersionSuffix(res))
+		}
+		if res.ReasonCode == codeInstalled {
+			return fmt.Sprintf("%s: installed %s (%s)", name, safeVersion(res.After), fmtDuration(res.Duration))
+		}
+		if res.ReasonCode == codeReinstalled {
+			return fmt.Sprintf("%s: reinstalled %s (%s)", name, safeVersion(res.After), fmtDuration(res.Duration))
+		}
+		return fmt.Sprintf("%s: %s -> %s (%s)%s", name, safeVersion(res.Before), safeVersion(res.After), fmtDuration(res.Duration), partialSuffix(res))
+	case statusUnchanged:
+		return fmt.Sprintf("%s: unchanged %s -> %s (%s)%s", name, safeVersion(res.Before), safeVersion(res.After), fmtDuration(res.Duration), partialSuffix(res))
+	default:
+		return fmt.Sprintf("%s: unknown", name)
+	}
+}
+
+// dryRunVersionSuffix notes whether a dry-run agent is behind its latest known version.
+func dryRunVersionSuffix(res result) string {
+	before, after := safeVersion(res.Before), safeVersion(res.After)
+	switch {
+	case !hasLatestQuery(res.Method):
+		return " [target unknown]"
+	case before == "unknown":
+		return ""
+	case after == "unknown":
+		return " [latest unknown]"
+	case before != after && !sameVersionToken(before, after):
+		return fmt.Sprintf(" [outdated: %s -> %s]", before, after)
+	default:
+		return ""
+	}
+}
+
+func partialSuffix(res result) string {
+	if res.ReasonCode == codeBatchPartial {
+		return " [batch partial]"
+	}
+	return ""
+}
+
+// colorizeResultName colors the leading agent name of a formatted result line by status.
+func colorizeResultName(line string, res result) string {
+	name := res.Agent.Name
+	if !strings.HasPrefix(line, name) {
+		return line
+	}
+	status := res.Status
+	if status == statusUpdated && res.ReasonCode.dryRun() {
+		status = "dry-run"
+	}
+	return colorize(name, status, true) + line[len(name):]
+}
+
+func formatExplain(res result) string {
+	return fmt.Sprintf("  info: %s", explainDetail(res))
+}
+
+// formatVersionPair is the dashboard's "before → after" with both sides normalized by displayVersions.
+func formatVersionPair(before, after string) string {
+	before, after = displayVersions(before, after)
+	return before + " → " + after
+}
+
+// displayVersions normalizes a before/after pair for display only: a side with a version token shows just
+// the token, dropping tool names and suffixes such as "codex-cli " or " (Claude Code)", and a "v" prefix
+// on one side only is dropped, so "codex-cli 0.90.0-alpha.5" and "v0.98.0" read "0.90.0-alpha.5" and
+// "0.98.0". Reports and --format output keep the raw strings.
+func displayVersions(before, after string) (string, string) {
+	before, after = safeVersion(before), safeVersion(after)
+	tb, okB := extractVersionToken(before)
+	ta, okA := extractVersionToken(after)
+	if okB && okA && strings.HasPrefix(strings.ToLower(tb), "v") != strings.HasPrefix(strings.ToLower(ta), "v") {
+		tb, ta = tb[strings.IndexAny(tb, "0123456789"):], ta[strings.IndexAny(ta, "0123456789"):]
+	}
+	if okB {
+		before = tb
+	}
+	if okA {
+		after = ta
+	}
+	return before, after
+}
+
+func safeVersion(v string) string {
+	if strings.TrimSpace(v) == "" {
+		return "unknown"
+	}
+	return v
+}
+
+func fmtDuration(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%ds", seconds)
+}
+
+func printLogs(w io.Writer, results []result, opts options) {
+	if opts.DryRun {
+		return
+	}
+	type logGroup struct {
+		names []string
+		log   string
+	}
+	groups := map[string]*logGroup{}
+	order := []string{}
+
+	for _, res := range results {
+		if !showsLog(res, opts) {
+			continue
+		}
+		key := res.UpdateCmd + "\n" + res.Status + "\n" + res.Log
+		group := groups[key]
+		if group == nil {
+			group = &logGroup{log: res.Log}
+			groups[key] = group
+			order = append(order, key)
+		}
+		group.names = append(group.names, res.Agent.Name)
+	}
+
+	for _, key := range order {
+		group := groups[key]
+		printLog(w, strings.Join(group.names, ", "), group.log)
+	}
+	if opts.GroupFailures {
+		fmt.Fprint(w, formatFailureClasses(results))
+	}
+}
+
+// logStream decides what the dashboard prints above itself as agents finish. A batch shares one log, so
+// its members' logs are held until the last member finishes and then printed once under their joined
+// names, grouped the same way printLogs groups them.
+type logStream struct {
+	running map[string]int // batch members started but not yet finished, by update command
+	names   map[string][]string
+	logs    map[string]string
+	order   map[string][]string
+}
+
+func newLogStream() *logStream {
+	return &logStream{
+		running: map[string]int{},
+		names:   map[string][]string{},
+		logs:    map[string]string{},
+		order:   map[string][]string{},
+	}
+}
+
+// add records ev and returns the log text to print now, if any.
+func (s *logStream) add(ev updateEvent, opts options) string {
+	res := ev.Result
+	switch {
+	case ev.Phase == phaseStart && res.Batched:
+		s.running[res.UpdateCmd]++
+		return ""
+	case ev.Phase != phaseFinish:
+		return ""
+	}
+	var b strings.Builder
+	if !res.Batched {
+		if showsLog(res, opts) {
+			printLog(&b, res.Agent.Name, res.Log)
+		}
+		return b.String()
+	}
+	cmd := res.UpdateCmd
+	if showsLog(res, opts) {
+		key := cmd + "\n" + res.Status + "\n" + res.Log
+		if _, ok := s.names[key]; !ok {
+			s.logs[key] = res.Log
+			s.order[cmd] = append(s.order[cmd], key)
+		}
+		s.names[key] = append(s.names[key], res.Agent.Name)
+	}
+	s.running[cmd]--
+	if s.running[cmd] > 0 {
+		return ""
+	}
+	for _, key := range s.order[cmd] {
+		printLog(&b, strings.Join(s.names[key], ", "), s.logs[key])
+		delete(s.names, key)
+		delete(s.logs, key)
+	}
+	delete(s.order, cmd)
+	delete(s.running, cmd)
+	return b.String()
+}
+
+const failureTailLines = 5
+
+// formatFailureClasses groups failed agents by classified reason: one section per reason with a
+// representative log, followed by a short tail of each other member's log.
+func formatFailureClasses(results []result) string {
+	classes := map[string][]result{}
+	order := []string{}
+	for _, res := range results {
+		if res.Status != statusFailed {
+			continue
+		}
+		reason := strings.TrimSpace(res.Reason())
+		if reason == "" {
+			reason = "unknown"
+		}
+		if _, ok := classes[reason]; !ok {
+			order = append(order, reason)
+		}
+		classes[reason] = append(classes[reason], res)
+	}
+
+	var b strings.Builder
+	for _, reason := range order {
+		members := classes[reason]
+		names := make([]string, 0, len(members))
+		for _, res := range members {
+			names = append(names, res.Agent.Name)
+		}
+		noun := "agents"
+		if len(members) == 1 {
+			noun = "agent"
+		}
+		fmt.Fprintf(&b, "==> %s (%d %s: %s)\n", reason, len(members), noun, strings.Join(names, ", "))
+		representative := strings.TrimSpace(members[0].Log)
+		if representative == "" {
+			b.WriteString("(no output)\n")
+		} else {
+			b.WriteString(representative + "\n")
+		}
+		for _, res := range members[1:] {
+			log := strings.TrimSpace(res.Log)
+			switch {
+			case log == representative:
+				fmt.Fprintf(&b, "--> %s (same output)\n", res.Agent.Name)
+			case log == "":
+				fmt.Fprintf(&b, "--> %s (no output)\n", res.Agent.Name)
+			default:
+				fmt.Fprintf(&b, "--> %s (last %d lines)\n%s\n", res.Agent.Name, failureTailLines, tailLines(log, failureTailLines))
+			}
+		}
+	}
+	return b.String()
+}
+
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// showsLog reports whether res's log is printed: failures always (unless --group-failures prints them by
+// class), updates with --verbose.
+func showsLog(res result, opts options) bool {
+	if res.Status == statusFailed {
+		return !opts.GroupFailures
+	}
+	return opts.Verbose && res.Status == statusUpdated
+}
+
+// streamsLogs reports whether the dashboard prints logs above itself as agents finish (--verbose), in
+// place of the log dump after the run.
+func streamsLogs(opts options) bool {
+	return opts.Verbose && !opts.DryRun
+}
+
+func printLog(w io.Writer, agentName, log string) {
+	fmt.Fprintf(w, "==> %s\n", agentName)
+	trimmed := strings.TrimSpace(log)
+	if trimmed == "" {
+		fmt.Fprintln(w, "(no output)")
+		return
+	}
+	fmt.Fprintln(w, trimmed)
+}
+
+func printSummary(w io.Writer, results []result, unknown []string, elapsed time.Duration, opts options) {
+	fmt.Fprint(w, formatSummary(results, unknown, elapsed, opts.ErrorsOnly))
+	fmt.Fprint(w, formatFailureDigest(results))
+	fmt.Fprint(w, formatAdvisories(results))
+	fmt.Fprint(w, formatOtherInstalls(results))
+}
+
+// failureDigestText describes a failure class shared by several agents in the digest line.
+var failureDigestText = map[reasonCode]string{
+	codeNetwork:       "network errors; check connectivity, proxy, or VPN",
+	codeTLS:           "TLS errors; check proxy settings or system certificates",
+	codePermission:    "permission errors; check the global install prefix and file permissions",
+	codeTimeout:       "timeouts; retry on a faster connection or raise --timeout",
+	codeBroken:        "releases that no longer launch (--verify); reinstall the previous versions or use --rollback",
+	codeQuota:         "quota errors; retry later",
+	codeBrewBusy:      "Homebrew busy errors; wait for the other brew process",
+	codeNpmNotEmpty:   "npm ENOTEMPTY errors; retry or try --clean-reinstall",
+	codeDepConflict:   "npm peer dependency conflicts (ERESOLVE); retry with --legacy-peer-deps",
+	codePnpmIntegrity: "pnpm integrity errors; run `pnpm store prune`",
+	codePnpmStore:     "pnpm store errors; run `pnpm store prune`",
+	codePnpmLockfile:  "pnpm lockfile errors",
 }
 
-func printExplainDetails(results []result) {
+// formatFailureDigest condenses a run where several agents failed: one line per reason shared by two or
+// more agents (e.g. a dropped VPN failing everything with network errors) and a ready-to-paste command
+// that reruns only the failed agents. It is empty unless at least two agents failed.
+func formatFailureDigest(results []result) string {
+	failed := []string{}
+	byCode := map[reasonCode][]string{}
+	codes := []reasonCode{}
 	for _, res := range results {
-		if strings.TrimSpace(res.Explain) == "" {
+		if res.Status != statusFailed {
+			continue
+		}
+		failed = append(failed, res.Agent.Name)
+		code := res.ReasonCode
+		if _, ok := failureDigestText[code]; !ok {
 			continue
 		}
-		fmt.Fprintf(os.Stdout, "%s: %s\n", res.Agent.Name, res.Explain)
+		if _, seen := byCode[code]; !seen {
+			codes = append(codes, code)
+		}
+		byCode[code] = append(byCode[code], res.Agent.Name)
+	}
+	if len(failed) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	for _, code := range codes {
+		if names := byCode[code]; len(names) >= 2 {
+			fmt.Fprintf(&b, "%d agents failed with %s\n", len(names), failureDigestText[code])
+		}
 	}
+	fmt.Fprintf(&b, "retry the failed agents: uca --only %s\n", strings.Join(failed, ","))
+	return b.String()
 }
 
-func formatResult(res result, opts options) string {
-	name := res.Agent.Name
-	switch res.Status {
-	case statusSkipped:
-		return fmt.Sprintf("%s: skipped (%s)", name, res.Reason)
-	case statusFailed:
-		reason := strings.TrimSpace(res.Reason)
-		if reason != "" {
-			return fmt.Sprintf("%s: failed (%s; %s -> %s (%s))", name, reason, safeVersion(res.Before), safeVersion(res.After), fmtDuration(res.Duration))
+// formatAnnotations renders GitHub Actions workflow commands: ::error for failures, ::warning for batch
+// partials and skips other than "not installed".
+func formatAnnotations(results []result) []string {
+	lines := []string{}
+	for _, res := range results {
+		level := ""
+		switch {
+		case res.Status == statusFailed:
+			level = "error"
+		case res.ReasonCode == codeBatchPartial:
+			level = "warning"
+		case res.Status == statusSkipped:
+			switch res.ReasonCode {
+			case codeMissing, codeMissingBun, codeMissingVSCode, codeCurrent:
+				continue
+			}
+			level = "warning"
+		default:
+			continue
 		}
-		return fmt.Sprintf("%s: failed (%s -> %s (%s))", name, safeVersion(res.Before), safeVersion(res.After), fmtDuration(res.Duration))
-	case statusUpdated:
-		if opts.DryRun {
-			return fmt.Sprintf("%s: %s", name, res.UpdateCmd)
+		message := res.Reason()
+		if message == "" {
+			message = res.Status
 		}
-		return fmt.Sprintf("%s: %s -> %s (%s)", name, safeVersion(res.Before), safeVersion(res.After), fmtDuration(res.Duration))
-	case statusUnchanged:
-		return fmt.Sprintf("%s: unchanged %s -> %s (%s)", name, safeVersion(res.Before), safeVersion(res.After), fmtDuration(res.Duration))
-	default:
-		return fmt.Sprintf("%s: unknown", name)
+		if explain := strings.TrimSpace(res.Explain); explain != "" {
+			message += ": " + explain
+		}
+		lines = append(lines, fmt.Sprintf("::%s title=%s::%s", level, escapeAnnotationProperty(res.Agent.Name), escapeAnnotationData(message)))
 	}
+	return lines
 }
 
-func formatExplain(res result) string {
-	if strings.TrimSpace(res.Explain) == "" {
-		return ""
+func escapeAnnotationData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeAnnotationProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// runReport is the --output --json document.
+type runReport struct {
+	Agents    []agentRunReport `json:"agents"`
+	Unknown   []string         `json:"unknown,omitempty"`
+	ElapsedMs int64            `json:"elapsedMs"`
+	DryRun    bool             `json:"dryRun,omitempty"`
+}
+
+type agentRunReport struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Reason     string `json:"reason,omitempty"`
+	ReasonCode string `json:"reasonCode,omitempty"`
+	ExitCode   int    `json:"exitCode,omitempty"`
+	Before     string `json:"before,omitempty"`
+	After      string `json:"after,omitempty"`
+	Method     string `json:"method,omitempty"`
+	Command    string `json:"command,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Batched    bool   `json:"batched,omitempty"`
+	Advisories string `json:"advisories,omitempty"`
+	// OtherInstalls names other managers that also have the agent installed.
+	OtherInstalls []string `json:"otherInstalls,omitempty"`
+}
+
+func buildRunReport(results []result, unknown []string, elapsed time.Duration, dryRun bool) runReport {
+	report := runReport{Agents: []agentRunReport{}, Unknown: unknown, ElapsedMs: elapsed.Milliseconds(), DryRun: dryRun}
+	for _, res := range results {
+		report.Agents = append(report.Agents, agentRunReport{
+			Name:          res.Agent.Name,
+			Status:        res.Status,
+			Reason:        res.Reason(),
+			ReasonCode:    string(res.ReasonCode),
+			ExitCode:      res.ExitCode,
+			Before:        res.Before,
+			After:         res.After,
+			Method:        res.Method,
+			Command:       res.UpdateCmd,
+			DurationMs:    res.Duration.Milliseconds(),
+			Batched:       res.Batched,
+			Advisories:    res.Advisories,
+			OtherInstalls: res.OtherInstalls,
+		})
+	}
+	return report
+}
+
+// formatReport renders the --output file: every agent's result line followed by the full summary,
+// regardless of --quiet/--errors-only, or the JSON report with --json.
+func formatReport(results []result, unknown []string, elapsed time.Duration, opts options) ([]byte, error) {
+	if opts.JSON {
+		data, err := json.MarshalIndent(buildRunReport(results, unknown, elapsed, opts.DryRun), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	var b strings.Builder
+	for _, res := range results {
+		b.WriteString(formatResult(res, opts) + "\n")
 	}
-	return fmt.Sprintf("  info: %s", res.Explain)
+	b.WriteString(formatSummary(results, unknown, elapsed, false))
+	return []byte(b.String()), nil
 }
 
-func safeVersion(v string) string {
-	if strings.TrimSpace(v) == "" {
-		return "unknown"
+func writeReport(path string, results []result, unknown []string, elapsed time.Duration, opts options) error {
+	data, err := formatReport(results, unknown, elapsed, opts)
+	if err != nil {
+		return err
 	}
-	return v
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write --output: %w", err)
+	}
+	return nil
 }
 
-func fmtDuration(d time.Duration) string {
-	seconds := int(d.Round(time.Second).Seconds())
-	return fmt.Sprintf("%ds", seconds)
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatTSV  = "tsv"
+	formatCSV  = "csv"
+)
+
+// tableColumns are the --format tsv/csv columns, one row per agent.
+var tableColumns = []string{"name", "status", "before", "after", "method", "duration_s", "reason"}
+
+// isMachineFormat reports whether --format replaces the human-readable stdout output.
+func isMachineFormat(spec string) bool {
+	return spec != "" && spec != formatText
 }
 
-func printLogs(results []result, opts options) {
-	if opts.DryRun {
-		return
-	}
-	type logGroup struct {
-		names []string
-		log   string
+// parseResultFormat compiles --format into the template applied to each result; presets return nil.
+// Literal \t and \n are expanded so templates can be written in single-quoted shell strings.
+func parseResultFormat(spec string) (*template.Template, error) {
+	switch spec {
+	case "", formatText, formatJSON, formatTSV, formatCSV:
+		return nil, nil
 	}
-	groups := map[string]*logGroup{}
-	order := []string{}
+	spec = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(spec)
+	return template.New("format").Parse(spec)
+}
 
+// printFormatted writes the results in a machine-readable --format: the JSON run report, a TSV/CSV
+// table, or one template-rendered line per result.
+func printFormatted(w io.Writer, results []result, unknown []string, elapsed time.Duration, opts options) error {
+	switch opts.Format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(buildRunReport(results, unknown, elapsed, opts.DryRun))
+	case formatTSV:
+		return writeTSV(w, results, opts.Header)
+	case formatCSV:
+		return writeCSV(w, results, opts.Header)
+	}
+	var b bytes.Buffer
 	for _, res := range results {
-		if res.Status != statusFailed && !(opts.Verbose && res.Status == statusUpdated) {
-			continue
+		b.Reset()
+		if err := opts.resultFormat.Execute(&b, res); err != nil {
+			return fmt.Errorf("--format: %w", err)
 		}
-		key := res.UpdateCmd + "\n" + res.Status + "\n" + res.Log
-		group := groups[key]
-		if group == nil {
-			group = &logGroup{log: res.Log}
-			groups[key] = group
-			order = append(order, key)
+		line := b.String()
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
 		}
-		group.names = append(group.names, res.Agent.Name)
 	}
+	return nil
+}
 
-	for _, key := range order {
-		group := groups[key]
-		printLog(strings.Join(group.names, ", "), group.log)
+// tableRow returns a result's --format tsv/csv fields in tableColumns order.
+func tableRow(res result) []string {
+	seconds := strconv.FormatFloat(res.Duration.Round(time.Millisecond).Seconds(), 'f', -1, 64)
+	return []string{res.Agent.Name, res.Status, res.Before, res.After, res.Method, seconds, res.Reason()}
+}
+
+// writeTSV writes one tab-separated row per result. Tabs and newlines inside fields (multi-line version
+// output, say) become spaces so every agent stays on one line for awk and cut.
+func writeTSV(w io.Writer, results []result, header bool) error {
+	clean := strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+	var b strings.Builder
+	if header {
+		b.WriteString(strings.Join(tableColumns, "\t") + "\n")
+	}
+	for _, res := range results {
+		row := tableRow(res)
+		for i, field := range row {
+			row[i] = clean.Replace(field)
+		}
+		b.WriteString(strings.Join(row, "\t") + "\n")
 	}
+	_, err := io.WriteString(w, b.String())
+	return err
 }
 
-func printLog(agentName, log string) {
-	fmt.Fprintf(os.Stdout, "==> %s\n", agentName)
-	trimmed := strings.TrimSpace(log)
-	if trimmed == "" {
-		fmt.Fprintln(os.Stdout, "(no output)")
-		return
+// writeCSV writes one RFC 4180 row per result, quoting fields as needed.
+func writeCSV(w io.Writer, results []result, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		if err := cw.Write(tableColumns); err != nil {
+			return err
+		}
+	}
+	for _, res := range results {
+		if err := cw.Write(tableRow(res)); err != nil {
+			return err
+		}
 	}
-	fmt.Fprintln(os.Stdout, trimmed)
+	cw.Flush()
+	return cw.Error()
 }
 
-func printSummary(results []result, unknown []string) {
+// formatSummary renders the per-status summary lines and footer. With errorsOnly, the
+// updated/unchanged/missing lines are dropped and the footer only appears when something failed.
+func formatSummary(results []result, unknown []string, elapsed time.Duration, errorsOnly bool) string {
 	updated := []string{}
+	installed := []string{}
+	reinstalled := []string{}
+	partial := []string{}
 	unchanged := []string{}
 	skippedMissing := []string{}
 	skippedBun := []string{}
 	skippedCode := []string{}
 	skippedManual := []string{}
+	skippedBroken := []string{}
+	skippedTimeout := []string{}
+	skippedCanceled := []string{}
+	skippedLock := []string{}
+	skippedCurrent := []string{}
+	skippedDuplicate := []string{}
+	skippedMajor := []string{}
+	skippedAuth := []string{}
+	rolledBack := []string{}
 	failed := []string{}
 
 	for _, res := range results {
+		if res.ReasonCode == codeBatchPartial {
+			partial = append(partial, res.Agent.Name)
+			continue
+		}
 		switch res.Status {
 		case statusUpdated:
+			if res.ReasonCode == codeInstalled {
+				installed = append(installed, res.Agent.Name)
+				continue
+			}
+			if res.ReasonCode == codeReinstalled {
+				reinstalled = append(reinstalled, res.Agent.Name)
+				continue
+			}
 			updated = append(updated, res.Agent.Name)
 		case statusUnchanged:
 			unchanged = append(unchanged, res.Agent.Name)
 		case statusSkipped:
-			switch res.Reason {
-			case reasonMissingBun:
+			switch res.ReasonCode {
+			case codeMissingBun:
 				skippedBun = append(skippedBun, res.Agent.Name)
-			case reasonMissingCode:
+			case codeMissingVSCode:
 				skippedCode = append(skippedCode, res.Agent.Name)
-			case reasonManualInstall:
+			case codeManualInstall:
 				skippedManual = append(skippedManual, res.Agent.Name)
+			case codeBrokenInstall:
+				skippedBroken = append(skippedBroken, res.Agent.Name)
+			case codeDetectTimeout:
+				skippedTimeout = append(skippedTimeout, res.Agent.Name)
+			case codeCanceled:
+				skippedCanceled = append(skippedCanceled, res.Agent.Name)
+			case codeLockTimeout:
+				skippedLock = append(skippedLock, res.Agent.Name)
+			case codeCurrent:
+				skippedCurrent = append(skippedCurrent, res.Agent.Name)
+			case codeDuplicate:
+				skippedDuplicate = append(skippedDuplicate, res.Agent.Name)
+			case codeMajorUpgrade:
+				skippedMajor = append(skippedMajor, res.Agent.Name)
+			case codeAuth, codeQuota:
+				skippedAuth = append(skippedAuth, res.Agent.Name)
 			default:
 				skippedMissing = append(skippedMissing, res.Agent.Name)
 			}
 		case statusFailed:
+			if res.ReasonCode == codeRolledBack {
+				rolledBack = append(rolledBack, res.Agent.Name)
+				continue
+			}
 			failed = append(failed, res.Agent.Name)
 		}
 	}
 
-	printSummaryLine("updated", updated)
-	printSummaryLine("unchanged", unchanged)
-	printSummaryLine("skipped (missing)", skippedMissing)
-	printSummaryLine("skipped (missing bun)", skippedBun)
-	printSummaryLine("skipped (missing vscode)", skippedCode)
-	printSummaryLine("skipped (manual install)", skippedManual)
-	if len(unknown) > 0 {
-		printSummaryLine("skipped (unknown)", unknown)
+	var b strings.Builder
+	if !errorsOnly {
+		writeSummaryLine(&b, "updated", updated)
+		writeSummaryLine(&b, "installed", installed)
+		writeSummaryLine(&b, "reinstalled", reinstalled)
+		writeSummaryLine(&b, "unchanged", unchanged)
+		writeSummaryLine(&b, "skipped (missing)", skippedMissing)
+		writeSummaryLine(&b, "skipped (current)", skippedCurrent)
+		writeSummaryLine(&b, "skipped (duplicate)", skippedDuplicate)
+	}
+	writeSummaryLine(&b, "skipped (missing bun)", skippedBun)
+	writeSummaryLine(&b, "skipped (missing vscode)", skippedCode)
+	writeSummaryLine(&b, "skipped (manual install)", skippedManual)
+	writeSummaryLine(&b, "skipped (broken install)", skippedBroken)
+	writeSummaryLine(&b, "skipped (detection timed out)", skippedTimeout)
+	writeSummaryLine(&b, "skipped (canceled)", skippedCanceled)
+	writeSummaryLine(&b, "skipped (lock timeout)", skippedLock)
+	writeSummaryLine(&b, "skipped (major upgrade)", skippedMajor)
+	writeSummaryLine(&b, "skipped (auth check)", skippedAuth)
+	writeSummaryLine(&b, "batch partial", partial)
+	writeSummaryLine(&b, "skipped (unknown)", unknown)
+	writeSummaryLine(&b, "rolled back", rolledBack)
+	writeSummaryLine(&b, "failed", failed)
+	if !errorsOnly || len(failed) > 0 || len(rolledBack) > 0 {
+		b.WriteString(formatFooter(results, elapsed) + "\n")
+	}
+	return b.String()
+}
+
+// formatFooter renders the closing "done in ..." line with agent, batching, and failure counts.
+func formatFooter(results []result, elapsed time.Duration) string {
+	batched := 0
+	failed := 0
+	for _, res := range results {
+		if res.Batched {
+			batched++
+		}
+		if res.Status == statusFailed {
+			failed++
+		}
 	}
-	if len(failed) > 0 {
-		printSummaryLine("failed", failed)
+	line := fmt.Sprintf("done in %s (%d agents, %d batched", fmtElapsed(elapsed), len(results), batched)
+	if failed > 0 {
+		line += fmt.Sprintf(", %d failed", failed)
 	}
+	return line + ")"
 }
 
-func printSummaryLine(label string, items []string) {
+func writeSummaryLine(b *strings.Builder, label string, items []string) {
 	if len(items) == 0 {
 		return
 	}
-	fmt.Fprintf(os.Stdout, "%s: %s\n", label, strings.Join(items, " "))
+	fmt.Fprintf(b, "%s: %s\n", label, strings.Join(items, " "))
+}
+
+// unmovedAgents lists the agents whose update ran without changing the version (--require-update):
+// unchanged ones and batch partials.
+func unmovedAgents(results []result) []string {
+	names := []string{}
+	for _, res := range results {
+		if res.Status == statusUnchanged || (res.Status == statusUpdated && res.ReasonCode == codeBatchPartial) {
+			names = append(names, res.Agent.Name)
+		}
+	}
+	return names
 }
 
 func hasFailures(results []result) bool {
@@ -1875,6 +6151,8 @@ func hasFailures(results []result) bool {
 
 type envState struct {
 	ctx context.Context
+	// runner executes detection, version, and update commands; nil means execRunner.
+	runner commandRunner
 
 	hasBun    bool
 	hasBrew   bool
@@ -1882,42 +6160,66 @@ type envState struct {
 	hasPnpm   bool
 	hasYarn   bool
 	hasUv     bool
+	hasAsdf   bool
 	hasPython bool
 	codeCmd   string
+	// managerPriority breaks ties when an agent matches several node managers.
+	managerPriority []string
+	// prefer is --prefer: "native" or "package" strategies are tried first.
+	prefer string
+	// detectTimeout bounds detection commands; zero means defaultDetectTimeout.
+	detectTimeout time.Duration
 
 	mu           sync.Mutex
 	binPathCache map[string]string
 	npmBinOnce   sync.Once
 	npmBin       string
 	npmPkgOnce   sync.Once
-	npmPkgs      map[string]bool
+	npmPkgs      map[string]string
 	pnpmBinOnce  sync.Once
 	pnpmBin      string
 	pnpmPkgOnce  sync.Once
-	pnpmPkgs     map[string]bool
+	pnpmPkgs     map[string]string
 	yarnBinOnce  sync.Once
 	yarnBin      string
 	yarnPkgOnce  sync.Once
-	yarnPkgs     map[string]bool
+	yarnVerOnce  sync.Once
+	yarnVersion  string
+	yarnPkgs     map[string]string
 	bunBinOnce   sync.Once
 	bunGlobalBin string
 	bunPkgOnce   sync.Once
-	bunPkgs      map[string]bool
+	bunPkgs      map[string]string
 	uvOnce       sync.Once
-	uvTools      map[string]bool
-	codeOnce     sync.Once
-	codeExts     map[string]string
+	asdfOnce     sync.Once
+	asdfOld      bool
+	uvTools      map[string]string
+	// uvPins maps uv tools whose receipt pins an exact version (e.g. after a --rollback) to that version;
+	// `uv tool upgrade` keeps such a pin.
+	uvPins   map[string]string
+	codeOnce sync.Once
+	// codeExts maps lowercased extension ids (see extensionKey) to versions; codeExtIDs keeps each id's
+	// casing as the VS Code CLI listed it, for display.
+	codeExts   map[string]string
+	codeExtIDs map[string]string
+	codeStale  map[string]bool
+	// corepackDirs caches corepackShimDir per manager kind.
+	corepackDirs map[string]string
+	// detectTimedOut maps a strategy kind to the detection command that hit detectTimeout.
+	detectTimedOut map[string]string
 }
 
 func newEnv(ctx context.Context) *envState {
 	return &envState{
 		ctx:          ctx,
+		runner:       execRunner{},
 		hasBun:       hasBinary("bun"),
 		hasBrew:      hasBinary("brew"),
 		hasNpm:       hasBinary("npm"),
 		hasPnpm:      hasBinary("pnpm"),
 		hasYarn:      hasBinary("yarn"),
 		hasUv:        hasBinary("uv"),
+		hasAsdf:      hasBinary("asdf"),
 		hasPython:    hasBinary("python3"),
 		codeCmd:      detectCodeCmd(),
 		binPathCache: map[string]string{},
@@ -1934,6 +6236,13 @@ func detectCodeCmd() string {
 	return ""
 }
 
+func (e *envState) commands() commandRunner {
+	if e == nil || e.runner == nil {
+		return execRunner{}
+	}
+	return e.runner
+}
+
 func (e *envState) baseCtx() context.Context {
 	if e == nil || e.ctx == nil {
 		return context.Background()
@@ -1941,6 +6250,49 @@ func (e *envState) baseCtx() context.Context {
 	return e.ctx
 }
 
+// runDetect runs a detection command under the detect timeout. A timeout is recorded against kind so
+// an empty result can be reported as "detection timed out" instead of "missing".
+func (e *envState) runDetect(kind string, args []string) (string, int, time.Duration, error) {
+	timeout := e.detectTimeout
+	if timeout <= 0 {
+		timeout = defaultDetectTimeout
+	}
+	out, exitCode, duration, err := e.commands().Output(e.baseCtx(), args, timeout)
+	if exitCode == exitCodeTimeout {
+		e.mu.Lock()
+		if e.detectTimedOut == nil {
+			e.detectTimedOut = map[string]string{}
+		}
+		e.detectTimedOut[kind] = cmdString(args)
+		e.mu.Unlock()
+	}
+	return out, exitCode, duration, err
+}
+
+// detectTimeoutWarning describes detection commands that timed out for any of agent's strategies.
+func (e *envState) detectTimeoutWarning(agent agents.Agent) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	seen := map[string]bool{}
+	cmds := []string{}
+	for _, strat := range agent.Strategies {
+		cmd, ok := e.detectTimedOut[strat.Kind]
+		if !ok || seen[strat.Kind] {
+			continue
+		}
+		seen[strat.Kind] = true
+		cmds = append(cmds, "`"+cmd+"`")
+	}
+	if len(cmds) == 0 {
+		return ""
+	}
+	timeout := e.detectTimeout
+	if timeout <= 0 {
+		timeout = defaultDetectTimeout
+	}
+	return fmt.Sprintf("warning: %s timed out after %s; agent may be installed (raise --detect-timeout)", strings.Join(cmds, ", "), timeout)
+}
+
 func (e *envState) hasBinary(name string) bool {
 	return e.binaryPath(name) != ""
 }
@@ -1960,9 +6312,14 @@ func (e *envState) binaryPath(name string) string {
 		return path
 	}
 	e.mu.Unlock()
+	// On Windows LookPath resolves PATHEXT wrappers (usually name.cmd for npm); its directory is what
+	// nodeManagerForBinary compares against the managers' global bin dirs.
 	path, err := exec.LookPath(name)
 	if err != nil {
 		path = ""
+		if runtime.GOOS == "windows" {
+			path = findPowerShellShim(name, os.Getenv("PATH"))
+		}
 	} else {
 		path = filepath.Clean(path)
 	}
@@ -1979,7 +6336,7 @@ func (e *envState) hasNodeManager(kind string) bool {
 	case agents.KindPnpm:
 		return e.hasPnpm
 	case agents.KindYarn:
-		return e.hasYarn
+		return e.hasYarn && !e.yarnBerry()
 	case agents.KindBun:
 		return e.hasBun
 	default:
@@ -1987,6 +6344,22 @@ func (e *envState) hasNodeManager(kind string) bool {
 	}
 }
 
+// yarnBerry reports whether `yarn` is Yarn 2+ (berry), which removed `yarn global`. Yarn strategies are
+// skipped entirely in that case rather than emitting commands that always fail.
+func (e *envState) yarnBerry() bool {
+	e.yarnVerOnce.Do(func() {
+		if !e.hasYarn {
+			return
+		}
+		out, exitCode, _, _ := e.runDetect(agents.KindYarn, []string{"yarn", "--version"})
+		if exitCode == 0 {
+			e.yarnVersion = strings.TrimSpace(out)
+		}
+	})
+	major, _, ok := versionMajorMinor(e.yarnVersion)
+	return ok && major >= 2
+}
+
 func (e *envState) nodeManagerForBinary(name string) string {
 	binPath := e.binaryPath(name)
 	if binPath == "" {
@@ -2014,23 +6387,35 @@ func (e *envState) nodeManagerForBinary(name string) string {
 		return matches[0]
 	}
 	if len(matches) > 1 {
-		bestKind := ""
+		best := []string{}
 		bestLen := -1
-		tie := false
 		for _, kind := range matches {
 			dir := e.nodeBinDir(kind)
 			if len(dir) > bestLen {
 				bestLen = len(dir)
-				bestKind = kind
-				tie = false
+				best = []string{kind}
 				continue
 			}
 			if len(dir) == bestLen {
-				tie = true
+				best = append(best, kind)
 			}
 		}
-		if !tie {
-			return bestKind
+		if len(best) == 1 {
+			return best[0]
+		}
+		return pickByPriority(best, e.managerPriority)
+	}
+	return ""
+}
+
+// pickByPriority breaks a tie between matching managers using the --manager-priority order.
+// Without a priority (or when none of the candidates is listed) it returns "".
+func pickByPriority(candidates, priority []string) string {
+	for _, kind := range priority {
+		for _, candidate := range candidates {
+			if candidate == kind {
+				return kind
+			}
 		}
 	}
 	return ""
@@ -2040,53 +6425,250 @@ func (e *envState) nodeBinHasBinary(kind, name string) bool {
 	return binDirHasBinary(e.nodeBinDir(kind), name)
 }
 
+// nodeBinDanglingLink returns "name -> target" when kind's global bin has name as a symlink whose target
+// is gone, or "".
+func (e *envState) nodeBinDanglingLink(kind, name string) string {
+	dir := e.nodeBinDir(kind)
+	if dir == "" || name == "" {
+		return ""
+	}
+	return danglingSymlink(filepath.Join(dir, name))
+}
+
 func (e *envState) nodeBinDir(kind string) string {
+	dir := ""
 	switch kind {
 	case agents.KindNpm:
 		return e.npmBinDir()
 	case agents.KindPnpm:
-		return e.pnpmBinDir()
+		dir = e.pnpmBinDir()
+	case agents.KindYarn:
+		dir = e.yarnBinDir()
+	case agents.KindBun:
+		dir = e.bunGlobalBinDir()
+	default:
+		return ""
+	}
+	if dir != "" && e.corepackShimDir(kind) != "" && samePath(dir, e.corepackShimDir(kind)) {
+		// A corepack-provisioned manager reporting the directory that holds its own shim (node's bin dir)
+		// says nothing about where it installs; npm owns that dir. Fall back to package-list matching.
+		return ""
+	}
+	return dir
+}
+
+// corepackShimDir returns the directory of the manager's executable when it is a corepack shim, or "".
+func (e *envState) corepackShimDir(kind string) string {
+	e.mu.Lock()
+	if dir, ok := e.corepackDirs[kind]; ok {
+		e.mu.Unlock()
+		return dir
+	}
+	e.mu.Unlock()
+	dir := ""
+	if path := e.binaryPath(kind); path != "" && isCorepackShim(path) {
+		dir = filepath.Dir(path)
+	}
+	e.mu.Lock()
+	if e.corepackDirs == nil {
+		e.corepackDirs = map[string]string{}
+	}
+	e.corepackDirs[kind] = dir
+	e.mu.Unlock()
+	return dir
+}
+
+// isCorepackShim reports whether path is a corepack shim: a symlink into corepack's dist dir on Unix,
+// or a wrapper script that invokes corepack (Windows .cmd shims, some version managers).
+func isCorepackShim(path string) bool {
+	if resolved := resolveSymlinkPath(path); strings.Contains(filepath.ToSlash(resolved), "/corepack/") {
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return bytes.Contains(head[:n], []byte("corepack"))
+}
+
+func (e *envState) withCorepackNote(kind, detail string) string {
+	if kind == agents.KindNpm || e.corepackShimDir(kind) == "" {
+		return detail
+	}
+	return detail + fmt.Sprintf("; note: %s is a corepack shim", kind)
+}
+
+// nodeVersionDirPatterns match a node version manager's per-version bin dir (slash-separated): nvm's
+// versions/node/v20.11.0/bin and fnm's node-versions/v20.11.0/installation/bin.
+var nodeVersionDirPatterns = []struct {
+	manager string
+	re      *regexp.Regexp
+}{
+	{manager: "nvm", re: regexp.MustCompile(`/versions/node/(v\d[^/]*)/bin$`)},
+	{manager: "fnm", re: regexp.MustCompile(`/node-versions/(v\d[^/]*)/installation/bin$`)},
+}
+
+// nodeVersionOfDir reports which nvm/fnm node version a bin dir belongs to, following symlinks such as
+// fnm's per-shell multishell dirs. version is "" for a dir outside a version manager.
+func nodeVersionOfDir(dir string) (manager, version string) {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	for _, pattern := range nodeVersionDirPatterns {
+		if m := pattern.re.FindStringSubmatch(dir); m != nil {
+			return pattern.manager, m[1]
+		}
+	}
+	return "", ""
+}
+
+// withNodeVersionNote flags an agent installed under an nvm/fnm node version other than the active one:
+// the update lands in the active node's globals, so the copy on PATH never changes.
+func (e *envState) withNodeVersionNote(binary, detail string) string {
+	path := e.binaryPath(binary)
+	if path == "" {
+		return detail
+	}
+	manager, installed := nodeVersionOfDir(filepath.Dir(path))
+	if installed == "" {
+		return detail
+	}
+	node := e.binaryPath("node")
+	if node == "" {
+		return detail
+	}
+	_, active := nodeVersionOfDir(filepath.Dir(node))
+	if active == installed {
+		return detail
+	}
+	label := "node " + active
+	if active == "" {
+		label = "a node outside " + manager + " (" + filepath.Dir(node) + ")"
+	}
+	return appendHint(detail, fmt.Sprintf("%s is installed under node %s (%s), but the active node is %s; the update goes to the active node's globals and won't change this copy, so run `%s use %s` first", binary, installed, manager, label, manager, strings.TrimPrefix(installed, "v")))
+}
+
+func (e *envState) nodeManagerForPackage(pkg string) string {
+	if pkg == "" {
+		return ""
+	}
+	matches := []string{}
+	for _, kind := range []string{agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun} {
+		if !e.hasNodeManager(kind) {
+			continue
+		}
+		if e.nodeManagerHasPackage(kind, pkg) {
+			matches = append(matches, kind)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0]
+	}
+	return pickByPriority(matches, e.managerPriority)
+}
+
+// nodePackageMismatch reports whether kind's global package list was read and lacks pkg. An empty list
+// is treated as unknown (the listing may have failed or timed out), not as a mismatch.
+func (e *envState) nodePackageMismatch(kind, pkg string) bool {
+	if pkg == "" || len(e.nodePackages(kind)) == 0 {
+		return false
+	}
+	return !e.nodeManagerHasPackage(kind, pkg)
+}
+
+func (e *envState) nodeManagerHasPackage(kind, pkg string) bool {
+	switch kind {
+	case agents.KindNpm:
+		return e.npmHas(pkg)
+	case agents.KindPnpm:
+		return e.pnpmHas(pkg)
+	case agents.KindYarn:
+		return e.yarnHas(pkg)
+	case agents.KindBun:
+		return e.bunHas(pkg)
+	default:
+		return false
+	}
+}
+
+// nodePackageVersion returns the version a manager's global package list reports for pkg, if any.
+func (e *envState) nodePackageVersion(kind, pkg string) string {
+	if pkg == "" || !e.nodeManagerHasPackage(kind, pkg) {
+		return ""
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch kind {
+	case agents.KindNpm:
+		return e.npmPkgs[pkg]
+	case agents.KindPnpm:
+		return e.pnpmPkgs[pkg]
+	case agents.KindYarn:
+		return e.yarnPkgs[pkg]
+	case agents.KindBun:
+		return e.bunPkgs[pkg]
+	default:
+		return ""
+	}
+}
+
+// nodePackages returns a copy of a manager's global package list (name -> version).
+func (e *envState) nodePackages(kind string) map[string]string {
+	// Any lookup triggers the lazy load for that manager.
+	e.nodeManagerHasPackage(kind, "")
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var src map[string]string
+	switch kind {
+	case agents.KindNpm:
+		src = e.npmPkgs
+	case agents.KindPnpm:
+		src = e.pnpmPkgs
 	case agents.KindYarn:
-		return e.yarnBinDir()
+		src = e.yarnPkgs
 	case agents.KindBun:
-		return e.bunGlobalBinDir()
-	default:
-		return ""
-	}
-}
-
-func (e *envState) nodeManagerForPackage(pkg string) string {
-	if pkg == "" {
-		return ""
-	}
-	matches := []string{}
-	for _, kind := range []string{agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun} {
-		if !e.hasNodeManager(kind) {
-			continue
-		}
-		if e.nodeManagerHasPackage(kind, pkg) {
-			matches = append(matches, kind)
-		}
+		src = e.bunPkgs
 	}
-	if len(matches) == 1 {
-		return matches[0]
+	pkgs := make(map[string]string, len(src))
+	for name, version := range src {
+		pkgs[name] = version
 	}
-	return ""
+	return pkgs
 }
 
-func (e *envState) nodeManagerHasPackage(kind, pkg string) bool {
+// refreshNodePackages reloads a manager's global package list after an update mutated it, and drops
+// cached binary paths so newly linked binaries are picked up.
+func (e *envState) refreshNodePackages(kind string, binaries []string) {
 	switch kind {
 	case agents.KindNpm:
-		return e.npmHas(pkg)
+		e.npmPkgOnce.Do(func() {})
+		e.loadNpmPkgs()
 	case agents.KindPnpm:
-		return e.pnpmHas(pkg)
+		e.pnpmPkgOnce.Do(func() {})
+		e.loadPnpmPkgs()
 	case agents.KindYarn:
-		return e.yarnHas(pkg)
+		e.yarnPkgOnce.Do(func() {})
+		e.loadYarnPkgs()
 	case agents.KindBun:
-		return e.bunHas(pkg)
+		e.bunPkgOnce.Do(func() {})
+		e.loadBunPkgs()
 	default:
-		return false
+		return
+	}
+	e.forgetBinaries(binaries)
+}
+
+// forgetBinaries drops cached PATH lookups so binaries installed or moved during the run are re-resolved.
+func (e *envState) forgetBinaries(binaries []string) {
+	e.mu.Lock()
+	for _, name := range binaries {
+		delete(e.binPathCache, name)
 	}
+	e.mu.Unlock()
 }
 
 func (e *envState) npmBinDir() string {
@@ -2099,7 +6681,7 @@ func (e *envState) loadNpmBin() {
 	if !e.hasNpm {
 		return
 	}
-	out, exitCode, _, _ := runCmdStdout(e.baseCtx(), []string{"npm", "bin", "-g"}, detectCmdTimeout)
+	out, exitCode, _, _ := e.runDetect(agents.KindNpm, []string{"npm", "bin", "-g"})
 	if exitCode == 0 {
 		if dir := strings.TrimSpace(out); dir != "" {
 			e.npmBin = dir
@@ -2108,7 +6690,7 @@ func (e *envState) loadNpmBin() {
 	}
 
 	// npm v11 removed `npm bin`, but `npm prefix -g` still works.
-	prefixOut, exitCode, _, _ := runCmdStdout(e.baseCtx(), []string{"npm", "prefix", "-g"}, detectCmdTimeout)
+	prefixOut, exitCode, _, _ := e.runDetect(agents.KindNpm, []string{"npm", "prefix", "-g"})
 	if exitCode != 0 {
 		return
 	}
@@ -2132,24 +6714,37 @@ func (e *envState) loadNpmBin() {
 
 func (e *envState) npmHas(pkg string) bool {
 	e.npmPkgOnce.Do(e.loadNpmPkgs)
-	return e.npmPkgs[pkg]
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.npmPkgs[pkg]
+	return ok
 }
 
 func (e *envState) loadNpmPkgs() {
-	e.npmPkgs = map[string]bool{}
+	pkgs := e.listNpmPkgs()
+	e.mu.Lock()
+	e.npmPkgs = pkgs
+	e.mu.Unlock()
+}
+
+func (e *envState) listNpmPkgs() map[string]string {
+	pkgs := map[string]string{}
 	if !e.hasNpm {
-		return
+		return pkgs
 	}
-	out, _, _, _ := runCmdStdout(e.baseCtx(), []string{"npm", "list", "-g", "--depth=0", "--json"}, detectCmdTimeout)
+	out, _, _, _ := e.runDetect(agents.KindNpm, []string{"npm", "list", "-g", "--depth=0", "--json"})
 	var payload struct {
-		Dependencies map[string]any `json:"dependencies"`
+		Dependencies map[string]struct {
+			Version string `json:"version"`
+		} `json:"dependencies"`
 	}
 	if err := json.Unmarshal([]byte(out), &payload); err != nil {
-		return
+		return pkgs
 	}
-	for name := range payload.Dependencies {
-		e.npmPkgs[name] = true
+	for name, dep := range payload.Dependencies {
+		pkgs[name] = dep.Version
 	}
+	return pkgs
 }
 
 func (e *envState) pnpmBinDir() string {
@@ -2162,7 +6757,7 @@ func (e *envState) loadPnpmBin() {
 	if !e.hasPnpm {
 		return
 	}
-	out, exitCode, _, _ := runCmdStdout(e.baseCtx(), []string{"pnpm", "bin", "-g"}, detectCmdTimeout)
+	out, exitCode, _, _ := e.runDetect(agents.KindPnpm, []string{"pnpm", "bin", "-g"})
 	if exitCode != 0 {
 		return
 	}
@@ -2171,34 +6766,77 @@ func (e *envState) loadPnpmBin() {
 
 func (e *envState) pnpmHas(pkg string) bool {
 	e.pnpmPkgOnce.Do(e.loadPnpmPkgs)
-	return e.pnpmPkgs[pkg]
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.pnpmPkgs[pkg]
+	return ok
 }
 
 func (e *envState) loadPnpmPkgs() {
-	e.pnpmPkgs = map[string]bool{}
+	pkgs := e.listPnpmPkgs()
+	e.mu.Lock()
+	e.pnpmPkgs = pkgs
+	e.mu.Unlock()
+}
+
+func (e *envState) listPnpmPkgs() map[string]string {
 	if !e.hasPnpm {
-		return
-	}
-	out, _, _, _ := runCmdStdout(e.baseCtx(), []string{"pnpm", "list", "-g", "--depth=0", "--json"}, detectCmdTimeout)
-	type pnpmPayload struct {
-		Dependencies map[string]any `json:"dependencies"`
+		return map[string]string{}
 	}
-	var list []pnpmPayload
-	if err := json.Unmarshal([]byte(out), &list); err == nil {
-		for _, entry := range list {
-			for name := range entry.Dependencies {
-				e.pnpmPkgs[name] = true
+	out, _, _, _ := e.runDetect(agents.KindPnpm, []string{"pnpm", "list", "-g", "--depth=0", "--json"})
+	return parsePnpmListOutput(out)
+}
+
+// pnpmDep is one entry of a `pnpm list --json` dependency map.
+type pnpmDep struct {
+	Version string `json:"version"`
+}
+
+// pnpmProject is one project in `pnpm list --json`. Global packages normally sit under dependencies, but
+// some pnpm 8/9 global dirs report them under devDependencies or optionalDependencies instead.
+type pnpmProject struct {
+	Dependencies         map[string]pnpmDep `json:"dependencies"`
+	DevDependencies      map[string]pnpmDep `json:"devDependencies"`
+	OptionalDependencies map[string]pnpmDep `json:"optionalDependencies"`
+}
+
+func (p pnpmProject) empty() bool {
+	return len(p.Dependencies) == 0 && len(p.DevDependencies) == 0 && len(p.OptionalDependencies) == 0
+}
+
+// parsePnpmListOutput reads `pnpm list -g --depth=0 --json` across pnpm versions: an array of projects
+// (pnpm 7+), a single project object (older pnpm), or an object keyed by the global dir's path. Warning
+// lines printed before the JSON are skipped.
+func parsePnpmListOutput(out string) map[string]string {
+	pkgs := map[string]string{}
+	if i := strings.IndexAny(out, "[{"); i > 0 {
+		out = out[i:]
+	}
+	data := []byte(out)
+	var projects []pnpmProject
+	if err := json.Unmarshal(data, &projects); err != nil {
+		var single pnpmProject
+		if err := json.Unmarshal(data, &single); err != nil {
+			return pkgs
+		}
+		projects = []pnpmProject{single}
+		if single.empty() {
+			var byPath map[string]pnpmProject
+			if err := json.Unmarshal(data, &byPath); err == nil {
+				for _, project := range byPath {
+					projects = append(projects, project)
+				}
 			}
 		}
-		return
-	}
-	var single pnpmPayload
-	if err := json.Unmarshal([]byte(out), &single); err != nil {
-		return
 	}
-	for name := range single.Dependencies {
-		e.pnpmPkgs[name] = true
+	for _, project := range projects {
+		for _, deps := range []map[string]pnpmDep{project.OptionalDependencies, project.DevDependencies, project.Dependencies} {
+			for name, dep := range deps {
+				pkgs[name] = dep.Version
+			}
+		}
 	}
+	return pkgs
 }
 
 func (e *envState) yarnBinDir() string {
@@ -2208,10 +6846,10 @@ func (e *envState) yarnBinDir() string {
 
 func (e *envState) loadYarnBin() {
 	e.yarnBin = ""
-	if !e.hasYarn {
+	if !e.hasNodeManager(agents.KindYarn) {
 		return
 	}
-	out, exitCode, _, _ := runCmdStdout(e.baseCtx(), []string{"yarn", "global", "bin"}, detectCmdTimeout)
+	out, exitCode, _, _ := e.runDetect(agents.KindYarn, []string{"yarn", "global", "bin"})
 	if exitCode != 0 {
 		return
 	}
@@ -2220,21 +6858,28 @@ func (e *envState) loadYarnBin() {
 
 func (e *envState) yarnHas(pkg string) bool {
 	e.yarnPkgOnce.Do(e.loadYarnPkgs)
-	return e.yarnPkgs[pkg]
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.yarnPkgs[pkg]
+	return ok
 }
 
 func (e *envState) loadYarnPkgs() {
-	e.yarnPkgs = map[string]bool{}
-	if !e.hasYarn {
-		return
+	pkgs := e.listYarnPkgs()
+	e.mu.Lock()
+	e.yarnPkgs = pkgs
+	e.mu.Unlock()
+}
+
+func (e *envState) listYarnPkgs() map[string]string {
+	if !e.hasNodeManager(agents.KindYarn) {
+		return map[string]string{}
 	}
-	out, exitCode, _, _ := runCmdStdout(e.baseCtx(), []string{"yarn", "global", "list", "--depth=0"}, detectCmdTimeout)
+	out, exitCode, _, _ := e.runDetect(agents.KindYarn, []string{"yarn", "global", "list", "--depth=0"})
 	if exitCode != 0 {
-		return
-	}
-	for name := range parsePackageListOutput(out) {
-		e.yarnPkgs[name] = true
+		return map[string]string{}
 	}
+	return parsePackageListOutput(out)
 }
 
 func (e *envState) bunGlobalBinDir() string {
@@ -2247,7 +6892,7 @@ func (e *envState) loadBunGlobalBin() {
 	if !e.hasBun {
 		return
 	}
-	out, exitCode, _, _ := runCmdStdout(e.baseCtx(), []string{"bun", "pm", "bin", "-g"}, detectCmdTimeout)
+	out, exitCode, _, _ := e.runDetect(agents.KindBun, []string{"bun", "pm", "bin", "-g"})
 	if exitCode != 0 {
 		return
 	}
@@ -2256,21 +6901,28 @@ func (e *envState) loadBunGlobalBin() {
 
 func (e *envState) bunHas(pkg string) bool {
 	e.bunPkgOnce.Do(e.loadBunPkgs)
-	return e.bunPkgs[pkg]
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.bunPkgs[pkg]
+	return ok
 }
 
 func (e *envState) loadBunPkgs() {
-	e.bunPkgs = map[string]bool{}
+	pkgs := e.listBunPkgs()
+	e.mu.Lock()
+	e.bunPkgs = pkgs
+	e.mu.Unlock()
+}
+
+func (e *envState) listBunPkgs() map[string]string {
 	if !e.hasBun {
-		return
+		return map[string]string{}
 	}
-	out, exitCode, _, _ := runCmdStdout(e.baseCtx(), []string{"bun", "pm", "ls", "-g"}, detectCmdTimeout)
+	out, exitCode, _, _ := e.runDetect(agents.KindBun, []string{"bun", "pm", "ls", "-g"})
 	if exitCode != 0 {
-		return
-	}
-	for name := range parsePackageListOutput(out) {
-		e.bunPkgs[name] = true
+		return map[string]string{}
 	}
+	return parsePackageListOutput(out)
 }
 
 func fileExists(path string) bool {
@@ -2278,17 +6930,33 @@ func fileExists(path string) bool {
 	return err == nil && !info.IsDir()
 }
 
+// windowsShimExts are the wrappers npm and friends create on Windows. `.ps1` is not in the default
+// PATHEXT, so exec.LookPath alone misses PowerShell-only shims.
+var windowsShimExts = []string{".exe", ".cmd", ".bat", ".ps1"}
+
+// findPowerShellShim looks for name.ps1 in the PATH-style list pathList.
+func findPowerShellShim(name, pathList string) string {
+	for _, dir := range filepath.SplitList(pathList) {
+		if dir == "" {
+			continue
+		}
+		candidate := filepath.Join(dir, name+".ps1")
+		if fileExists(candidate) {
+			return filepath.Clean(candidate)
+		}
+	}
+	return ""
+}
+
 func binDirHasBinary(binDir, name string) bool {
 	if binDir == "" || name == "" {
 		return false
 	}
 	candidates := []string{filepath.Join(binDir, name)}
 	if runtime.GOOS == "windows" {
-		candidates = append(candidates,
-			filepath.Join(binDir, name+".exe"),
-			filepath.Join(binDir, name+".cmd"),
-			filepath.Join(binDir, name+".bat"),
-		)
+		for _, ext := range windowsShimExts {
+			candidates = append(candidates, filepath.Join(binDir, name+ext))
+		}
 	}
 	for _, candidate := range candidates {
 		if fileExists(candidate) {
@@ -2298,6 +6966,22 @@ func binDirHasBinary(binDir, name string) bool {
 	return false
 }
 
+// danglingSymlink returns "path -> target" when path is a symlink that no longer resolves, or "".
+func danglingSymlink(path string) string {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return ""
+	}
+	if _, err := os.Stat(path); err == nil {
+		return ""
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	return path + " -> " + target
+}
+
 func samePath(a, b string) bool {
 	if a == "" || b == "" {
 		return false
@@ -2335,8 +7019,9 @@ func resolveSymlinkPath(path string) string {
 	return filepath.Clean(resolved)
 }
 
-func parsePackageListOutput(out string) map[string]bool {
-	pkgs := map[string]bool{}
+// parsePackageListOutput extracts name -> version pairs from `name@version` tokens in list output.
+func parsePackageListOutput(out string) map[string]string {
+	pkgs := map[string]string{}
 	scanner := bufio.NewScanner(strings.NewReader(out))
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -2344,8 +7029,8 @@ func parsePackageListOutput(out string) map[string]bool {
 			continue
 		}
 		for _, token := range strings.Fields(line) {
-			if name := parsePackageFromToken(token); name != "" {
-				pkgs[name] = true
+			if name, version := parsePackageToken(token); name != "" {
+				pkgs[name] = version
 			}
 		}
 	}
@@ -2353,33 +7038,48 @@ func parsePackageListOutput(out string) map[string]bool {
 }
 
 func parsePackageFromToken(token string) string {
+	name, _ := parsePackageToken(token)
+	return name
+}
+
+func parsePackageToken(token string) (string, string) {
 	if token == "" {
-		return ""
+		return "", ""
 	}
 	token = strings.Trim(token, "\"'`,")
 	token = strings.TrimRight(token, "):,")
 	token = strings.TrimLeft(token, "(")
 	if !strings.Contains(token, "@") {
-		return ""
+		return "", ""
 	}
 	idx := strings.LastIndex(token, "@")
 	if idx <= 0 || idx == len(token)-1 {
-		return ""
+		return "", ""
 	}
-	return token[:idx]
+	return token[:idx], token[idx+1:]
 }
 
 func (e *envState) uvHas(pkg string) bool {
 	e.uvOnce.Do(e.loadUvTools)
-	return e.uvTools[pkg]
+	_, ok := e.uvTools[pkg]
+	return ok
 }
 
+// uvPinRe matches the specifier `uv tool list --show-version-specifiers` prints for a pinned tool, e.g.
+// "aider-chat v0.86.1 [required: ==0.86.1]".
+var uvPinRe = regexp.MustCompile(`\[required:\s*==\s*([^\],\s]+)\]`)
+
 func (e *envState) loadUvTools() {
-	e.uvTools = map[string]bool{}
+	e.uvTools = map[string]string{}
+	e.uvPins = map[string]string{}
 	if !e.hasUv {
 		return
 	}
-	out, _, _, _ := runCmdStdout(e.baseCtx(), []string{"uv", "tool", "list"}, detectCmdTimeout)
+	out, exitCode, _, _ := e.runDetect(agents.KindUv, []string{"uv", "tool", "list", "--show-version-specifiers"})
+	if exitCode != 0 {
+		// An older uv has no --show-version-specifiers; pins are then unknown.
+		out, _, _, _ = e.runDetect(agents.KindUv, []string{"uv", "tool", "list"})
+	}
 	scanner := bufio.NewScanner(strings.NewReader(out))
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -2390,43 +7090,264 @@ func (e *envState) loadUvTools() {
 		if len(fields) == 0 {
 			continue
 		}
-		e.uvTools[fields[0]] = true
+		// Lines look like "aider-chat v0.86.1"; entry points are listed below as "- aider".
+		version := ""
+		if len(fields) > 1 && fields[0] != "-" {
+			version = strings.TrimPrefix(fields[1], "v")
+		}
+		e.uvTools[fields[0]] = version
+		if m := uvPinRe.FindStringSubmatch(line); m != nil && fields[0] != "-" {
+			e.uvPins[fields[0]] = m[1]
+		}
+	}
+}
+
+// uvPin returns the exact version the tool's receipt pins, or "".
+func (e *envState) uvPin(pkg string) string {
+	e.uvOnce.Do(e.loadUvTools)
+	return e.uvPins[pkg]
+}
+
+// uvToolList returns a copy of the installed uv tools (name -> version).
+func (e *envState) uvToolList() map[string]string {
+	e.uvOnce.Do(e.loadUvTools)
+	tools := map[string]string{}
+	for name, version := range e.uvTools {
+		if name == "-" {
+			continue
+		}
+		tools[name] = version
 	}
+	return tools
 }
 
 func (e *envState) brewHas(formula string) bool {
 	if !e.hasBrew {
 		return false
 	}
-	out, exitCode, _, _ := runCmdStdout(e.baseCtx(), []string{"brew", "list", "--formula", "--versions", formula}, detectCmdTimeout)
+	out, exitCode, _, _ := e.runDetect(agents.KindBrew, []string{"brew", "list", "--formula", "--versions", formula})
 	return exitCode == 0 && strings.TrimSpace(out) != ""
 }
 
+// asdfDataDir mirrors asdf's own lookup: $ASDF_DATA_DIR, else ~/.asdf.
+func asdfDataDir() string {
+	if dir := strings.TrimSpace(os.Getenv("ASDF_DATA_DIR")); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".asdf")
+}
+
+// asdfShimHas reports whether binary resolves to the asdf shims directory.
+func (e *envState) asdfShimHas(binary string) bool {
+	if !e.hasAsdf || binary == "" {
+		return false
+	}
+	path := e.binaryPath(binary)
+	dataDir := asdfDataDir()
+	if path == "" || dataDir == "" {
+		return false
+	}
+	return filepath.Dir(path) == filepath.Join(dataDir, "shims")
+}
+
+// miseDataDir mirrors mise's own lookup: $MISE_DATA_DIR, else $XDG_DATA_HOME/mise, else ~/.local/share/mise.
+func miseDataDir() string {
+	if dir := strings.TrimSpace(os.Getenv("MISE_DATA_DIR")); dir != "" {
+		return dir
+	}
+	if dir := strings.TrimSpace(os.Getenv("XDG_DATA_HOME")); dir != "" {
+		return filepath.Join(dir, "mise")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "share", "mise")
+}
+
+// shimExecPrefix returns the command that runs binary's real executable when binary resolves to an asdf
+// or mise shims directory (`asdf exec`, `mise exec --`), or nil.
+func (e *envState) shimExecPrefix(binary string) []string {
+	path := e.binaryPath(binary)
+	if path == "" {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	if dataDir := asdfDataDir(); dataDir != "" && dir == filepath.Join(dataDir, "shims") && e.hasAsdf {
+		return []string{"asdf", "exec"}
+	}
+	if dataDir := miseDataDir(); dataDir != "" && dir == filepath.Join(dataDir, "shims") && e.hasBinary("mise") {
+		return []string{"mise", "exec", "--"}
+	}
+	return nil
+}
+
+// asdfLegacy reports whether the installed asdf predates 0.16, which replaced `asdf global` with `asdf set`.
+func (e *envState) asdfLegacy() bool {
+	e.asdfOnce.Do(func() {
+		out, _, _, _ := e.runDetect(agents.KindAsdf, []string{"asdf", "--version"})
+		e.asdfOld = isLegacyAsdfVersion(out)
+	})
+	return e.asdfOld
+}
+
+func isLegacyAsdfVersion(out string) bool {
+	major, minor, ok := versionMajorMinor(out)
+	return ok && major == 0 && minor < 16
+}
+
+// versionMajorMinor extracts the major and minor numbers of the first version token in s.
+func versionMajorMinor(s string) (int, int, bool) {
+	core, ok := versionCore(s)
+	if !ok || len(core) < 2 {
+		return 0, 0, false
+	}
+	return core[0], core[1], true
+}
+
+// asdfUpdateCommand installs the latest version of plugin and makes it the user-wide default. The plugin
+// name is validated when the config is loaded, so it is safe to splice into the shell string.
+func asdfUpdateCommand(plugin string, legacy bool) []string {
+	setCmd := fmt.Sprintf("asdf set --home %s latest", plugin)
+	if legacy {
+		setCmd = fmt.Sprintf("asdf global %s latest", plugin)
+	}
+	return []string{"sh", "-c", fmt.Sprintf("asdf install %s latest && %s", plugin, setCmd)}
+}
+
+// execDetect runs a config-provided detect command; exit code 0 means installed.
+func (e *envState) execDetect(args []string) bool {
+	if len(args) == 0 || !hasBinary(args[0]) {
+		return false
+	}
+	_, exitCode, _, _ := e.runDetect(agents.KindExec, args)
+	return exitCode == 0
+}
+
 func (e *envState) pipHas(pkg string) bool {
 	if !e.hasPython {
 		return false
 	}
-	_, exitCode, _, _ := runCmdStdout(e.baseCtx(), []string{"python3", "-m", "pip", "show", pkg}, detectCmdTimeout)
+	_, exitCode, _, _ := e.runDetect(agents.KindPip, []string{"python3", "-m", "pip", "show", pkg})
 	return exitCode == 0
 }
 
+// extensionKey normalizes a VS Code extension id for lookups: the Marketplace and the CLI treat ids
+// case-insensitively, so a config's RooVeterinaryInc.roo-cline must match a listed rooveterinaryinc.roo-cline.
+func extensionKey(extID string) string {
+	return strings.ToLower(strings.TrimSpace(extID))
+}
+
 func (e *envState) vscodeHas(extID string) bool {
 	e.codeOnce.Do(e.loadCodeExtensions)
-	_, ok := e.codeExts[extID]
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.codeExts[extensionKey(extID)]
 	return ok
 }
 
 func (e *envState) vscodeVersion(extID string) string {
 	e.codeOnce.Do(e.loadCodeExtensions)
-	return e.codeExts[extID]
+	key := extensionKey(extID)
+	e.mu.Lock()
+	stale := e.codeStale[key]
+	e.mu.Unlock()
+	if stale {
+		// The extension was reinstalled during this run; re-query so After reflects the new version.
+		exts, ids := e.listCodeExtensions()
+		e.mu.Lock()
+		if version, ok := exts[key]; ok {
+			e.codeExts[key] = version
+			if e.codeExtIDs != nil {
+				e.codeExtIDs[key] = ids[key]
+			}
+		} else {
+			delete(e.codeExts, key)
+		}
+		delete(e.codeStale, key)
+		e.mu.Unlock()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.codeExts[key]
+}
+
+// codeExtensionList returns a copy of the installed VS Code extensions (id as listed -> version).
+func (e *envState) codeExtensionList() map[string]string {
+	e.codeOnce.Do(e.loadCodeExtensions)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exts := make(map[string]string, len(e.codeExts))
+	for key, version := range e.codeExts {
+		id := e.codeExtIDs[key]
+		if id == "" {
+			id = key
+		}
+		exts[id] = version
+	}
+	return exts
+}
+
+// invalidateCodeExtension marks an extension's cached version as stale so the next lookup re-queries the CLI.
+func (e *envState) invalidateCodeExtension(extID string) {
+	if extID == "" {
+		return
+	}
+	e.codeOnce.Do(e.loadCodeExtensions)
+	e.mu.Lock()
+	if e.codeStale == nil {
+		e.codeStale = map[string]bool{}
+	}
+	e.codeStale[extensionKey(extID)] = true
+	e.mu.Unlock()
+}
+
+// maxListedExtensions caps the extensions named in a not-installed diagnostic; `uca detect --json` has them all.
+const maxListedExtensions = 20
+
+// codeExtensionDiagnostic explains why an extension id wasn't matched: which VS Code CLI was asked and the
+// id@version pairs it reported.
+func (e *envState) codeExtensionDiagnostic(extID string) string {
+	exts := e.codeExtensionList()
+	ids := make([]string, 0, len(exts))
+	for id := range exts {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return fmt.Sprintf("extension %s not installed in %s (`%s --list-extensions` reported no extensions)", extID, e.codeCmd, e.codeCmd)
+	}
+	sort.Strings(ids)
+	listed := make([]string, 0, maxListedExtensions)
+	for _, id := range ids {
+		if len(listed) == maxListedExtensions {
+			break
+		}
+		listed = append(listed, id+"@"+exts[id])
+	}
+	list := strings.Join(listed, ", ")
+	if extra := len(ids) - len(listed); extra > 0 {
+		list += fmt.Sprintf(" and %d more (see `uca detect --json`)", extra)
+	}
+	return fmt.Sprintf("extension %s not installed in %s; `%s --list-extensions` reported %s", extID, e.codeCmd, e.codeCmd, list)
 }
 
 func (e *envState) loadCodeExtensions() {
-	e.codeExts = map[string]string{}
+	e.codeExts, e.codeExtIDs = e.listCodeExtensions()
+}
+
+// listCodeExtensions queries the VS Code CLI. Both maps are keyed by extensionKey: exts holds versions,
+// ids the casing the CLI printed.
+func (e *envState) listCodeExtensions() (exts, ids map[string]string) {
+	exts = map[string]string{}
+	ids = map[string]string{}
 	if e.codeCmd == "" {
-		return
+		return exts, ids
 	}
-	out, _, _, _ := runCmdStdout(e.baseCtx(), []string{e.codeCmd, "--list-extensions", "--show-versions"}, detectCmdTimeout)
+	out, _, _, _ := e.runDetect(agents.KindVSCode, []string{e.codeCmd, "--list-extensions", "--show-versions"})
 	scanner := bufio.NewScanner(strings.NewReader(out))
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -2438,7 +7359,215 @@ func (e *envState) loadCodeExtensions() {
 			continue
 		}
 		id := line[:idx]
-		version := line[idx+1:]
-		e.codeExts[id] = version
+		exts[extensionKey(id)] = line[idx+1:]
+		ids[extensionKey(id)] = id
+	}
+	return exts, ids
+}
+
+type detectReport struct {
+	Managers []managerReport     `json:"managers"`
+	Agents   []agentDetectReport `json:"agents"`
+	Unknown  []string            `json:"unknown,omitempty"`
+}
+
+type managerReport struct {
+	Kind     string            `json:"kind"`
+	Command  string            `json:"command,omitempty"`
+	Present  bool              `json:"present"`
+	BinDir   string            `json:"binDir,omitempty"`
+	Packages map[string]string `json:"packages,omitempty"`
+	// Warning explains a present manager whose detection came up short, e.g. a failed `npm bin -g`.
+	Warning string `json:"warning,omitempty"`
+}
+
+type agentDetectReport struct {
+	Name    string   `json:"name"`
+	Method  string   `json:"method,omitempty"`
+	Command []string `json:"command,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+	Detail  string   `json:"detail,omitempty"`
+	// Steps is the per-strategy decision trace (--explain-json only).
+	Steps []decisionStep `json:"steps,omitempty"`
+}
+
+// buildDetectReport runs every detection loader and resolves each agent without updating anything.
+func buildDetectReport(env *envState, selected []agents.Agent, unknown []string, withSteps bool) detectReport {
+	report := detectReport{Unknown: unknown, Managers: buildManagerReports(env)}
+
+	for _, agent := range selected {
+		var trace *decisionTrace
+		if withSteps {
+			trace = &decisionTrace{}
+		}
+		cmd, reason, method, detail := resolveUpdateTrace(agent, env, trace)
+		entry := agentDetectReport{
+			Name:    agent.Name,
+			Method:  method,
+			Command: cmd,
+			Reason:  reason.label(),
+			Detail:  detail,
+		}
+		if trace != nil {
+			entry.Steps = trace.steps
+		}
+		report.Agents = append(report.Agents, entry)
+	}
+	return report
+}
+
+// buildManagerReports runs the envState loaders for every supported manager: whether it is present, its
+// global bin dir, and the packages it lists. Detection commands that timed out are noted as warnings.
+func buildManagerReports(env *envState) []managerReport {
+	managers := []managerReport{}
+	for _, kind := range []string{agents.KindNpm, agents.KindPnpm, agents.KindYarn, agents.KindBun} {
+		m := managerReport{Kind: kind, Present: env.hasNodeManager(kind)}
+		if kind == agents.KindYarn && env.yarnBerry() {
+			// Report berry as present but unusable so it isn't mistaken for a missing yarn.
+			m.Present = true
+			m.Command = "berry " + env.yarnVersion + ", no global installs"
+		} else if m.Present {
+			m.BinDir = env.nodeBinDir(kind)
+			m.Packages = env.nodePackages(kind)
+			if m.BinDir == "" {
+				m.Warning = "global bin dir unknown; agents are matched by package list only"
+			}
+		}
+		managers = append(managers, m)
+	}
+	managers = append(managers, managerReport{Kind: agents.KindBrew, Present: env.hasBrew})
+	managers = append(managers, managerReport{Kind: agents.KindPip, Present: env.hasPython})
+	managers = append(managers, managerReport{Kind: agents.KindAsdf, Present: env.hasAsdf})
+	uv := managerReport{Kind: agents.KindUv, Present: env.hasUv}
+	if uv.Present {
+		uv.Packages = env.uvToolList()
+	}
+	managers = append(managers, uv)
+	code := managerReport{Kind: agents.KindVSCode, Command: env.codeCmd, Present: env.codeCmd != ""}
+	if code.Present {
+		code.Packages = env.codeExtensionList()
+	}
+	managers = append(managers, code)
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	for i := range managers {
+		if cmd, ok := env.detectTimedOut[managers[i].Kind]; ok {
+			managers[i].Warning = appendNote(fmt.Sprintf("`%s` timed out (raise --detect-timeout)", cmd), managers[i].Warning)
+		}
+	}
+	return managers
+}
+
+// printManagers is --list-managers: one line per manager, or the reports as JSON.
+func printManagers(w io.Writer, managers []managerReport, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(managers)
+	}
+	for _, m := range managers {
+		fmt.Fprintln(w, formatManagerLine(m))
+	}
+	return nil
+}
+
+// formatManagerLine renders a manager's detection state, e.g. "npm: present, bin /usr/local/bin, 12 packages".
+func formatManagerLine(m managerReport) string {
+	label := m.Kind
+	if m.Command != "" {
+		label = fmt.Sprintf("%s (%s)", m.Kind, m.Command)
+	}
+	if !m.Present {
+		return label + ": not found"
+	}
+	line := label + ": present"
+	if m.BinDir != "" {
+		line += ", bin " + m.BinDir
+	}
+	if m.Packages != nil {
+		line += fmt.Sprintf(", %d packages", len(m.Packages))
+	}
+	if m.Warning != "" {
+		line += "; warning: " + m.Warning
+	}
+	return line
+}
+
+// printConfig writes the effective agent definitions in the --config file format, so the output can be
+// committed and loaded back unchanged.
+func printConfig(w io.Writer, effective []agents.Agent) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(agents.Config{Agents: effective})
+}
+
+// printCatalog prints one line per agent, e.g. "codex: binary=codex strategies=npm,pnpm,yarn,bun".
+func printCatalog(w io.Writer, catalog []agents.Agent, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(catalog)
+	}
+	for _, agent := range catalog {
+		fmt.Fprintln(w, formatCatalogLine(agent))
+	}
+	return nil
+}
+
+func formatCatalogLine(agent agents.Agent) string {
+	fields := []string{agent.Name + ":"}
+	if agent.Binary != "" {
+		fields = append(fields, "binary="+agent.Binary)
+	}
+	if agent.ExtensionID != "" {
+		fields = append(fields, "extension="+agent.ExtensionID)
+	}
+	kinds := make([]string, 0, len(agent.Strategies))
+	for _, strat := range agent.Strategies {
+		kinds = append(kinds, strat.Kind)
+	}
+	fields = append(fields, "strategies="+strings.Join(kinds, ","))
+	if len(agent.Aliases) > 0 {
+		fields = append(fields, "aliases="+strings.Join(agent.Aliases, ","))
+	}
+	if len(agent.ConflictGroups) > 0 {
+		fields = append(fields, "conflicts="+strings.Join(agent.ConflictGroups, ","))
+	}
+	return strings.Join(fields, " ")
+}
+
+func printDetectReport(w io.Writer, report detectReport, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	fmt.Fprintln(w, "managers:")
+	for _, m := range report.Managers {
+		fmt.Fprintln(w, "  "+formatManagerLine(m))
+		names := make([]string, 0, len(m.Packages))
+		for name := range m.Packages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "    %s %s\n", name, m.Packages[name])
+		}
+	}
+	fmt.Fprintln(w, "agents:")
+	for _, a := range report.Agents {
+		if a.Command != nil {
+			fmt.Fprintf(w, "  %s: %s (%s)\n", a.Name, methodLabel(a.Method), cmdString(a.Command))
+		} else {
+			fmt.Fprintf(w, "  %s: skipped (%s)\n", a.Name, a.Reason)
+		}
+		if a.Detail != "" {
+			fmt.Fprintf(w, "    %s\n", a.Detail)
+		}
+	}
+	if len(report.Unknown) > 0 {
+		fmt.Fprintf(w, "unknown: %s\n", strings.Join(report.Unknown, " "))
 	}
+	return nil
 }
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -38,6 +48,21 @@ func TestParseVersionOutput(t *testing.T) {
 			out:  "claude 2.1.19\n",
 			want: "claude 2.1.19",
 		},
+		{
+			name: "crlf_version_only",
+			out:  "1.2.3\r\n",
+			want: "1.2.3",
+		},
+		{
+			name: "crlf_selects_version_only_line",
+			out:  "(node:1234) ExperimentalWarning: fetch\r\n0.5.1\r\n",
+			want: "0.5.1",
+		},
+		{
+			name: "carriage_return_progress",
+			out:  "checking for updates...\r1.4.0\n",
+			want: "1.4.0",
+		},
 		{
 			name: "selects_last_version_only_line",
 			out:  "INFO something\n1.1.36\n",
@@ -59,6 +84,29 @@ func TestParseVersionOutput(t *testing.T) {
 	}
 }
 
+func TestRunVersionCmdNonZeroExit(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply fakeReply
+		want  string
+	}{
+		{name: "version despite exit 1", reply: fakeReply{out: "mytool 1.4.2\n", code: 1}, want: "mytool 1.4.2"},
+		{name: "version only line after noise", reply: fakeReply{out: "warning: config missing\n1.4.2\n", code: 2}, want: "1.4.2"},
+		{name: "error mentioning a version", reply: fakeReply{out: "No version is set for command pi\nConsider adding: nodejs 20.1.0\n", code: 126}, want: "unknown"},
+		{name: "error only", reply: fakeReply{out: "error: unknown option --version", code: 1}, want: "unknown"},
+		{name: "not found", reply: fakeReply{out: "1.0.0", code: 127}, want: "unknown"},
+		{name: "timeout", reply: fakeReply{out: "1.0.0", code: exitCodeTimeout}, want: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{replies: map[string][]fakeReply{"mytool --version": {tt.reply}}}
+			if got := runVersionCmd(context.Background(), runner, []string{"mytool", "--version"}); got != tt.want {
+				t.Fatalf("runVersionCmd() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestExtractVersionToken(t *testing.T) {
 	tests := []struct {
 		in   string
@@ -99,6 +147,26 @@ func TestFormatVersionWithToken(t *testing.T) {
 	}
 }
 
+func TestDisplayVersions(t *testing.T) {
+	tests := []struct {
+		before, after         string
+		wantBefore, wantAfter string
+	}{
+		{before: "codex-cli 0.90.0-alpha.5", after: "0.98.0", wantBefore: "0.90.0-alpha.5", wantAfter: "0.98.0"},
+		{before: "2.1.19 (Claude Code)", after: "2.1.20 (Claude Code)", wantBefore: "2.1.19", wantAfter: "2.1.20"},
+		{before: "v1.4.0", after: "1.5.0", wantBefore: "1.4.0", wantAfter: "1.5.0"},
+		{before: "v1.4.0", after: "v1.5.0", wantBefore: "v1.4.0", wantAfter: "v1.5.0"},
+		{before: "", after: "gemini 0.5.0", wantBefore: "unknown", wantAfter: "0.5.0"},
+		{before: "nightly", after: "unknown", wantBefore: "nightly", wantAfter: "unknown"},
+	}
+	for _, tt := range tests {
+		before, after := displayVersions(tt.before, tt.after)
+		if before != tt.wantBefore || after != tt.wantAfter {
+			t.Fatalf("displayVersions(%q, %q) = %q, %q; want %q, %q", tt.before, tt.after, before, after, tt.wantBefore, tt.wantAfter)
+		}
+	}
+}
+
 func TestFormatRowUpdatingShowsTargetVersion(t *testing.T) {
 	row := uiRow{
 		name:   "codex",
@@ -110,11 +178,206 @@ func TestFormatRowUpdatingShowsTargetVersion(t *testing.T) {
 	r := &uiRenderer{width: 200, useColor: false, useUnicode: true}
 
 	got := formatRow(row, len(row.name), options{}, r)
-	if !strings.Contains(got, "codex-cli 0.90.0-alpha.5 → codex-cli 0.98.0") {
+	if !strings.Contains(got, " 0.90.0-alpha.5 → 0.98.0 ") {
 		t.Fatalf("formatRow() did not include target version; got %q", got)
 	}
 }
 
+func TestRenderDashboardFinalFrame(t *testing.T) {
+	rows := []uiRow{
+		{name: "codex", status: statusUpdated, before: "0.1.0", after: "0.2.0", duration: 3 * time.Second, visible: true},
+		{name: "amp", status: statusUnchanged, before: "1.0.0", after: "1.0.0", duration: time.Second, visible: true},
+	}
+	r := &uiRenderer{width: 200, useColor: false, useUnicode: false, final: true}
+	got := renderDashboard(rows, 5, time.Now(), options{}, r, 2, 2)
+	if !strings.HasPrefix(got, "uca  done  2/2  ok:1 same:1 fail:0") {
+		t.Fatalf("final header = %q", strings.SplitN(got, "\n", 2)[0])
+	}
+	if strings.Contains(got, "\x1b[") {
+		t.Fatalf("final frame contains escape sequences: %q", got)
+	}
+	if !strings.Contains(got, "0.1.0 → 0.2.0") || !strings.Contains(got, "3s") {
+		t.Fatalf("final frame lost per-agent details: %q", got)
+	}
+}
+
+func TestManualInstructions(t *testing.T) {
+	agent := agents.Agent{
+		Name:               "mytool",
+		Binary:             "mytool",
+		Strategies:         []agents.UpdateStrategy{{Kind: agents.KindPip, Package: "mytool"}},
+		ManualInstructions: "update from Settings > Updates",
+	}
+	env := &envState{binPathCache: map[string]string{"mytool": "/opt/mytool/bin/mytool"}}
+	_, reason, _, detail := resolveUpdate(agent, env)
+	if reason != codeManualInstall || !strings.HasSuffix(detail, "; hint: update from Settings > Updates") {
+		t.Fatalf("resolveUpdate() = %q, %q", reason, detail)
+	}
+
+	res := result{Agent: agent, Status: statusSkipped, ReasonCode: codeManualInstall}
+	if got := formatResult(res, options{}); got != "mytool: skipped (manual install; update from Settings > Updates)" {
+		t.Fatalf("formatResult() = %q", got)
+	}
+	var row uiRow
+	applyEvent(&row, updateEvent{Phase: phaseDetect, Result: res, Show: true})
+	row.name = "mytool"
+	if got := formatRow(row, 6, options{}, &uiRenderer{width: 200}); !strings.Contains(got, "(update from Settings > Updates)") {
+		t.Fatalf("formatRow() = %q", got)
+	}
+
+	failed := result{Agent: agent, Method: agents.KindNative}
+	setFailureResult(&failed, 1, []string{"mytool", "update"}, "boom", 0)
+	if !strings.Contains(failed.Explain, "hint: update from Settings > Updates") {
+		t.Fatalf("native failure explain = %q", failed.Explain)
+	}
+}
+
+func TestFrameRows(t *testing.T) {
+	frame := strings.Repeat("x", 80) + "\n\n" + colorize(strings.Repeat("y", 80), statusUpdated, true) + "\n"
+	tests := []struct {
+		width int
+		want  int
+	}{
+		{width: 80, want: 3},
+		{width: 120, want: 3},
+		{width: 40, want: 5},
+		{width: 30, want: 7},
+		{width: 0, want: 3},
+	}
+	for _, tt := range tests {
+		if got := frameRows(frame, tt.width); got != tt.want {
+			t.Fatalf("frameRows(width %d) = %d, want %d", tt.width, got, tt.want)
+		}
+	}
+	if got := frameRows("", 80); got != 0 {
+		t.Fatalf("frameRows(\"\") = %d", got)
+	}
+}
+
+func TestRendererKeepsWidthWhenQueryFails(t *testing.T) {
+	out, err := os.CreateTemp(t.TempDir(), "frame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	r := &uiRenderer{out: out, width: 100}
+	r.Draw(strings.Repeat("x", 100) + "\n" + strings.Repeat("y", 100) + "\n")
+	// A file is not a terminal, so the size query fails and the last known width stays.
+	r.refreshWidth()
+	if r.width != 100 {
+		t.Fatalf("width after failed query = %d, want 100", r.width)
+	}
+	// After a shrink to 50 columns the previous frame wrapped to four rows; Clear must move up past all of them.
+	r.width = 50
+	r.Clear()
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), "\x1b[4A\x1b[0G\x1b[0J") {
+		t.Fatalf("clear sequence = %q", data[len(data)-20:])
+	}
+}
+
+func TestRendererLogRegion(t *testing.T) {
+	t.Cleanup(func() { scrollRegionSet.Store(false) })
+	out, err := os.CreateTemp(t.TempDir(), "frame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	// A file has no terminal size, so the 10-row height set here is kept.
+	r := &uiRenderer{out: out, width: 80, region: true, height: 10}
+	r.Draw("uca\n\ncodex\n")
+	r.Log("==> codex\nadded 1 package\n")
+	r.Draw("uca\n\ncodex\ngemini\n")
+	r.closeLogRegion()
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\x1b[10;1H\n\n\n\x1b[1;7r\x1b[8;1H\x1b[0Juca\n\ncodex" + // reserve 3 rows at the bottom
+		"\x1b[7;1H\n==> codex\nadded 1 package" + // log at the bottom of the region
+		"\x1b[7;1H\n\x1b[1;6r\x1b[7;1H\x1b[0Juca\n\ncodex\ngemini" + // scroll the logs up for a 4th row
+		"\x1b[r\x1b[10;1H\n"
+	if string(data) != want {
+		t.Fatalf("output = %q\nwant     %q", data, want)
+	}
+	if scrollRegionSet.Load() || r.region {
+		t.Fatalf("region still set after closeLogRegion")
+	}
+
+	// Without a terminal height the log is printed inline above the frame.
+	inline, err := os.CreateTemp(t.TempDir(), "inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inline.Close()
+	r = &uiRenderer{out: inline, width: 80}
+	r.openLogRegion()
+	r.Draw("uca\n")
+	r.Log("==> codex\nok\n")
+	data, err = os.ReadFile(inline.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x1b[0G\x1b[0Juca\n\x1b[1A\x1b[0G\x1b[0J==> codex\nok\n\x1b[0G\x1b[0Juca\n"; string(data) != want {
+		t.Fatalf("inline output = %q\nwant %q", data, want)
+	}
+}
+
+func TestPrintExplainDetails(t *testing.T) {
+	results := []result{
+		{Agent: agents.Agent{Name: "claude"}, Status: statusUpdated, Explain: "binary claude found; using built-in update"},
+		{Agent: agents.Agent{Name: "cursor"}, Status: statusSkipped, ReasonCode: codeMissing, Explain: "no supported binary or install method detected"},
+		{Agent: agents.Agent{Name: "kilocode"}, Status: statusSkipped, ReasonCode: codeMissingVSCode, Explain: "VS Code CLI not found (code/codium/code-insiders)"},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusUnchanged},
+	}
+	var b bytes.Buffer
+	printExplainDetails(&b, results)
+	want := "claude: binary claude found; using built-in update\n" +
+		"cursor: skipped (missing); no supported binary or install method detected\n" +
+		"kilocode: skipped (missing vscode); VS Code CLI not found (code/codium/code-insiders)\n" +
+		"amp: no detection detail recorded\n"
+	if got := b.String(); got != want {
+		t.Fatalf("printExplainDetails() = %q, want %q", got, want)
+	}
+}
+
+func TestReadStdinLists(t *testing.T) {
+	tests := []struct {
+		name     string
+		only     string
+		skip     string
+		stdin    string
+		wantOnly string
+		wantSkip string
+		wantErr  string
+	}{
+		{name: "only", only: "-", stdin: "gemini codex\nclaude, amp # ignored\n", wantOnly: "gemini,codex,claude,amp"},
+		{name: "skip", skip: "-", only: "c*", stdin: "cursor", wantOnly: "c*", wantSkip: "cursor"},
+		{name: "empty skip", skip: "-", stdin: "", wantSkip: ""},
+		{name: "empty only", only: "-", stdin: "  \n# nothing\n", wantErr: errNoStdinAgents.Error()},
+		{name: "both", only: "-", skip: "-", wantErr: "can't both read stdin"},
+		{name: "no dash", only: "gemini", stdin: "codex", wantOnly: "gemini"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := options{Only: tt.only, Skip: tt.skip}
+			err := readStdinLists(&opts, strings.NewReader(tt.stdin))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readStdinLists() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || opts.Only != tt.wantOnly || opts.Skip != tt.wantSkip {
+				t.Fatalf("readStdinLists() = only %q skip %q (%v), want %q %q", opts.Only, opts.Skip, err, tt.wantOnly, tt.wantSkip)
+			}
+		})
+	}
+}
+
 func TestShouldRetryNpm(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -234,40 +497,82 @@ func TestFormatRetryOutput(t *testing.T) {
 
 func TestClassifyUpdateFailure(t *testing.T) {
 	tests := []struct {
-		name       string
-		args       []string
-		output     string
-		wantReason string
-		wantHint   string
+		name     string
+		args     []string
+		output   string
+		wantCode reasonCode
+		wantHint string
 	}{
 		{
-			name:       "quota",
-			args:       []string{"gemini", "--version"},
-			output:     "TerminalQuotaError: You have exhausted your capacity on this model.",
-			wantReason: reasonQuota,
-			wantHint:   "quota exceeded",
+			name:     "quota",
+			args:     []string{"gemini", "--version"},
+			output:   "TerminalQuotaError: You have exhausted your capacity on this model.",
+			wantCode: codeQuota,
+			wantHint: "quota exceeded",
+		},
+		{
+			name:     "npm_enotempty",
+			args:     []string{"npm", "install", "-g", "pkg"},
+			output:   "npm error ENOTEMPTY: directory not empty",
+			wantCode: codeNpmNotEmpty,
+			wantHint: "npm rename failed",
+		},
+		{
+			name:     "npm_eresolve",
+			args:     []string{"npm", "install", "-g", "pkg@latest"},
+			output:   "npm error code ERESOLVE\nnpm error ERESOLVE could not resolve\nnpm error Could not resolve dependency:\nnpm error peer react@\"^18\" from ink@5.0.0",
+			wantCode: codeDepConflict,
+			wantHint: "rerun with --legacy-peer-deps",
+		},
+		{
+			name:     "npm_eresolve_legacy",
+			args:     []string{"npm", "install", "-g", "pkg@latest", "--legacy-peer-deps"},
+			output:   "npm error code ERESOLVE",
+			wantCode: codeDepConflict,
+			wantHint: "even with --legacy-peer-deps",
+		},
+		{
+			name:     "pnpm_integrity",
+			args:     []string{"pnpm", "add", "-g", "pkg@latest"},
+			output:   " ERR_PNPM_TARBALL_INTEGRITY  Got unexpected checksum for \"https://registry.npmjs.org/pkg/-/pkg-1.0.0.tgz\"",
+			wantCode: codePnpmIntegrity,
+			wantHint: "pnpm store prune",
+		},
+		{
+			name:     "pnpm_store",
+			args:     []string{"pnpm", "add", "-g", "pkg@latest"},
+			output:   " ERR_PNPM_UNEXPECTED_STORE  Unexpected store location",
+			wantCode: codePnpmStore,
+			wantHint: "pnpm store prune",
+		},
+		{
+			name:     "pnpm_lockfile",
+			args:     []string{"pnpm", "add", "-g", "pkg@latest"},
+			output:   " ERR_PNPM_OUTDATED_LOCKFILE  Cannot install with \"frozen-lockfile\" because pnpm-lock.yaml is not up to date",
+			wantCode: codePnpmLockfile,
+			wantHint: "lockfile",
 		},
 		{
-			name:       "npm_enotempty",
-			args:       []string{"npm", "install", "-g", "pkg"},
-			output:     "npm error ENOTEMPTY: directory not empty",
-			wantReason: reasonNpmNotEmpty,
-			wantHint:   "npm rename failed",
+			name:     "pnpm_code_from_other_manager",
+			args:     []string{"npm", "install", "-g", "pkg"},
+			output:   "ERR_PNPM_TARBALL_INTEGRITY",
+			wantCode: "",
+			wantHint: "",
 		},
 		{
-			name:       "enotempty_non_npm",
-			args:       []string{"gemini", "--version"},
-			output:     "ENOTEMPTY",
-			wantReason: "",
-			wantHint:   "",
+			name:     "enotempty_non_npm",
+			args:     []string{"gemini", "--version"},
+			output:   "ENOTEMPTY",
+			wantCode: "",
+			wantHint: "",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotReason, gotHint := classifyUpdateFailure(tt.args, tt.output)
-			if gotReason != tt.wantReason {
-				t.Fatalf("classifyUpdateFailure() reason = %q, want %q", gotReason, tt.wantReason)
+			gotCode, gotHint := classifyUpdateFailure(tt.args, tt.output)
+			if gotCode != tt.wantCode {
+				t.Fatalf("classifyUpdateFailure() code = %q, want %q", gotCode, tt.wantCode)
 			}
 			if tt.wantHint != "" && !strings.Contains(gotHint, tt.wantHint) {
 				t.Fatalf("classifyUpdateFailure() hint = %q, want to contain %q", gotHint, tt.wantHint)
@@ -378,6 +683,11 @@ func TestNodeUpdateCommand_UsesLatestTag(t *testing.T) {
 			strat: agents.UpdateStrategy{Kind: agents.KindBun, Package: "pkg"},
 			want:  []string{"bun", "add", "-g", "pkg@latest"},
 		},
+		{
+			name:  "npm_tag",
+			strat: agents.UpdateStrategy{Kind: agents.KindNpm, Package: "pkg", Tag: "next"},
+			want:  []string{"npm", "install", "-g", "pkg@next"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -394,9 +704,11 @@ func TestNodeBatchUpdateCommand(t *testing.T) {
 		name string
 		kind string
 		pkgs []string
+		tags map[string]string
 		want []string
 	}{
 		{name: "npm", kind: agents.KindNpm, pkgs: []string{"a", "b"}, want: []string{"npm", "install", "-g", "a@latest", "b@latest"}},
+		{name: "npm_pinned_tag", kind: agents.KindNpm, pkgs: []string{"a", "b"}, tags: map[string]string{"b": "beta"}, want: []string{"npm", "install", "-g", "a@latest", "b@beta"}},
 		{name: "pnpm", kind: agents.KindPnpm, pkgs: []string{"a", "b"}, want: []string{"pnpm", "add", "-g", "a@latest", "b@latest"}},
 		{name: "yarn", kind: agents.KindYarn, pkgs: []string{"a", "b"}, want: []string{"yarn", "global", "add", "a@latest", "b@latest"}},
 		{name: "bun", kind: agents.KindBun, pkgs: []string{"a", "b"}, want: []string{"bun", "add", "-g", "a@latest", "b@latest"}},
@@ -405,30 +717,75 @@ func TestNodeBatchUpdateCommand(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := nodeBatchUpdateCommand(tt.kind, tt.pkgs); !reflect.DeepEqual(got, tt.want) {
+			if got := nodeBatchUpdateCommand(tt.kind, tt.pkgs, tt.tags); !reflect.DeepEqual(got, tt.want) {
 				t.Fatalf("nodeBatchUpdateCommand() = %#v, want %#v", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestAutoConcurrency(t *testing.T) {
+	tests := []struct {
+		name    string
+		tasks   int
+		network int
+		cpus    int
+		want    int
+	}{
+		{name: "all network, laptop", tasks: 10, network: 10, cpus: 8, want: 4},
+		{name: "all network, small", tasks: 10, network: 10, cpus: 2, want: 2},
+		{name: "mixed", tasks: 6, network: 4, cpus: 8, want: 6},
+		{name: "capped by cpus", tasks: 12, network: 2, cpus: 4, want: 4},
+		{name: "single cpu", tasks: 3, network: 0, cpus: 1, want: 2},
+		{name: "one task", tasks: 1, network: 1, cpus: 16, want: 1},
+		{name: "no tasks", tasks: 0, network: 0, cpus: 8, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := autoConcurrency(tt.tasks, tt.network, tt.cpus); got != tt.want {
+				t.Fatalf("autoConcurrency(%d, %d, %d) = %d, want %d", tt.tasks, tt.network, tt.cpus, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrencyFlag(t *testing.T) {
+	var n int
+	value := (*concurrencyFlag)(&n)
+	if err := value.Set("auto"); err != nil || n != concurrencyAuto || value.String() != "auto" {
+		t.Fatalf("Set(auto) = %d, %v (%q)", n, err, value.String())
+	}
+	if err := value.Set("3"); err != nil || n != 3 {
+		t.Fatalf("Set(3) = %d, %v", n, err)
+	}
+	for _, bad := range []string{"lots", "-1", "-2"} {
+		if err := value.Set(bad); err == nil || n != 3 {
+			t.Fatalf("Set(%s) = %d, %v; want an error and the value unchanged", bad, n, err)
+		}
+	}
+}
+
 func TestEffectiveConcurrency(t *testing.T) {
 	tests := []struct {
-		name  string
-		opts  options
-		tasks int
-		want  int
+		name    string
+		opts    options
+		tasks   int
+		network int
+		want    int
 	}{
 		{name: "serial", opts: options{Serial: true}, tasks: 10, want: 1},
-		{name: "safe_default", opts: options{Safe: true}, tasks: 10, want: 1},
+		{name: "safe_default", opts: options{Safe: true}, tasks: 10, want: defaultSafeConcurrency},
+		{name: "safe_tuned", opts: options{Safe: true, SafeConcurrency: 4}, tasks: 10, want: 4},
+		{name: "safe_fully_serial", opts: options{Safe: true, SafeConcurrency: 1}, tasks: 10, want: 1},
 		{name: "safe_override", opts: options{Safe: true, Concurrency: 3}, tasks: 10, want: 3},
 		{name: "explicit_concurrency", opts: options{Concurrency: 2}, tasks: 10, want: 2},
 		{name: "default_unlimited", opts: options{}, tasks: 7, want: 7},
 		{name: "no_tasks", opts: options{}, tasks: 0, want: 1},
+		{name: "auto_serial", opts: options{Serial: true, Concurrency: concurrencyAuto}, tasks: 10, want: 1},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := effectiveConcurrency(tt.opts, tt.tasks); got != tt.want {
+			if got := effectiveConcurrency(tt.opts, tt.tasks, tt.network); got != tt.want {
 				t.Fatalf("effectiveConcurrency() = %d, want %d", got, tt.want)
 			}
 		})
@@ -621,3 +978,2826 @@ func TestCleanupNpmENotEmpty(t *testing.T) {
 		t.Fatalf("cleanupNpmENotEmpty() did not remove %q", dest)
 	}
 }
+
+func TestFormatBeforeAfter(t *testing.T) {
+	agent := agents.Agent{Name: "codex"}
+	tests := []struct {
+		name string
+		res  result
+		want string
+	}{
+		{name: "updated", res: result{Agent: agent, Status: statusUpdated, Before: "0.1.0", After: "0.2.0"}, want: "codex: 0.1.0 -> 0.2.0"},
+		{name: "updated_same_version", res: result{Agent: agent, Status: statusUpdated, Before: "0.1.0", After: "0.1.0"}, want: ""},
+		{name: "unchanged", res: result{Agent: agent, Status: statusUnchanged, Before: "0.1.0", After: "0.1.0"}, want: ""},
+		{name: "skipped", res: result{Agent: agent, Status: statusSkipped, ReasonCode: codeMissing}, want: ""},
+		{name: "failed", res: result{Agent: agent, Status: statusFailed, ReasonCode: codeNetwork}, want: "codex: FAILED (network)"},
+		{name: "failed_no_reason", res: result{Agent: agent, Status: statusFailed}, want: "codex: FAILED (unknown error)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatBeforeAfter(tt.res); got != tt.want {
+				t.Fatalf("formatBeforeAfter() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveUpdateExtensionDiagnostic(t *testing.T) {
+	agent := agents.Agent{Name: "roo", Strategies: []agents.UpdateStrategy{{Kind: agents.KindVSCode, ExtensionID: "RooVeterinaryInc.roo-cline"}}}
+	tests := []struct {
+		name string
+		exts map[string]string
+		want string
+	}{
+		{name: "listed", exts: map[string]string{"b.ext": "2.0.0", "a.ext": "1.0.0"}, want: "`code --list-extensions` reported a.ext@1.0.0, b.ext@2.0.0"},
+		{name: "none", exts: map[string]string{}, want: "reported no extensions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &envState{codeCmd: "code", codeExts: tt.exts, binPathCache: map[string]string{}}
+			env.codeOnce.Do(func() {})
+			trace := &decisionTrace{}
+			_, reason, _, detail := resolveUpdateTrace(agent, env, trace)
+			if reason != codeMissing || !strings.Contains(detail, tt.want) {
+				t.Fatalf("resolveUpdateTrace() = %q, %q; want detail containing %q", reason, detail, tt.want)
+			}
+			if len(trace.steps) != 1 || !strings.Contains(trace.steps[0].Reason, tt.want) {
+				t.Fatalf("trace = %+v", trace.steps)
+			}
+		})
+	}
+}
+
+func TestCodeExtensionsMatchCaseInsensitively(t *testing.T) {
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		"code --list-extensions --show-versions": {{out: "rooveterinaryinc.roo-cline@3.0.0\nGitHub.copilot@1.2.0\n"}},
+	}}
+	env := &envState{runner: runner, codeCmd: "code"}
+	if !env.vscodeHas("RooVeterinaryInc.roo-cline") {
+		t.Fatalf("vscodeHas() missed a mixed-case id")
+	}
+	if got := env.vscodeVersion("github.Copilot"); got != "1.2.0" {
+		t.Fatalf("vscodeVersion() = %q, want %q", got, "1.2.0")
+	}
+	want := map[string]string{"rooveterinaryinc.roo-cline": "3.0.0", "GitHub.copilot": "1.2.0"}
+	if got := env.codeExtensionList(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("codeExtensionList() = %#v, want listed casing %#v", got, want)
+	}
+	agent := agents.Agent{Name: "roo", Strategies: []agents.UpdateStrategy{{Kind: agents.KindVSCode, ExtensionID: "RooVeterinaryInc.roo-cline"}}}
+	if cmd, _, method, _ := resolveUpdate(agent, env); method != agents.KindVSCode || cmdString(cmd) != "code --install-extension RooVeterinaryInc.roo-cline --force" {
+		t.Fatalf("resolveUpdate() = %v via %q", cmd, method)
+	}
+}
+
+func TestNodeVersionNote(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths map[string]string
+		want  string
+	}{
+		{name: "nvm mismatch", paths: map[string]string{"codex": "/home/u/.nvm/versions/node/v18.19.0/bin/codex", "node": "/home/u/.nvm/versions/node/v20.11.0/bin/node"}, want: "codex is installed under node v18.19.0 (nvm), but the active node is node v20.11.0"},
+		{name: "fnm mismatch", paths: map[string]string{"codex": "/home/u/.local/share/fnm/node-versions/v18.19.0/installation/bin/codex", "node": "/usr/bin/node"}, want: "but the active node is a node outside fnm (/usr/bin); the update goes to the active node's globals and won't change this copy, so run `fnm use 18.19.0` first"},
+		{name: "same version", paths: map[string]string{"codex": "/home/u/.nvm/versions/node/v20.11.0/bin/codex", "node": "/home/u/.nvm/versions/node/v20.11.0/bin/node"}},
+		{name: "no manager", paths: map[string]string{"codex": "/usr/local/bin/codex", "node": "/home/u/.nvm/versions/node/v20.11.0/bin/node"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &envState{binPathCache: tt.paths}
+			got := env.withNodeVersionNote("codex", "matched")
+			if tt.want == "" && got != "matched" {
+				t.Fatalf("withNodeVersionNote() = %q, want no note", got)
+			}
+			if tt.want != "" && !strings.Contains(got, tt.want) {
+				t.Fatalf("withNodeVersionNote() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidateCodeExtension(t *testing.T) {
+	env := &envState{
+		codeExts: map[string]string{"publisher.ext": "1.0.0", "other.ext": "2.0.0"},
+	}
+	env.codeOnce.Do(func() {})
+
+	if got := env.vscodeVersion("publisher.ext"); got != "1.0.0" {
+		t.Fatalf("vscodeVersion() = %q, want %q", got, "1.0.0")
+	}
+	// With no code CLI the re-query returns nothing, so the stale entry must be dropped.
+	env.invalidateCodeExtension("publisher.ext")
+	if got := env.vscodeVersion("publisher.ext"); got != "" {
+		t.Fatalf("vscodeVersion() after invalidate = %q, want empty", got)
+	}
+	if got := env.vscodeVersion("other.ext"); got != "2.0.0" {
+		t.Fatalf("vscodeVersion() for untouched id = %q, want %q", got, "2.0.0")
+	}
+}
+
+func TestParsePackageListOutputVersions(t *testing.T) {
+	out := "├── @google/gemini-cli@0.5.1\n└── opencode-ai@1.0.3\n"
+	got := parsePackageListOutput(out)
+	want := map[string]string{"@google/gemini-cli": "0.5.1", "opencode-ai": "1.0.3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parsePackageListOutput() = %#v, want %#v", got, want)
+	}
+}
+
+func TestParsePnpmListOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want map[string]string
+	}{
+		{name: "array", out: `[{"path":"/g/5","dependencies":{"@openai/codex":{"version":"0.2.0"}}}]`, want: map[string]string{"@openai/codex": "0.2.0"}},
+		{name: "single object", out: `{"dependencies":{"opencode-ai":{"version":"1.0.3"}}}`, want: map[string]string{"opencode-ai": "1.0.3"}},
+		{name: "dev dependencies", out: `[{"path":"/g/5","dependencies":{},"devDependencies":{"@google/gemini-cli":{"version":"0.5.1"}}}]`, want: map[string]string{"@google/gemini-cli": "0.5.1"}},
+		{name: "path keyed", out: `{"/home/u/.local/share/pnpm/global/5":{"dependencies":{"@openai/codex":{"version":"0.2.0"}}}}`, want: map[string]string{"@openai/codex": "0.2.0"}},
+		{name: "warning prefix", out: "WARN  Issues with peer dependencies found\n[{\"dependencies\":{\"opencode-ai\":{\"version\":\"1.0.3\"}}}]", want: map[string]string{"opencode-ai": "1.0.3"}},
+		{name: "empty", out: `[]`, want: map[string]string{}},
+		{name: "garbage", out: "ERR_PNPM_NO_GLOBAL_BIN_DIR", want: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePnpmListOutput(tt.out); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parsePnpmListOutput() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshNodePackagesDropsBinaryCache(t *testing.T) {
+	env := &envState{
+		binPathCache: map[string]string{"codex": "/old/bin/codex", "other": "/bin/other"},
+		npmPkgs:      map[string]string{"@openai/codex": "0.1.0"},
+	}
+	env.npmPkgOnce.Do(func() {})
+
+	if got := env.nodePackageVersion(agents.KindNpm, "@openai/codex"); got != "0.1.0" {
+		t.Fatalf("nodePackageVersion() = %q, want %q", got, "0.1.0")
+	}
+	// npm is not available, so the reload yields an empty package set.
+	env.refreshNodePackages(agents.KindNpm, []string{"codex"})
+	if got := env.nodePackageVersion(agents.KindNpm, "@openai/codex"); got != "" {
+		t.Fatalf("nodePackageVersion() after refresh = %q, want empty", got)
+	}
+	if _, ok := env.binPathCache["codex"]; ok {
+		t.Fatalf("refreshNodePackages() kept cached path for codex")
+	}
+	if _, ok := env.binPathCache["other"]; !ok {
+		t.Fatalf("refreshNodePackages() dropped unrelated cached path")
+	}
+}
+
+func TestDryRunCommandProblem(t *testing.T) {
+	env := &envState{binPathCache: map[string]string{"npm": "/usr/bin/npm", "nope": ""}}
+	tests := []struct {
+		name string
+		cmd  []string
+		want string
+	}{
+		{name: "found", cmd: []string{"npm", "install", "-g", "pkg@latest"}, want: ""},
+		{name: "missing", cmd: []string{"nope", "update"}, want: "would fail: nope not found"},
+		{name: "empty", cmd: nil, want: "would fail: empty command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dryRunCommandProblem(env, tt.cmd); got != tt.want {
+				t.Fatalf("dryRunCommandProblem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDryRunWouldFailKeepsDryRunStatus(t *testing.T) {
+	res := result{Agent: agents.Agent{Name: "pi"}, Status: statusUpdated, ReasonCode: codeWouldFail, ReasonDetail: "would fail: pnpm not found", UpdateCmd: "pnpm add -g pi@latest"}
+	if got, want := formatResult(res, options{DryRun: true}), "pi: pnpm add -g pi@latest [would fail: pnpm not found]"; got != want {
+		t.Fatalf("formatResult() = %q, want %q", got, want)
+	}
+	row := uiRow{status: statusUpdated, code: res.ReasonCode, reason: res.Reason()}
+	if got := statusLabelFor(row); got != "dry-run" {
+		t.Fatalf("statusLabelFor() = %q, want dry-run", got)
+	}
+	if hasFailures([]result{res}) {
+		t.Fatalf("hasFailures() = true for a dry-run preview")
+	}
+}
+
+func TestResolveColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("TERM", "xterm-256color")
+	tests := []struct {
+		name string
+		mode string
+		tty  bool
+		want bool
+	}{
+		{name: "always_non_tty", mode: modeAlways, tty: false, want: true},
+		{name: "never_tty", mode: modeNever, tty: true, want: false},
+		{name: "auto_tty", mode: modeAuto, tty: true, want: true},
+		{name: "auto_non_tty", mode: modeAuto, tty: false, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveColor(tt.mode, tt.tty); got != tt.want {
+				t.Fatalf("resolveColor(%q, %v) = %v, want %v", tt.mode, tt.tty, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgressTrackerLine(t *testing.T) {
+	start := time.Now()
+	p := progressTracker{total: 4, start: start}
+	p.apply(updateEvent{Index: 0, Phase: phaseDetect})
+	p.apply(updateEvent{Index: 0, Phase: phaseFinish})
+	p.apply(updateEvent{Index: 1, Phase: phaseStart})
+	p.apply(updateEvent{Index: 2, Phase: phaseStart})
+	p.apply(updateEvent{Index: 2, Phase: phaseFinish})
+
+	want := "uca: 2/4 done, 1 in progress, 1m30s elapsed"
+	if got := p.line(start.Add(90 * time.Second)); got != want {
+		t.Fatalf("line() = %q, want %q", got, want)
+	}
+}
+
+func TestParseAgentsFile(t *testing.T) {
+	input := "# team agents\nclaude\ncodex, gemini  # node ones\n\n  aider\tpi\n"
+	got, err := parseAgentsFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseAgentsFile() err = %v", err)
+	}
+	want := []string{"claude", "codex", "gemini", "aider", "pi"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseAgentsFile() = %#v, want %#v", got, want)
+	}
+}
+
+func TestChunkStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []string
+		size  int
+		want  [][]string
+	}{
+		{name: "empty", items: nil, size: 2, want: nil},
+		{name: "no_limit", items: []string{"a", "b", "c"}, size: 0, want: [][]string{{"a", "b", "c"}}},
+		{name: "even", items: []string{"a", "b", "c", "d"}, size: 2, want: [][]string{{"a", "b"}, {"c", "d"}}},
+		{name: "remainder", items: []string{"a", "b", "c"}, size: 2, want: [][]string{{"a", "b"}, {"c"}}},
+		{name: "larger_than_items", items: []string{"a"}, size: 5, want: [][]string{{"a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunkStrings(tt.items, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("chunkStrings() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintDetectReportText(t *testing.T) {
+	report := detectReport{
+		Managers: []managerReport{
+			{Kind: agents.KindNpm, Present: true, BinDir: "/usr/local/bin", Packages: map[string]string{"b": "2.0.0", "a": "1.0.0"}},
+			{Kind: agents.KindVSCode, Command: "code", Present: false},
+		},
+		Agents: []agentDetectReport{
+			{Name: "codex", Method: agents.KindNpm, Command: []string{"npm", "install", "-g", "@openai/codex@latest"}, Detail: "matched"},
+			{Name: "amp", Reason: "missing"},
+		},
+		Unknown: []string{"nope"},
+	}
+	var buf bytes.Buffer
+	if err := printDetectReport(&buf, report, false); err != nil {
+		t.Fatalf("printDetectReport() err = %v", err)
+	}
+	want := "managers:\n" +
+		"  npm: present, bin /usr/local/bin, 2 packages\n" +
+		"    a 1.0.0\n" +
+		"    b 2.0.0\n" +
+		"  vscode (code): not found\n" +
+		"agents:\n" +
+		"  codex: npm (npm install -g @openai/codex@latest)\n" +
+		"    matched\n" +
+		"  amp: skipped (missing)\n" +
+		"unknown: nope\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("printDetectReport() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBuildManagerReports(t *testing.T) {
+	env := &envState{
+		hasNpm:         true,
+		npmPkgs:        map[string]string{"@openai/codex": "0.1.0"},
+		detectTimedOut: map[string]string{agents.KindNpm: "npm bin -g"},
+		binPathCache:   map[string]string{},
+	}
+	env.npmBinOnce.Do(func() {})
+	env.npmPkgOnce.Do(func() {})
+	managers := buildManagerReports(env)
+	if len(managers) != 9 {
+		t.Fatalf("buildManagerReports() = %d managers, want 9", len(managers))
+	}
+	want := "npm: present, 1 packages; warning: `npm bin -g` timed out (raise --detect-timeout); global bin dir unknown; agents are matched by package list only"
+	if got := formatManagerLine(managers[0]); got != want {
+		t.Fatalf("npm line = %q\nwant %q", got, want)
+	}
+	if got := formatManagerLine(managers[1]); got != "pnpm: not found" {
+		t.Fatalf("pnpm line = %q", got)
+	}
+}
+
+func TestFilterAgentsAliases(t *testing.T) {
+	all := []agents.Agent{
+		{Name: "gemini", Aliases: []string{"gemini-cli", "gem"}},
+		{Name: "codex"},
+		{Name: "claude"},
+	}
+	tests := []struct {
+		name        string
+		only        string
+		skip        string
+		wantNames   []string
+		wantUnknown []string
+	}{
+		{name: "alias_only", only: "gemini-cli", wantNames: []string{"gemini"}, wantUnknown: []string{}},
+		{name: "alias_case", only: "GEM,codex", wantNames: []string{"gemini", "codex"}, wantUnknown: []string{}},
+		{name: "alias_skip", skip: "gem", wantNames: []string{"codex", "claude"}, wantUnknown: []string{}},
+		{name: "unknown", only: "nope", wantNames: []string{}, wantUnknown: []string{"nope"}},
+		{name: "glob_prefix", only: "c*", wantNames: []string{"codex", "claude"}, wantUnknown: []string{}},
+		{name: "glob_alias", only: "gem*", wantNames: []string{"gemini"}, wantUnknown: []string{}},
+		{name: "glob_skip", skip: "c?aude,gem", wantNames: []string{"codex"}, wantUnknown: []string{}},
+		{name: "glob_no_match", only: "z*,codex", wantNames: []string{"codex"}, wantUnknown: []string{"z*"}},
+		{name: "glob_malformed", only: "[c", wantNames: []string{}, wantUnknown: []string{"[c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, unknown := filterAgents(all, tt.only, tt.skip)
+			names := []string{}
+			for _, agent := range selected {
+				names = append(names, agent.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Fatalf("filterAgents() selected = %v, want %v", names, tt.wantNames)
+			}
+			if !reflect.DeepEqual(unknown, tt.wantUnknown) {
+				t.Fatalf("filterAgents() unknown = %v, want %v", unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestFormatFooter(t *testing.T) {
+	results := []result{
+		{Status: statusUpdated, Batched: true},
+		{Status: statusUnchanged, Batched: true},
+		{Status: statusFailed},
+		{Status: statusSkipped},
+	}
+	if got, want := formatFooter(results, 72*time.Second), "done in 1m12s (4 agents, 2 batched, 1 failed)"; got != want {
+		t.Fatalf("formatFooter() = %q, want %q", got, want)
+	}
+	if got, want := formatFooter(results[:2], 5*time.Second), "done in 5s (2 agents, 2 batched)"; got != want {
+		t.Fatalf("formatFooter() = %q, want %q", got, want)
+	}
+}
+
+func TestNodeManagerForBinaryPriorityTieBreak(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping PATH-based binary detection test on windows")
+	}
+	dir := t.TempDir()
+	binName := "fakecli"
+	if err := os.WriteFile(filepath.Join(dir, binName), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write fake binary: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	newTieEnv := func(priority []string) *envState {
+		env := &envState{
+			hasNpm:          true,
+			hasPnpm:         true,
+			binPathCache:    map[string]string{},
+			npmBin:          dir,
+			pnpmBin:         dir,
+			managerPriority: priority,
+		}
+		env.npmBinOnce.Do(func() {})
+		env.pnpmBinOnce.Do(func() {})
+		return env
+	}
+
+	if got := newTieEnv(nil).nodeManagerForBinary(binName); got != "" {
+		t.Fatalf("nodeManagerForBinary() without priority = %q, want empty", got)
+	}
+	if got := newTieEnv([]string{agents.KindPnpm, agents.KindNpm}).nodeManagerForBinary(binName); got != agents.KindPnpm {
+		t.Fatalf("nodeManagerForBinary() with priority = %q, want %q", got, agents.KindPnpm)
+	}
+}
+
+func TestApplyPins(t *testing.T) {
+	all := []agents.Agent{
+		{Name: "codex", Aliases: []string{"codex-cli"}, Strategies: []agents.UpdateStrategy{{Kind: agents.KindNpm, Package: "@openai/codex"}, {Kind: agents.KindBun, Package: "@openai/codex"}}},
+		{Name: "claude", Strategies: []agents.UpdateStrategy{{Kind: agents.KindNative, Command: []string{"claude", "update"}}}},
+	}
+
+	got, err := applyPins(all, map[string]string{"codex-cli": "beta"})
+	if err != nil {
+		t.Fatalf("applyPins() err = %v", err)
+	}
+	for _, strat := range got[0].Strategies {
+		if strat.Tag != "beta" {
+			t.Fatalf("applyPins() strategy %s tag = %q, want beta", strat.Kind, strat.Tag)
+		}
+	}
+	if all[0].Strategies[0].Tag != "" {
+		t.Fatalf("applyPins() mutated the input agents")
+	}
+
+	for _, pins := range []map[string]string{
+		{"nope": "beta"},
+		{"claude": "beta"},
+		{"codex": "1.0 beta"},
+	} {
+		if _, err := applyPins(all, pins); err == nil {
+			t.Fatalf("applyPins(%v) err = nil, want error", pins)
+		}
+	}
+}
+
+func TestFormatFailureClasses(t *testing.T) {
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusFailed, ReasonCode: codeNetwork, Log: "npm error ETIMEDOUT\nnpm error network"},
+		{Agent: agents.Agent{Name: "claude"}, Status: statusUpdated, Log: "ok"},
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusFailed, ReasonCode: codeNetwork, Log: "npm error ETIMEDOUT\nnpm error network"},
+		{Agent: agents.Agent{Name: "pi"}, Status: statusFailed, ReasonCode: codeNetwork, Log: "1\n2\n3\n4\n5\n6\nECONNRESET"},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusFailed, ReasonCode: codeExitStatus, ReasonDetail: "exit 2"},
+	}
+	want := "==> network (3 agents: codex, gemini, pi)\n" +
+		"npm error ETIMEDOUT\nnpm error network\n" +
+		"--> gemini (same output)\n" +
+		"--> pi (last 5 lines)\n3\n4\n5\n6\nECONNRESET\n" +
+		"==> exit 2 (1 agent: amp)\n" +
+		"(no output)\n"
+	if got := formatFailureClasses(results); got != want {
+		t.Fatalf("formatFailureClasses() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatSummaryErrorsOnly(t *testing.T) {
+	healthy := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated},
+		{Agent: agents.Agent{Name: "claude"}, Status: statusUnchanged},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusSkipped, ReasonCode: codeMissing},
+	}
+	if got := formatSummary(healthy, nil, time.Second, true); got != "" {
+		t.Fatalf("formatSummary(healthy) = %q, want empty", got)
+	}
+
+	failing := append(healthy, result{Agent: agents.Agent{Name: "pi"}, Status: statusFailed, ReasonCode: codeNetwork})
+	want := "failed: pi\ndone in 1s (4 agents, 0 batched, 1 failed)\n"
+	if got := formatSummary(failing, nil, time.Second, true); got != want {
+		t.Fatalf("formatSummary(failing) = %q, want %q", got, want)
+	}
+
+	full := formatSummary(failing, nil, time.Second, false)
+	for _, line := range []string{"updated: codex\n", "unchanged: claude\n", "skipped (missing): amp\n"} {
+		if !strings.Contains(full, line) {
+			t.Fatalf("formatSummary(full) = %q, missing %q", full, line)
+		}
+	}
+}
+
+func TestFormatReport(t *testing.T) {
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, Before: "0.1.0", After: "0.2.0", Method: agents.KindNpm, Duration: 2 * time.Second},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusSkipped, ReasonCode: codeMissing},
+	}
+	// --errors-only and --quiet only affect the console; the report is always complete.
+	opts := options{ErrorsOnly: true, Quiet: true}
+	data, err := formatReport(results, nil, time.Second, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "codex: 0.1.0 -> 0.2.0 (2s)\namp: skipped (missing)\nupdated: codex\nskipped (missing): amp\ndone in 1s (2 agents, 0 batched)\n"
+	if string(data) != want {
+		t.Fatalf("formatReport(text) = %q, want %q", data, want)
+	}
+
+	opts.JSON = true
+	data, err = formatReport(results, nil, time.Second, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report runReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("formatReport(json) is not JSON: %v\n%s", err, data)
+	}
+	if len(report.Agents) != 2 || report.Agents[0].After != "0.2.0" || report.Agents[0].DurationMs != 2000 || report.Agents[1].Reason != "missing" {
+		t.Fatalf("formatReport(json) = %+v", report)
+	}
+}
+
+func TestShortTimeoutWarning(t *testing.T) {
+	tests := []struct {
+		name string
+		opts options
+		want string
+	}{
+		{name: "default", opts: options{Timeout: 15 * time.Minute}},
+		{name: "disabled", opts: options{Timeout: 0}},
+		{name: "short", opts: options{Timeout: 10 * time.Second}, want: "--timeout 10s is shorter"},
+		{name: "short agent", opts: options{Timeout: 15 * time.Minute, agentTimeouts: map[string]time.Duration{"pi": 5 * time.Second, "amp": 0}}, want: "--timeout-agent pi=5s is shorter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shortTimeoutWarning(tt.opts)
+			if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+				t.Fatalf("shortTimeoutWarning() = %q, want containing %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutHint(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{timeout: 10 * time.Second, want: "likely too short"},
+		{timeout: 15 * time.Minute, want: "may be hung"},
+		{timeout: 0, want: "larger --timeout"},
+	}
+	for _, tt := range tests {
+		var res result
+		setFailureResult(&res, exitCodeTimeout, []string{"amp", "update"}, "", tt.timeout)
+		if !strings.Contains(res.Explain, tt.want) {
+			t.Fatalf("timeout %s hint = %q, want containing %q", tt.timeout, res.Explain, tt.want)
+		}
+	}
+}
+
+func TestResultReasonCode(t *testing.T) {
+	for code, label := range reasonLabels {
+		if got := (result{Status: statusSkipped, ReasonCode: code}).Reason(); got != label {
+			t.Fatalf("Reason() for %q = %q, want %q", code, got, label)
+		}
+	}
+	var res result
+	setFailureResult(&res, 3, []string{"amp", "update"}, "boom", 0)
+	if res.Reason() != "exit 3" || res.ReasonCode != codeExitStatus || res.ExitCode != 3 {
+		t.Fatalf("unclassified failure = %q (%q, exit %d)", res.Reason(), res.ReasonCode, res.ExitCode)
+	}
+	res = result{}
+	setFailureResult(&res, 1, []string{"npm", "install", "-g", "pkg"}, "npm error code ETIMEDOUT", 0)
+	if res.ReasonCode != codeNetwork || res.Reason() != "network" {
+		t.Fatalf("network failure = %q (%q)", res.Reason(), res.ReasonCode)
+	}
+	res = result{}
+	setFailureResult(&res, exitCodeTimeout, []string{"amp", "update"}, "", time.Minute)
+	if res.ReasonCode != codeTimeout || res.ExitCode != 0 {
+		t.Fatalf("timeout = %q (exit %d)", res.ReasonCode, res.ExitCode)
+	}
+	if got := (result{}).Reason(); got != "" {
+		t.Fatalf("Reason() without a code = %q", got)
+	}
+
+	report := buildRunReport([]result{{Agent: agents.Agent{Name: "pi"}, Status: statusFailed, ReasonCode: codeExitStatus, ReasonDetail: "exit 3", ExitCode: 3}}, nil, 0, false)
+	if got := report.Agents[0]; got.ReasonCode != string(codeExitStatus) || got.Reason != "exit 3" || got.ExitCode != 3 {
+		t.Fatalf("report agent = %+v", got)
+	}
+}
+
+func TestPrintFormatted(t *testing.T) {
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, Before: "0.1.0", After: "0.2.0", Method: agents.KindNpm, Duration: 2 * time.Second},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusSkipped, ReasonCode: codeMissing},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: `{{.Agent.Name}}\t{{.Status}}\t{{.After}}`, want: "codex\tupdated\t0.2.0\namp\tskipped\t\n"},
+		{format: "{{.Agent.Name}}={{.Duration.Seconds}}\n", want: "codex=2\namp=0\n"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseResultFormat(tt.format)
+		if err != nil {
+			t.Fatalf("parseResultFormat(%q) err = %v", tt.format, err)
+		}
+		var b bytes.Buffer
+		if err := printFormatted(&b, results, nil, time.Second, options{Format: tt.format, resultFormat: tmpl}); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Fatalf("printFormatted(%q) = %q, want %q", tt.format, b.String(), tt.want)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := printFormatted(&b, results, []string{"nope"}, time.Second, options{Format: formatJSON}); err != nil {
+		t.Fatal(err)
+	}
+	var report runReport
+	if err := json.Unmarshal(b.Bytes(), &report); err != nil || len(report.Agents) != 2 || report.Unknown[0] != "nope" {
+		t.Fatalf("printFormatted(json) = %s (err %v)", b.String(), err)
+	}
+}
+
+func TestPrintRunOutputJSONStdoutIsPure(t *testing.T) {
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, Before: "0.1.0", After: "0.2.0", Method: agents.KindNpm, Log: "changed 1 package"},
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusFailed, ReasonCode: codeNetwork, Log: "npm ERR! network ETIMEDOUT", UpdateCmd: "npm install -g gemini"},
+		{Agent: agents.Agent{Name: "claude"}, Status: statusFailed, ReasonCode: codeNetwork, Log: "curl: (6) Could not resolve host"},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusSkipped, ReasonCode: codeMissing},
+		{Agent: agents.Agent{Name: "aider"}, Status: statusUnchanged, Before: "1.0", After: "1.0", Advisories: "1 high"},
+	}
+	for _, quiet := range []bool{false, true} {
+		opts := options{Format: formatJSON, Quiet: quiet, JSON: true, Verbose: true, Explain: true, Check: true, ChangedSince: time.Hour}
+		var stdout, stderr bytes.Buffer
+		if err := printRunOutput(&stdout, &stderr, results, []string{"nope"}, time.Second, opts, runState{Agents: map[string]agentRecord{}}, false); err != nil {
+			t.Fatal(err)
+		}
+		dec := json.NewDecoder(&stdout)
+		var report runReport
+		if err := dec.Decode(&report); err != nil || len(report.Agents) != len(results) {
+			t.Fatalf("quiet=%v: stdout is not one JSON report (err %v)", quiet, err)
+		}
+		if dec.More() {
+			t.Fatalf("quiet=%v: stdout has more than one JSON document", quiet)
+		}
+		if !strings.Contains(stderr.String(), "failed: gemini claude") {
+			t.Fatalf("quiet=%v: summary missing from stderr:\n%s", quiet, stderr.String())
+		}
+		if hasLogs := strings.Contains(stderr.String(), "ETIMEDOUT"); hasLogs == quiet {
+			t.Fatalf("quiet=%v: logs on stderr = %v:\n%s", quiet, hasLogs, stderr.String())
+		}
+	}
+}
+
+func TestUnmovedAgents(t *testing.T) {
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, Before: "0.1.0", After: "0.2.0"},
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusUnchanged, Before: "0.5.1", After: "0.5.1"},
+		{Agent: agents.Agent{Name: "opencode"}, Status: statusUpdated, ReasonCode: codeBatchPartial},
+		{Agent: agents.Agent{Name: "aider"}, Status: statusUpdated, ReasonCode: codeReinstalled},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusSkipped, ReasonCode: codeCurrent},
+		{Agent: agents.Agent{Name: "claude"}, Status: statusFailed},
+	}
+	want := []string{"gemini", "opencode"}
+	if got := unmovedAgents(results); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unmovedAgents() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteTables(t *testing.T) {
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, Before: "0.1.0", After: "0.2.0", Method: agents.KindNpm, Duration: 2500 * time.Millisecond},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusFailed, Before: "amp 1.0\n(build 7)", ReasonCode: codeNetwork, ReasonDetail: "network, retry"},
+	}
+	tests := []struct {
+		format string
+		header bool
+		want   string
+	}{
+		{format: formatTSV, want: "codex\tupdated\t0.1.0\t0.2.0\tnpm\t2.5\t\namp\tfailed\tamp 1.0 (build 7)\t\t\t0\tnetwork, retry\n"},
+		{format: formatTSV, header: true, want: "name\tstatus\tbefore\tafter\tmethod\tduration_s\treason\ncodex\tupdated\t0.1.0\t0.2.0\tnpm\t2.5\t\namp\tfailed\tamp 1.0 (build 7)\t\t\t0\tnetwork, retry\n"},
+		{format: formatCSV, header: true, want: "name,status,before,after,method,duration_s,reason\ncodex,updated,0.1.0,0.2.0,npm,2.5,\namp,failed,\"amp 1.0\n(build 7)\",,,0,\"network, retry\"\n"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := printFormatted(&b, results, nil, time.Second, options{Format: tt.format, Header: tt.header}); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Fatalf("printFormatted(%s, header=%v) = %q, want %q", tt.format, tt.header, b.String(), tt.want)
+		}
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	opts := options{RefreshInterval: time.Second, DetectTimeout: time.Second, Color: modeAuto, Unicode: modeAuto}
+	for _, format := range []string{"", formatText, formatJSON, formatTSV, formatCSV, "{{.Agent.Name}}"} {
+		opts.Format = format
+		if err := validateOptions(opts); err != nil {
+			t.Fatalf("validateOptions(--format %q) err = %v", format, err)
+		}
+	}
+	opts.Format = "{{.Agent.Name"
+	if err := validateOptions(opts); err == nil || !strings.Contains(err.Error(), "--format") {
+		t.Fatalf("validateOptions(bad --format) err = %v, want --format error", err)
+	}
+	opts.Format, opts.Header = formatJSON, true
+	if err := validateOptions(opts); err == nil || !strings.Contains(err.Error(), "--header") {
+		t.Fatalf("validateOptions(--header --format json) err = %v, want --header error", err)
+	}
+}
+
+func TestFormatFailureDigest(t *testing.T) {
+	network := func(name string) result {
+		return result{Agent: agents.Agent{Name: name}, Status: statusFailed, ReasonCode: codeNetwork}
+	}
+	tests := []struct {
+		name    string
+		results []result
+		want    string
+	}{
+		{
+			name:    "single_failure",
+			results: []result{network("codex"), {Agent: agents.Agent{Name: "amp"}, Status: statusUpdated}},
+			want:    "",
+		},
+		{
+			name: "shared_network_failure",
+			results: []result{
+				network("codex"),
+				{Agent: agents.Agent{Name: "amp"}, Status: statusUpdated},
+				network("gemini"),
+				{Agent: agents.Agent{Name: "pi"}, Status: statusFailed, ReasonCode: codeExitStatus, ReasonDetail: "exit 3", ExitCode: 3},
+				network("opencode"),
+			},
+			want: "3 agents failed with network errors; check connectivity, proxy, or VPN\nretry the failed agents: uca --only codex,gemini,pi,opencode\n",
+		},
+		{
+			name: "different_reasons",
+			results: []result{
+				network("codex"),
+				{Agent: agents.Agent{Name: "copilot"}, Status: statusFailed, ReasonCode: codeBrewBusy},
+			},
+			want: "retry the failed agents: uca --only codex,copilot\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatFailureDigest(tt.results); got != tt.want {
+				t.Fatalf("formatFailureDigest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAnnotations(t *testing.T) {
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated},
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusFailed, ReasonCode: codeNetwork, Explain: "hint: retry\n50% done"},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusSkipped, ReasonCode: codeMissing},
+		{Agent: agents.Agent{Name: "pi"}, Status: statusSkipped, ReasonCode: codeManualInstall},
+		{Agent: agents.Agent{Name: "cline"}, Status: statusUpdated, ReasonCode: codeBatchPartial},
+		{Agent: agents.Agent{Name: "a,b"}, Status: statusFailed},
+	}
+	want := []string{
+		"::error title=gemini::network: hint: retry%0A50%25 done",
+		"::warning title=pi::manual install",
+		"::warning title=cline::batch partial",
+		"::error title=a%2Cb::failed",
+	}
+	if got := formatAnnotations(results); !reflect.DeepEqual(got, want) {
+		t.Fatalf("formatAnnotations() = %q\nwant %q", got, want)
+	}
+}
+
+func TestAsdfUpdateCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    string
+	}{
+		{name: "modern", version: "asdf version 0.16.7", want: "asdf install aider latest && asdf set --home aider latest"},
+		{name: "legacy", version: "v0.14.0-ccdd47d", want: "asdf install aider latest && asdf global aider latest"},
+		{name: "unparseable", version: "", want: "asdf install aider latest && asdf set --home aider latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := asdfUpdateCommand("aider", isLegacyAsdfVersion(tt.version))
+			if len(cmd) != 3 || cmd[0] != "sh" || cmd[1] != "-c" || cmd[2] != tt.want {
+				t.Fatalf("asdfUpdateCommand() = %q, want sh -c %q", cmd, tt.want)
+			}
+		})
+	}
+}
+
+func TestAsdfShimHas(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("ASDF_DATA_DIR", dataDir)
+	env := &envState{
+		hasAsdf: true,
+		binPathCache: map[string]string{
+			"aider": filepath.Join(dataDir, "shims", "aider"),
+			"codex": "/usr/local/bin/codex",
+		},
+	}
+	if !env.asdfShimHas("aider") {
+		t.Fatalf("asdfShimHas(aider) = false, want true")
+	}
+	if env.asdfShimHas("codex") {
+		t.Fatalf("asdfShimHas(codex) = true, want false")
+	}
+	env.hasAsdf = false
+	if env.asdfShimHas("aider") {
+		t.Fatalf("asdfShimHas(aider) without asdf = true, want false")
+	}
+}
+
+func TestGetVersionThroughShim(t *testing.T) {
+	asdfDir := t.TempDir()
+	miseDir := t.TempDir()
+	t.Setenv("ASDF_DATA_DIR", asdfDir)
+	t.Setenv("MISE_DATA_DIR", miseDir)
+	tests := []struct {
+		name    string
+		agent   agents.Agent
+		binPath string
+		replies map[string][]fakeReply
+		want    string
+	}{
+		{
+			name:    "configured prefix",
+			agent:   agents.Agent{Name: "pi", Binary: "pi", VersionCmd: []string{"pi", "--version"}, VersionPrefix: []string{"mise", "exec", "--"}},
+			binPath: "/usr/local/bin/pi",
+			replies: map[string][]fakeReply{"mise exec -- pi --version": {{out: "1.2.3"}}},
+			want:    "1.2.3",
+		},
+		{
+			name:    "asdf shim",
+			agent:   agents.Agent{Name: "pi", Binary: "pi", VersionCmd: []string{"pi", "--version"}},
+			binPath: filepath.Join(asdfDir, "shims", "pi"),
+			replies: map[string][]fakeReply{
+				"pi --version":           {{out: "No version is set for command pi", code: 126}},
+				"asdf exec pi --version": {{out: "2.0.0"}},
+			},
+			want: "2.0.0",
+		},
+		{
+			name:    "mise shim",
+			agent:   agents.Agent{Name: "pi", Binary: "pi", VersionCmd: []string{"pi", "--version"}},
+			binPath: filepath.Join(miseDir, "shims", "pi"),
+			replies: map[string][]fakeReply{
+				"pi --version":              {{out: "mise ERROR no version set", code: 1}},
+				"mise exec -- pi --version": {{out: "3.1.0"}},
+			},
+			want: "3.1.0",
+		},
+		{
+			name:    "not a shim",
+			agent:   agents.Agent{Name: "pi", Binary: "pi", VersionCmd: []string{"pi", "--version"}},
+			binPath: "/usr/local/bin/pi",
+			replies: map[string][]fakeReply{"pi --version": {{out: "boom", code: 1}}},
+			want:    "unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &envState{
+				hasAsdf:      true,
+				runner:       &fakeRunner{replies: tt.replies},
+				binPathCache: map[string]string{"pi": tt.binPath, "mise": "/usr/local/bin/mise"},
+			}
+			if got := getVersion(context.Background(), tt.agent, env, agents.KindNative); got != tt.want {
+				t.Fatalf("getVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveUpdateDetectTimeout(t *testing.T) {
+	agent := agents.Agent{
+		Name:       "codex",
+		Binary:     "codex",
+		Strategies: []agents.UpdateStrategy{{Kind: agents.KindNpm, Package: "@openai/codex"}},
+	}
+	env := &envState{binPathCache: map[string]string{"codex": ""}}
+	if _, reason, _, _ := resolveUpdate(agent, env); reason != codeMissing {
+		t.Fatalf("resolveUpdate() reason = %q, want %q", reason, codeMissing)
+	}
+
+	env.detectTimeout = 5 * time.Second
+	env.detectTimedOut = map[string]string{
+		agents.KindNpm:  "npm list -g --depth=0 --json",
+		agents.KindBrew: "brew list --formula --versions x",
+	}
+	_, reason, _, detail := resolveUpdate(agent, env)
+	if reason != codeDetectTimeout {
+		t.Fatalf("resolveUpdate() reason = %q, want %q", reason, codeDetectTimeout)
+	}
+	want := "warning: `npm list -g --depth=0 --json` timed out after 5s; agent may be installed (raise --detect-timeout)"
+	if detail != want {
+		t.Fatalf("resolveUpdate() detail = %q, want %q", detail, want)
+	}
+}
+
+func TestRecheckUnknownVersions(t *testing.T) {
+	prev := versionSettleDelay
+	versionSettleDelay = 0
+	t.Cleanup(func() { versionSettleDelay = prev })
+
+	env := &envState{codeExts: map[string]string{"ext.fresh": "1.2.0", "ext.same": "0.9.0"}}
+	env.codeOnce.Do(func() {})
+	results := []result{
+		{Agent: agents.Agent{Name: "fresh", ExtensionID: "ext.fresh"}, Status: statusUpdated, Before: "1.1.0", After: "unknown"},
+		{Agent: agents.Agent{Name: "same", ExtensionID: "ext.same"}, Status: statusUpdated, Before: "0.9.0", After: "unknown"},
+		{Agent: agents.Agent{Name: "gone", ExtensionID: "ext.gone"}, Status: statusUpdated, Before: "unknown", After: "unknown"},
+		{Agent: agents.Agent{Name: "failed", ExtensionID: "ext.fresh"}, Status: statusFailed, After: "unknown"},
+	}
+	recheckUnknownVersions(context.Background(), env, results)
+
+	want := []struct{ status, after string }{
+		{statusUpdated, "1.2.0"},
+		{statusUnchanged, "0.9.0"},
+		{statusUpdated, "unknown"},
+		{statusFailed, "unknown"},
+	}
+	for i, w := range want {
+		if results[i].Status != w.status || results[i].After != w.after {
+			t.Fatalf("results[%d] = %s %q, want %s %q", i, results[i].Status, results[i].After, w.status, w.after)
+		}
+	}
+}
+
+func TestInstallCommand(t *testing.T) {
+	gemini := agents.Agent{Name: "gemini", Binary: "gemini", Strategies: []agents.UpdateStrategy{
+		{Kind: agents.KindNpm, Package: "@google/gemini-cli"},
+		{Kind: agents.KindPnpm, Package: "@google/gemini-cli"},
+	}}
+	amp := agents.Agent{Name: "amp", Binary: "amp", Strategies: []agents.UpdateStrategy{{Kind: agents.KindNative, Command: []string{"amp", "update"}}}}
+	aider := agents.Agent{Name: "aider", Binary: "aider", Strategies: []agents.UpdateStrategy{
+		{Kind: agents.KindUv, Package: "aider-chat"},
+		{Kind: agents.KindPip, Package: "aider-chat"},
+	}}
+	tests := []struct {
+		name       string
+		agent      agents.Agent
+		env        *envState
+		wantCmd    []string
+		wantMethod string
+	}{
+		{name: "npm_first", agent: gemini, env: &envState{hasNpm: true, hasPnpm: true}, wantCmd: []string{"npm", "install", "-g", "@google/gemini-cli@latest"}, wantMethod: agents.KindNpm},
+		{name: "priority", agent: gemini, env: &envState{hasNpm: true, hasPnpm: true, managerPriority: []string{"pnpm"}}, wantCmd: []string{"pnpm", "add", "-g", "@google/gemini-cli@latest"}, wantMethod: agents.KindPnpm},
+		{name: "no_manager", agent: gemini, env: &envState{}},
+		{name: "native_only", agent: amp, env: &envState{hasNpm: true}},
+		{name: "pip_fallback", agent: aider, env: &envState{hasPython: true}, wantCmd: []string{"python3", "-m", "pip", "install", "aider-chat"}, wantMethod: agents.KindPip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, method, _ := installCommand(tt.agent, tt.env)
+			if !reflect.DeepEqual(cmd, tt.wantCmd) || method != tt.wantMethod {
+				t.Fatalf("installCommand() = %q, %q; want %q, %q", cmd, method, tt.wantCmd, tt.wantMethod)
+			}
+		})
+	}
+}
+
+func TestInstallMissingRequiresOnly(t *testing.T) {
+	opts := options{InstallMissing: true, RefreshInterval: time.Second, DetectTimeout: time.Second, Color: modeAuto, Unicode: modeAuto}
+	if err := validateOptions(opts); err == nil || !strings.Contains(err.Error(), "--install-all-missing") {
+		t.Fatalf("validateOptions() err = %v, want --install-missing guard", err)
+	}
+	if shouldInstallMissing(opts) {
+		t.Fatalf("shouldInstallMissing() = true without --only")
+	}
+	opts.Only = "codex"
+	if err := validateOptions(opts); err != nil {
+		t.Fatalf("validateOptions() err = %v", err)
+	}
+	if !shouldInstallMissing(opts) {
+		t.Fatalf("shouldInstallMissing() = false with --only")
+	}
+	if !shouldInstallMissing(options{InstallAllMissing: true}) {
+		t.Fatalf("shouldInstallMissing() = false with --install-all-missing")
+	}
+}
+
+func TestYarnBerrySkipsYarnStrategy(t *testing.T) {
+	tests := []struct {
+		version   string
+		wantYarn  bool
+		wantBerry bool
+	}{
+		{version: "1.22.22", wantYarn: true},
+		{version: "4.1.0", wantBerry: true},
+		{version: "", wantYarn: true},
+	}
+	for _, tt := range tests {
+		env := &envState{hasYarn: true, yarnVersion: tt.version}
+		env.yarnVerOnce.Do(func() {})
+		if got := env.yarnBerry(); got != tt.wantBerry {
+			t.Fatalf("yarnBerry(%q) = %v, want %v", tt.version, got, tt.wantBerry)
+		}
+		if got := env.hasNodeManager(agents.KindYarn); got != tt.wantYarn {
+			t.Fatalf("hasNodeManager(yarn) with %q = %v, want %v", tt.version, got, tt.wantYarn)
+		}
+	}
+
+	env := &envState{hasYarn: true, yarnVersion: "4.1.0", binPathCache: map[string]string{"codex": "/home/me/.yarn/bin/codex"}}
+	env.yarnVerOnce.Do(func() {})
+	agent := agents.Agent{Name: "codex", Binary: "codex", Strategies: []agents.UpdateStrategy{{Kind: agents.KindYarn, Package: "@openai/codex"}}}
+	cmd, reason, _, detail := resolveUpdate(agent, env)
+	if cmd != nil || reason != codeManualInstall || !strings.Contains(detail, "Yarn Berry") {
+		t.Fatalf("resolveUpdate() = %q, %q, %q; want manual install explaining Yarn Berry", cmd, reason, detail)
+	}
+}
+
+func TestNodeBinDirIgnoresCorepackShimDir(t *testing.T) {
+	nodeBin := t.TempDir()
+	shim := filepath.Join(nodeBin, "pnpm")
+	if err := os.WriteFile(shim, []byte("#!/bin/sh\nexec corepack pnpm \"$@\"\n"), 0o755); err != nil {
+		t.Fatalf("write shim: %v", err)
+	}
+	plain := filepath.Join(nodeBin, "bun")
+	if err := os.WriteFile(plain, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write bun: %v", err)
+	}
+	env := &envState{
+		hasPnpm:      true,
+		hasBun:       true,
+		binPathCache: map[string]string{"pnpm": shim, "bun": plain},
+		pnpmBin:      nodeBin,
+		bunGlobalBin: nodeBin,
+	}
+	env.pnpmBinOnce.Do(func() {})
+	env.bunBinOnce.Do(func() {})
+
+	if got := env.nodeBinDir(agents.KindPnpm); got != "" {
+		t.Fatalf("nodeBinDir(pnpm) = %q, want empty for corepack shim dir", got)
+	}
+	if got := env.nodeBinDir(agents.KindBun); got != nodeBin {
+		t.Fatalf("nodeBinDir(bun) = %q, want %q", got, nodeBin)
+	}
+	if got := env.withCorepackNote(agents.KindPnpm, "matched"); got != "matched; note: pnpm is a corepack shim" {
+		t.Fatalf("withCorepackNote(pnpm) = %q", got)
+	}
+	if got := env.withCorepackNote(agents.KindBun, "matched"); got != "matched" {
+		t.Fatalf("withCorepackNote(bun) = %q", got)
+	}
+
+	// A corepack shim whose manager reports a separate global bin dir is still trusted.
+	env = &envState{hasPnpm: true, binPathCache: map[string]string{"pnpm": shim}, pnpmBin: "/home/me/.local/share/pnpm"}
+	env.pnpmBinOnce.Do(func() {})
+	if got := env.nodeBinDir(agents.KindPnpm); got != "/home/me/.local/share/pnpm" {
+		t.Fatalf("nodeBinDir(pnpm) = %q, want PNPM_HOME dir", got)
+	}
+}
+
+func TestAgentTimeouts(t *testing.T) {
+	all := agents.Default()
+	timeouts, err := parseAgentTimeouts(all, []string{"claude-code=30m", "codex=0"})
+	if err != nil {
+		t.Fatalf("parseAgentTimeouts() err = %v", err)
+	}
+	opts := options{Timeout: 5 * time.Minute, agentTimeouts: timeouts}
+	if got := agentTimeout(opts, "claude"); got != 30*time.Minute {
+		t.Fatalf("agentTimeout(claude) = %s, want 30m", got)
+	}
+	if got := agentTimeout(opts, "codex"); got != 0 {
+		t.Fatalf("agentTimeout(codex) = %s, want 0", got)
+	}
+	if got := agentTimeout(opts, "gemini"); got != 5*time.Minute {
+		t.Fatalf("agentTimeout(gemini) = %s, want 5m", got)
+	}
+
+	for _, entry := range []string{"nope=1m", "claude=soon", "claude=-1m"} {
+		if _, err := parseAgentTimeouts(all, []string{entry}); err == nil {
+			t.Fatalf("parseAgentTimeouts(%q) err = nil, want error", entry)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		timeouts []time.Duration
+		want     time.Duration
+	}{
+		{name: "max", timeouts: []time.Duration{time.Minute, 20 * time.Minute, 5 * time.Minute}, want: 20 * time.Minute},
+		{name: "zero_disables", timeouts: []time.Duration{time.Minute, 0}, want: 0},
+		{name: "single", timeouts: []time.Duration{3 * time.Minute}, want: 3 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			works := make([]agentWork, len(tt.timeouts))
+			for i, d := range tt.timeouts {
+				works[i].timeout = d
+			}
+			if got := taskTimeout(works); got != tt.want {
+				t.Fatalf("taskTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNpmSingleGlobalInstall(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		want   string
+		wantOK bool
+	}{
+		{name: "scoped", args: []string{"npm", "install", "-g", "@openai/codex@latest"}, want: "@openai/codex", wantOK: true},
+		{name: "plain_flags", args: []string{"npm", "install", "--global", "--no-fund", "opencode-ai@beta"}, want: "opencode-ai", wantOK: true},
+		{name: "no_version", args: []string{"npm", "install", "-g", "cline"}, want: "cline", wantOK: true},
+		{name: "batch", args: []string{"npm", "install", "-g", "a@latest", "b@latest"}},
+		{name: "not_global", args: []string{"npm", "install", "a@latest"}},
+		{name: "pnpm", args: []string{"pnpm", "add", "-g", "a@latest"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := npmSingleGlobalInstall(tt.args)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("npmSingleGlobalInstall() = %q, %v; want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFormatCleanReinstallOutput(t *testing.T) {
+	got := formatCleanReinstallOutput("npm error EINVAL\n", []string{"npm", "uninstall", "-g", "cline"}, "removed 1 package\n", []string{"npm", "install", "-g", "cline@latest"}, "added 1 package\n")
+	want := "npm error EINVAL\n\n" +
+		"(uca) npm install still failing; clean reinstall (--clean-reinstall)\n" +
+		"(uca) npm uninstall -g cline\n" +
+		"removed 1 package\n" +
+		"(uca) npm install -g cline@latest\n" +
+		"added 1 package"
+	if got != want {
+		t.Fatalf("formatCleanReinstallOutput() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFindPowerShellShim(t *testing.T) {
+	empty := t.TempDir()
+	npmBin := t.TempDir()
+	shim := filepath.Join(npmBin, "codex.ps1")
+	if err := os.WriteFile(shim, []byte("#!/usr/bin/env pwsh\n"), 0o644); err != nil {
+		t.Fatalf("write shim: %v", err)
+	}
+	pathList := strings.Join([]string{empty, "", npmBin}, string(os.PathListSeparator))
+	if got := findPowerShellShim("codex", pathList); got != shim {
+		t.Fatalf("findPowerShellShim(codex) = %q, want %q", got, shim)
+	}
+	if got := findPowerShellShim("gemini", pathList); got != "" {
+		t.Fatalf("findPowerShellShim(gemini) = %q, want empty", got)
+	}
+}
+
+func TestBuildTasksNoBatch(t *testing.T) {
+	newWorks := func() []agentWork {
+		return []agentWork{
+			{index: 0, method: agents.KindNpm, nodePackageName: "@openai/codex", updateCmdSingle: []string{"npm", "install", "-g", "@openai/codex@latest"}},
+			{index: 1, method: agents.KindNpm, nodePackageName: "opencode-ai", updateCmdSingle: []string{"npm", "install", "-g", "opencode-ai@latest"}},
+			{index: 2, method: agents.KindNative, updateCmdSingle: []string{"amp", "update"}},
+			{index: 3},
+		}
+	}
+
+	works := newWorks()
+	tasks := buildTasks(works, options{})
+	if len(tasks) != 2 {
+		t.Fatalf("buildTasks() = %d tasks, want 2 (native + npm batch)", len(tasks))
+	}
+	if !works[0].batched || !works[1].batched {
+		t.Fatalf("buildTasks() did not mark npm agents as batched")
+	}
+	if want := "batched with 2 packages: @openai/codex opencode-ai"; works[0].explain != want || tasks[1].agents[1].explain != want {
+		t.Fatalf("buildTasks() explain = %q / %q, want %q", works[0].explain, tasks[1].agents[1].explain, want)
+	}
+
+	works = newWorks()
+	tasks = buildTasks(works, options{NoBatch: true})
+	if len(tasks) != 3 {
+		t.Fatalf("buildTasks(--no-batch) = %d tasks, want 3", len(tasks))
+	}
+	for _, task := range tasks {
+		if len(task.agents) != 1 || !reflect.DeepEqual(task.cmd, task.agents[0].updateCmdSingle) {
+			t.Fatalf("buildTasks(--no-batch) task = %+v, want single-agent command", task)
+		}
+	}
+	if works[0].batched || works[1].batched {
+		t.Fatalf("buildTasks(--no-batch) marked agents as batched")
+	}
+
+	works = newWorks()
+	tasks = buildTasks(works, options{LegacyPeerDeps: true})
+	if got, want := cmdString(tasks[1].cmd), "npm install -g @openai/codex@latest opencode-ai@latest --legacy-peer-deps"; got != want {
+		t.Fatalf("buildTasks(--legacy-peer-deps) batch = %q, want %q", got, want)
+	}
+
+	works = newWorks()
+	tasks = buildTasks(works, options{Fast: true})
+	if got, want := cmdString(tasks[1].cmd), "npm install -g @openai/codex@latest opencode-ai@latest --no-fund --no-audit"; got != want {
+		t.Fatalf("buildTasks(--fast) batch = %q, want %q", got, want)
+	}
+}
+
+func TestWithNpmFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  []string
+		opts options
+		want []string
+	}{
+		{name: "off", cmd: []string{"npm", "install", "-g", "a@latest"}, want: []string{"npm", "install", "-g", "a@latest"}},
+		{name: "fast", cmd: []string{"npm", "install", "-g", "a@latest"}, opts: options{Fast: true}, want: []string{"npm", "install", "-g", "a@latest", "--no-fund", "--no-audit"}},
+		{name: "both", cmd: []string{"npm", "update", "-g", "a"}, opts: options{Fast: true, LegacyPeerDeps: true}, want: []string{"npm", "update", "-g", "a", "--legacy-peer-deps", "--no-fund", "--no-audit"}},
+		{name: "not npm", cmd: []string{"pnpm", "add", "-g", "a@latest"}, opts: options{Fast: true}, want: []string{"pnpm", "add", "-g", "a@latest"}},
+		{name: "nil", opts: options{Fast: true}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withNpmFlags(tt.cmd, tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("withNpmFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunUpdateCmdRetriesWithoutFastFlags(t *testing.T) {
+	fast := []string{"npm", "install", "-g", "a@latest", "--no-fund", "--no-audit"}
+	plain := []string{"npm", "install", "-g", "a@latest"}
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		cmdString(fast):  {{out: "npm WARN Unknown cli config \"--no-fund\"\nnpm ERR! code EUSAGE", code: 1}},
+		cmdString(plain): {{out: "added 1 package"}},
+	}}
+	out, _, code, _, _ := runUpdateCmd(context.Background(), runner, fast, time.Minute, false, nil)
+	if code != 0 || !strings.Contains(out, "retrying without them") {
+		t.Fatalf("runUpdateCmd() = %q (exit %d), want a successful retry without --fast flags", out, code)
+	}
+	if want := []string{cmdString(fast), cmdString(plain)}; !reflect.DeepEqual(runner.calls, want) {
+		t.Fatalf("calls = %v, want %v", runner.calls, want)
+	}
+
+	// The warning alone is harmless: npm goes on, so a success is not rerun.
+	if rejectedNpmFastFlags(fast, 0, "npm WARN Unknown cli config \"--no-fund\"\nadded 1 package") {
+		t.Fatalf("rejectedNpmFastFlags() = true for a successful install")
+	}
+	// A failure without the warning is not the flags' fault.
+	if rejectedNpmFastFlags(fast, 1, "npm ERR! code E404\nnpm ERR! 404 Not Found - a") {
+		t.Fatalf("rejectedNpmFastFlags() = true for an unrelated failure")
+	}
+}
+
+func TestBuildTasksBatchesBrew(t *testing.T) {
+	works := []agentWork{
+		{index: 0, method: agents.KindBrew, updateCmdSingle: []string{"brew", "upgrade", "gh"}},
+		{index: 1, method: agents.KindNative, updateCmdSingle: []string{"amp", "update"}},
+		{index: 2, method: agents.KindBrew, updateCmdSingle: []string{"brew", "upgrade", "codex"}},
+		{index: 3, method: agents.KindBrew, updateCmdSingle: []string{"brew", "install", "goose"}},
+	}
+	tasks := buildTasks(works, options{})
+	got := []string{}
+	for _, task := range tasks {
+		got = append(got, cmdString(task.cmd))
+	}
+	want := []string{"amp update", "brew install goose", "brew upgrade codex gh"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("buildTasks() commands = %q, want %q", got, want)
+	}
+	if !works[0].batched || !works[2].batched || works[3].batched {
+		t.Fatalf("buildTasks() batched = %v/%v/%v, want only the upgrades", works[0].batched, works[2].batched, works[3].batched)
+	}
+	if len(tasks[2].agents) != 2 || tasks[2].kind != agents.KindBrew {
+		t.Fatalf("brew batch task = %+v", tasks[2])
+	}
+
+	if tasks := buildTasks(works, options{NoBatch: true}); len(tasks) != 4 {
+		t.Fatalf("buildTasks(--no-batch) = %d tasks, want 4", len(tasks))
+	}
+}
+
+func TestRunTaskBrewBatchFallsBack(t *testing.T) {
+	batch := []string{"brew", "upgrade", "a", "b"}
+	works := []agentWork{
+		{agent: agents.Agent{Name: "a", VersionCmd: []string{"a", "--version"}}, index: 0, method: agents.KindBrew, updateCmd: batch, updateCmdSingle: []string{"brew", "upgrade", "a"}, batched: true},
+		{agent: agents.Agent{Name: "b", VersionCmd: []string{"b", "--version"}}, index: 1, method: agents.KindBrew, updateCmd: batch, updateCmdSingle: []string{"brew", "upgrade", "b"}, batched: true},
+	}
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		"a --version":    {{out: "1.0.0"}, {out: "1.1.0"}},
+		"b --version":    {{out: "2.0.0"}},
+		cmdString(batch): {{out: "Error: b: no bottle available!", code: 1}},
+		"brew upgrade a": {{out: "==> Upgrading a"}},
+		"brew upgrade b": {{out: "Error: b: no bottle available!", code: 1}},
+	}}
+	env := &envState{runner: runner, binPathCache: map[string]string{}}
+	results := make([]result, 2)
+	runTask(context.Background(), updateTask{kind: agents.KindBrew, cmd: batch, agents: works}, env, options{}, newManagerLocker(), nil, results)
+	if results[0].Status != statusUpdated || results[1].Status != statusFailed {
+		t.Fatalf("statuses = %s/%s, want updated/failed", results[0].Status, results[1].Status)
+	}
+	if !strings.Contains(results[0].Explain, "batch update failed; retrying individually") {
+		t.Fatalf("explain = %q", results[0].Explain)
+	}
+}
+
+func TestUvUpgradeBatches(t *testing.T) {
+	newWorks := func() []agentWork {
+		return []agentWork{
+			{index: 0, method: agents.KindUv, updateCmdSingle: []string{"uv", "tool", "upgrade", "aider-chat"}},
+			{index: 1, method: agents.KindUv, updateCmdSingle: []string{"uv", "tool", "upgrade", "llm"}},
+			{index: 2, method: agents.KindUv, install: true, updateCmdSingle: uvToolInstallCommand("goose-ai")},
+		}
+	}
+	tests := []struct {
+		name      string
+		installed map[string]string
+		want      string
+	}{
+		{name: "all tools selected", installed: map[string]string{"aider-chat": "0.86.1", "llm": "0.19"}, want: "uv tool upgrade --all"},
+		{name: "other tool installed", installed: map[string]string{"aider-chat": "0.86.1", "llm": "0.19", "ruff": "0.6.0"}, want: "uv tool upgrade aider-chat llm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			works := newWorks()
+			env := &envState{hasUv: true, uvTools: tt.installed}
+			env.uvOnce.Do(func() {})
+			tasks := buildTasks(works, options{})
+			useUvUpgradeAll(tasks, works, env)
+			if len(tasks) != 2 {
+				t.Fatalf("buildTasks() = %d tasks, want install + batch", len(tasks))
+			}
+			if got := cmdString(tasks[1].cmd); got != tt.want || cmdString(works[0].updateCmd) != tt.want {
+				t.Fatalf("uv batch = %q (work %q), want %q", got, cmdString(works[0].updateCmd), tt.want)
+			}
+		})
+	}
+}
+
+func TestUvRollbackThenUpdateReinstallsUnpinned(t *testing.T) {
+	agent := agents.Agent{Name: "aider", Binary: "aider", Strategies: []agents.UpdateStrategy{{Kind: agents.KindUv, Package: "aider-chat"}}}
+	rollback, ok := rollbackCommand(agentWork{agent: agent, method: agents.KindUv}, "0.86.1")
+	if !ok || !strings.HasSuffix(cmdString(rollback), "aider-chat==0.86.1") {
+		t.Fatalf("rollbackCommand() = %q, %v", rollback, ok)
+	}
+
+	// The rollback leaves "==0.86.1" in the tool receipt; the next run must not `uv tool upgrade` into it.
+	tests := []struct {
+		name string
+		list string
+		want []string
+	}{
+		{name: "pinned", list: "aider-chat v0.86.1 [required: ==0.86.1]\n- aider", want: uvToolInstallCommand("aider-chat")},
+		{name: "unpinned", list: "aider-chat v0.86.1\n- aider", want: []string{"uv", "tool", "upgrade", "aider-chat"}},
+		{name: "range", list: "aider-chat v0.86.1 [required: >=0.80]\n- aider", want: []string{"uv", "tool", "upgrade", "aider-chat"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{replies: map[string][]fakeReply{"uv tool list --show-version-specifiers": {{out: tt.list}}}}
+			env := &envState{runner: runner, hasUv: true, binPathCache: map[string]string{}}
+			cmd, _, method, detail := resolveUpdate(agent, env)
+			if !reflect.DeepEqual(cmd, tt.want) || method != agents.KindUv {
+				t.Fatalf("resolveUpdate() = %q %q (%s), want %q", cmd, method, detail, tt.want)
+			}
+			if pinned := strings.Contains(detail, "pinned to ==0.86.1"); pinned != (tt.name == "pinned") {
+				t.Fatalf("detail = %q", detail)
+			}
+		})
+	}
+}
+
+func TestRunUpdateCmdUvFallsBackToForceReinstall(t *testing.T) {
+	upgrade := []string{"uv", "tool", "upgrade", "aider-chat"}
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		cmdString(upgrade): {{out: "error: Failed to upgrade aider-chat: interpreter not found", code: 2}},
+		cmdString(uvToolInstallCommand("aider-chat")): {{out: "Installed 1 executable: aider"}},
+	}}
+	out, _, exitCode, _, _ := runUpdateCmd(context.Background(), runner, upgrade, time.Minute, false, nil)
+	if exitCode != 0 || !strings.Contains(out, "(uca) uv tool upgrade failed; reinstalling with --force") {
+		t.Fatalf("runUpdateCmd() = %d:\n%s", exitCode, out)
+	}
+}
+
+func TestSkipDuplicateAgents(t *testing.T) {
+	npm := []string{"npm", "install", "-g", "@openai/codex@latest"}
+	works := []agentWork{
+		{agent: agents.Agent{Name: "codex"}, method: agents.KindNpm, updateCmdSingle: npm},
+		{agent: agents.Agent{Name: "codex-old"}, method: agents.KindNpm, updateCmdSingle: npm},
+		{agent: agents.Agent{Name: "codex-next"}, method: agents.KindNpm, updateCmdSingle: []string{"npm", "install", "-g", "@openai/codex@next"}},
+		{agent: agents.Agent{Name: "amp"}, reason: codeMissing},
+		{agent: agents.Agent{Name: "amp-too"}, reason: codeMissing},
+	}
+	skipDuplicateAgents(works)
+	for i, wantReason := range []reasonCode{"", codeDuplicate, "", codeMissing, codeMissing} {
+		if works[i].reason != wantReason {
+			t.Fatalf("%s reason = %q, want %q", works[i].agent.Name, works[i].reason, wantReason)
+		}
+	}
+	if works[1].updateCmdSingle != nil || !strings.Contains(works[1].explain, "same install as codex (`npm install -g @openai/codex@latest`)") {
+		t.Fatalf("duplicate work = %+v", works[1])
+	}
+}
+
+func TestSummarizeNodeInstall(t *testing.T) {
+	tests := []struct {
+		name string
+		kind string
+		out  string
+		want string
+	}{
+		{name: "npm", kind: agents.KindNpm, out: "\nadded 12 packages, removed 3 packages, changed 45 packages, and audited 200 packages in 2m\n", want: "npm packages: added 12, removed 3, changed 45"},
+		{name: "npm_single", kind: agents.KindNpm, out: "changed 1 package in 3s", want: "npm packages: changed 1"},
+		{name: "npm_up_to_date", kind: agents.KindNpm, out: "up to date, audited 10 packages in 1s", want: ""},
+		{name: "pnpm", kind: agents.KindPnpm, out: "Packages: +8 -2\n++++++++--\nDone in 4.1s", want: "pnpm packages: added 8, removed 2"},
+		{name: "bun", kind: agents.KindBun, out: "installed @openai/codex@0.40.0 with binaries:\n\n 3 packages installed [1.2s]", want: "bun packages: installed 3"},
+		{name: "yarn", kind: agents.KindYarn, out: "success Installed \"opencode-ai@1.0.0\"", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeNodeInstall(tt.kind, tt.out); got != tt.want {
+				t.Fatalf("summarizeNodeInstall() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagPartialBatch(t *testing.T) {
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, Before: "0.1.0", After: "0.2.0"},
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusUnchanged, Before: "1.0.0", After: "1.0.0"},
+		{Agent: agents.Agent{Name: "pi"}, Status: statusUnchanged, Before: "3.0.0", After: "3.0.0"},
+		{Agent: agents.Agent{Name: "cline"}, Status: statusUpdated, Before: "unknown", After: "unknown"},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusUnchanged, Before: "2.0.0", After: "2.0.0"},
+		{Agent: agents.Agent{Name: "qwen"}, Status: statusUnchanged, Before: "0.5.0", After: "0.5.0"},
+	}
+	// gemini was expected to reach 1.1.0; pi was already at its latest; amp had no preview (--quiet) and
+	// qwen's lookup failed, so neither has anything to be held to.
+	expected := []string{"0.2.0", "1.1.0", "v3.0.0", "", "", "unknown"}
+	flagPartialBatch(results, expected)
+
+	want := map[string]bool{"codex": false, "gemini": true, "pi": false, "cline": true, "amp": false, "qwen": false}
+	for _, res := range results {
+		if got := res.ReasonCode == codeBatchPartial; got != want[res.Agent.Name] {
+			t.Fatalf("%s flagged = %v, want %v", res.Agent.Name, got, want[res.Agent.Name])
+		}
+	}
+
+	// Without a sibling that moved there is nothing to compare against.
+	quiet := []result{
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusUnchanged, Before: "1.0.0", After: "1.0.0"},
+		{Agent: agents.Agent{Name: "cline"}, Status: statusUpdated, Before: "unknown", After: "unknown"},
+	}
+	flagPartialBatch(quiet, []string{"1.1.0", ""})
+	for _, res := range quiet {
+		if res.ReasonCode == codeBatchPartial {
+			t.Fatalf("%s flagged without a sibling update", res.Agent.Name)
+		}
+	}
+}
+
+func TestResolveUpdateTrace(t *testing.T) {
+	pnpmBin := filepath.Join(string(filepath.Separator), "home", "me", ".local", "share", "pnpm")
+	env := &envState{
+		hasPnpm:      true,
+		pnpmBin:      pnpmBin,
+		binPathCache: map[string]string{"codex": filepath.Join(pnpmBin, "codex"), "pnpm": ""},
+	}
+	env.pnpmBinOnce.Do(func() {})
+	agent := agents.Agent{Name: "codex", Binary: "codex", Strategies: []agents.UpdateStrategy{
+		{Kind: agents.KindBrew, Package: "codex"},
+		{Kind: agents.KindNpm, Package: "@openai/codex"},
+		{Kind: agents.KindPnpm, Package: "@openai/codex"},
+		{Kind: agents.KindBun, Package: "@openai/codex"},
+	}}
+
+	trace := &decisionTrace{}
+	_, _, method, _ := resolveUpdateTrace(agent, env, trace)
+	if method != agents.KindPnpm {
+		t.Fatalf("resolveUpdateTrace() method = %q, want pnpm", method)
+	}
+	want := []decisionStep{
+		{Kind: agents.KindBrew, Target: "codex", Outcome: outcomeRejected, Reason: "brew not available"},
+		{Kind: agents.KindNpm, Target: "@openai/codex", Outcome: outcomeRejected, Reason: "npm not available"},
+		{Kind: agents.KindPnpm, Target: "@openai/codex", Outcome: outcomeSelected, Reason: "pnpm global bin has codex; matched by bin dir; updating via pnpm"},
+	}
+	if !reflect.DeepEqual(trace.steps, want) {
+		t.Fatalf("trace steps = %#v\nwant %#v", trace.steps, want)
+	}
+
+	// A nil trace is allowed and records nothing.
+	if _, _, method, _ := resolveUpdateTrace(agent, env, nil); method != agents.KindPnpm {
+		t.Fatalf("resolveUpdateTrace(nil) method = %q, want pnpm", method)
+	}
+}
+
+func TestResolveUpdatePrefer(t *testing.T) {
+	pnpmBin := filepath.Join(string(filepath.Separator), "home", "me", ".local", "share", "pnpm")
+	agent := agents.Agent{Name: "claude", Binary: "claude", Strategies: []agents.UpdateStrategy{
+		{Kind: agents.KindNative, Command: []string{"claude", "update"}},
+		{Kind: agents.KindPnpm, Package: "@anthropic-ai/claude-code"},
+	}}
+	tests := []struct {
+		prefer string
+		want   string
+	}{
+		{prefer: "", want: agents.KindNative},
+		{prefer: preferNative, want: agents.KindNative},
+		{prefer: preferPackage, want: agents.KindPnpm},
+	}
+	for _, tt := range tests {
+		t.Run("prefer_"+tt.prefer, func(t *testing.T) {
+			env := &envState{
+				hasPnpm:      true,
+				pnpmBin:      pnpmBin,
+				prefer:       tt.prefer,
+				binPathCache: map[string]string{"claude": filepath.Join(pnpmBin, "claude"), "pnpm": ""},
+			}
+			env.pnpmBinOnce.Do(func() {})
+			if _, _, method, _ := resolveUpdate(agent, env); method != tt.want {
+				t.Fatalf("resolveUpdate() method = %q, want %q", method, tt.want)
+			}
+		})
+	}
+
+	// Package-first still falls back to the native updater when no package manager owns the binary.
+	env := &envState{prefer: preferPackage, binPathCache: map[string]string{"claude": "/usr/local/bin/claude"}}
+	if _, _, method, _ := resolveUpdate(agent, env); method != agents.KindNative {
+		t.Fatalf("resolveUpdate() without a package install = %q, want native", method)
+	}
+}
+
+func TestResolveUpdateReportedMethod(t *testing.T) {
+	methodCmd := []string{"mytool", "--install-method"}
+	agent := agents.Agent{Name: "mytool", Binary: "mytool", MethodCmd: methodCmd, Strategies: []agents.UpdateStrategy{
+		{Kind: agents.KindNative, Command: []string{"mytool", "update"}},
+		{Kind: agents.KindNpm, Package: "mytool"},
+	}}
+	tests := []struct {
+		name       string
+		reply      fakeReply
+		wantMethod string
+		wantNote   string
+	}{
+		{name: "reported", reply: fakeReply{out: "mytool 1.2.0\nInstalled via npm (global)\n"}, wantMethod: agents.KindNpm, wantNote: "`mytool --install-method` reports install method npm"},
+		{name: "unknown kind", reply: fakeReply{out: "installed via nix"}, wantMethod: agents.KindNative, wantNote: "named no known install method"},
+		{name: "failed", reply: fakeReply{out: "unknown flag", code: 2}, wantMethod: agents.KindNative, wantNote: "failed (exit 2); using detection"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &envState{
+				hasNpm:       true,
+				runner:       &fakeRunner{replies: map[string][]fakeReply{cmdString(methodCmd): {tt.reply}}},
+				binPathCache: map[string]string{"mytool": "/usr/local/bin/mytool"},
+			}
+			_, _, method, detail := resolveUpdate(agent, env)
+			if method != tt.wantMethod || !strings.Contains(detail, tt.wantNote) {
+				t.Fatalf("resolveUpdate() = %q (%s), want %q with %q", method, detail, tt.wantMethod, tt.wantNote)
+			}
+		})
+	}
+}
+
+func TestParseInstallMethod(t *testing.T) {
+	kinds := map[string]bool{agents.KindNative: true, agents.KindBrew: true, agents.KindNpm: true}
+	tests := []struct {
+		out  string
+		want string
+	}{
+		{"Installed via npm", agents.KindNpm},
+		{"install method: Homebrew", agents.KindBrew},
+		{"native installer (~/.local/bin)", agents.KindNative},
+		{"npmrc found; managed by brew", agents.KindBrew},
+		{"pnpm", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := parseInstallMethod(tt.out, kinds); got != tt.want {
+			t.Fatalf("parseInstallMethod(%q) = %q, want %q", tt.out, got, tt.want)
+		}
+	}
+}
+
+func TestResolveUpdateBrokenSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	npmBin := t.TempDir()
+	if err := os.Symlink("../lib/node_modules/@openai/codex/bin/codex.js", filepath.Join(npmBin, "codex")); err != nil {
+		t.Fatal(err)
+	}
+	agent := agents.Agent{Name: "codex", Binary: "codex", Strategies: []agents.UpdateStrategy{{Kind: agents.KindNpm, Package: "@openai/codex"}}}
+	env := &envState{hasNpm: true, npmBin: npmBin, binPathCache: map[string]string{"codex": ""}}
+	env.npmBinOnce.Do(func() {})
+
+	cmd, reason, method, detail := resolveUpdate(agent, env)
+	if cmd != nil || reason != codeBrokenInstall || method != agents.KindNpm || !strings.Contains(detail, "dangling symlink") {
+		t.Fatalf("resolveUpdate() = %q %q %q (%s)", cmd, reason, method, detail)
+	}
+	if label := (result{Status: statusSkipped, ReasonCode: reason}).Reason(); label != "broken install" {
+		t.Fatalf("Reason() = %q", label)
+	}
+
+	// With --reinstall the broken agent gets a forced install instead of a skip.
+	runner := &fakeRunner{replies: map[string][]fakeReply{}}
+	env.runner = runner
+	results := runAll(context.Background(), []agents.Agent{agent}, env, options{DryRun: true, Reinstall: true}, false)
+	if results[0].Status != statusUpdated || results[0].UpdateCmd != "npm install -g @openai/codex@latest --force" {
+		t.Fatalf("--reinstall result = %+v", results[0])
+	}
+}
+
+func TestResolveUpdateBinaryPackageMismatch(t *testing.T) {
+	pnpmBin := filepath.Join(string(filepath.Separator), "home", "me", ".local", "share", "pnpm")
+	agent := agents.Agent{Name: "pi", Binary: "pi", Strategies: []agents.UpdateStrategy{
+		{Kind: agents.KindPnpm, Package: "@mariozechner/pi-coding-agent"},
+	}}
+	tests := []struct {
+		name       string
+		pkgs       map[string]string
+		wantMethod string
+		wantReason reasonCode
+	}{
+		{name: "package_listed", pkgs: map[string]string{"@mariozechner/pi-coding-agent": "0.5.0"}, wantMethod: agents.KindPnpm},
+		{name: "other_tool", pkgs: map[string]string{"pi-calculator": "1.0.0"}, wantReason: codeManualInstall},
+		{name: "list_unavailable", pkgs: map[string]string{}, wantMethod: agents.KindPnpm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &envState{
+				hasPnpm:      true,
+				pnpmBin:      pnpmBin,
+				pnpmPkgs:     tt.pkgs,
+				binPathCache: map[string]string{"pi": filepath.Join(pnpmBin, "pi"), "pnpm": ""},
+			}
+			env.pnpmBinOnce.Do(func() {})
+			env.pnpmPkgOnce.Do(func() {})
+			_, reason, method, detail := resolveUpdate(agent, env)
+			if method != tt.wantMethod || reason != tt.wantReason {
+				t.Fatalf("resolveUpdate() = method %q reason %q (%s), want %q %q", method, reason, detail, tt.wantMethod, tt.wantReason)
+			}
+			if tt.wantReason != "" && !strings.Contains(detail, "different tool") {
+				t.Fatalf("detail = %q, want a different-tool warning", detail)
+			}
+		})
+	}
+}
+
+func TestRunTaskCanceledBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	task := updateTask{
+		kind: agents.KindNpm,
+		cmd:  []string{"npm", "install", "-g", "a@latest", "b@latest"},
+		agents: []agentWork{
+			{agent: agents.Agent{Name: "a"}, index: 0, method: agents.KindNpm},
+			{agent: agents.Agent{Name: "b"}, index: 1, method: agents.KindNpm},
+		},
+	}
+	results := make([]result, 2)
+	events := make(chan updateEvent, 4)
+	runTask(ctx, task, &envState{}, options{}, newManagerLocker(), events, results)
+	close(events)
+
+	for _, res := range results {
+		if res.Status != statusSkipped || res.ReasonCode != codeCanceled {
+			t.Fatalf("%s = %s (%s), want skipped (canceled)", res.Agent.Name, res.Status, res.ReasonCode)
+		}
+	}
+	finished := 0
+	for ev := range events {
+		if ev.Phase == phaseFinish {
+			finished++
+		}
+	}
+	if finished != 2 {
+		t.Fatalf("finish events = %d, want 2", finished)
+	}
+	if got := formatInterrupted(results); got != "uca: interrupted (2 agents not started)" {
+		t.Fatalf("formatInterrupted() = %q", got)
+	}
+}
+
+// fakeRunner is a commandRunner that replies from a script keyed by the command line. Each command's
+// replies are consumed in order and the last one repeats; unscripted commands fail with exit 127.
+type fakeRunner struct {
+	mu      sync.Mutex
+	replies map[string][]fakeReply
+	calls   []string
+}
+
+type fakeReply struct {
+	out  string
+	code int
+}
+
+func (f *fakeRunner) Run(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := cmdString(args)
+	f.calls = append(f.calls, key)
+	replies := f.replies[key]
+	if len(replies) == 0 {
+		return "command not found", 127, 0, errors.New("exit 127")
+	}
+	reply := replies[0]
+	if len(replies) > 1 {
+		f.replies[key] = replies[1:]
+	}
+	if reply.code != 0 {
+		return reply.out, reply.code, 0, errors.New("exit status")
+	}
+	return reply.out, 0, 0, nil
+}
+
+func (f *fakeRunner) Output(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error) {
+	return f.Run(ctx, args, timeout)
+}
+
+func TestRunTaskWithFakeRunner(t *testing.T) {
+	batch := []string{"npm", "install", "-g", "a@latest", "b@latest"}
+	workA := agentWork{
+		agent:           agents.Agent{Name: "a", VersionCmd: []string{"a", "--version"}},
+		index:           0,
+		method:          agents.KindNpm,
+		updateCmd:       batch,
+		updateCmdSingle: []string{"npm", "install", "-g", "a@latest"},
+		batched:         true,
+	}
+	workB := agentWork{
+		agent:           agents.Agent{Name: "b", VersionCmd: []string{"b", "--version"}},
+		index:           1,
+		method:          agents.KindNpm,
+		updateCmd:       batch,
+		updateCmdSingle: []string{"npm", "install", "-g", "b@latest"},
+		batched:         true,
+	}
+	task := updateTask{kind: agents.KindNpm, cmd: batch, agents: []agentWork{workA, workB}}
+
+	tests := []struct {
+		name       string
+		replies    map[string][]fakeReply
+		wantStatus []string
+		wantReason []reasonCode
+		wantCalls  int
+	}{
+		{
+			name: "batch_success",
+			replies: map[string][]fakeReply{
+				"a --version":    {{out: "1.0.0"}, {out: "1.1.0"}},
+				"b --version":    {{out: "2.0.0"}, {out: "2.1.0"}},
+				cmdString(batch): {{out: "changed 2 packages"}},
+			},
+			wantStatus: []string{statusUpdated, statusUpdated},
+			wantReason: []reasonCode{"", ""},
+			wantCalls:  5,
+		},
+		{
+			name: "batch_failure_falls_back",
+			replies: map[string][]fakeReply{
+				"a --version":             {{out: "1.0.0"}, {out: "1.1.0"}},
+				"b --version":             {{out: "2.0.0"}},
+				cmdString(batch):          {{out: "npm error ETIMEDOUT", code: 1}},
+				"npm install -g a@latest": {{out: "changed 1 package"}},
+				"npm install -g b@latest": {{out: "npm error code ETIMEDOUT", code: 1}},
+			},
+			wantStatus: []string{statusUpdated, statusFailed},
+			wantReason: []reasonCode{"", codeNetwork},
+			wantCalls:  7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{replies: tt.replies}
+			env := &envState{runner: runner, binPathCache: map[string]string{}}
+			results := make([]result, 2)
+			runTask(context.Background(), task, env, options{}, newManagerLocker(), nil, results)
+			for i, res := range results {
+				if res.Status != tt.wantStatus[i] || res.ReasonCode != tt.wantReason[i] {
+					t.Fatalf("%s = %s (%s), want %s (%s)\nlog: %s", res.Agent.Name, res.Status, res.ReasonCode, tt.wantStatus[i], tt.wantReason[i], res.Log)
+				}
+			}
+			if len(runner.calls) != tt.wantCalls {
+				t.Fatalf("calls = %v, want %d", runner.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRunTaskReinstallsStaleBun(t *testing.T) {
+	add := []string{"bun", "add", "-g", "pkg@latest"}
+	work := agentWork{
+		agent:           agents.Agent{Name: "a", VersionCmd: []string{"a", "--version"}},
+		method:          agents.KindBun,
+		nodePackageName: "pkg",
+		updateCmd:       add,
+		updateCmdSingle: add,
+	}
+	task := updateTask{kind: agents.KindBun, cmd: add, agents: []agentWork{work}}
+	latest := "bun info -g pkg@latest version --json"
+	update := "bun update -g --latest pkg"
+
+	tests := []struct {
+		name       string
+		replies    map[string][]fakeReply
+		wantStatus string
+		wantAfter  string
+		wantReason reasonCode
+		wantRemove bool
+		wantAdds   int
+		gate       *confirmGate
+	}{
+		{
+			name: "add_left_old_version",
+			replies: map[string][]fakeReply{
+				"a --version":       {{out: "1.0.0"}, {out: "1.0.0"}, {out: "1.0.0"}, {out: "1.1.0"}},
+				cmdString(add):      {{out: "installed pkg@1.0.0"}},
+				latest:              {{out: `"1.1.0"`}},
+				update:              {{out: "no changes"}},
+				"bun remove -g pkg": {{out: "removed"}},
+			},
+			wantStatus: statusUpdated,
+			wantAfter:  "1.1.0",
+			wantRemove: true,
+			wantAdds:   2,
+		},
+		{
+			name: "update_caught_up",
+			replies: map[string][]fakeReply{
+				"a --version":  {{out: "1.0.0"}, {out: "1.0.0"}, {out: "1.1.0"}},
+				cmdString(add): {{out: "installed pkg@1.0.0"}},
+				latest:         {{out: `"1.1.0"`}},
+				update:         {{out: "installed pkg@1.1.0"}},
+			},
+			wantStatus: statusUpdated,
+			wantAfter:  "1.1.0",
+			wantAdds:   1,
+		},
+		{
+			name: "remove_fails",
+			replies: map[string][]fakeReply{
+				"a --version":       {{out: "1.0.0"}},
+				cmdString(add):      {{out: "installed pkg@1.0.0"}},
+				latest:              {{out: `"1.1.0"`}},
+				update:              {{out: "error: unknown flag", code: 1}},
+				"bun remove -g pkg": {{out: "error: EACCES", code: 1}},
+			},
+			wantStatus: statusUnchanged,
+			wantAfter:  "1.0.0",
+			wantRemove: true,
+			wantAdds:   1,
+		},
+		{
+			name: "add_fails_after_remove",
+			replies: map[string][]fakeReply{
+				"a --version":       {{out: "1.0.0"}, {out: "1.0.0"}, {out: ""}},
+				cmdString(add):      {{out: "installed pkg@1.0.0"}, {out: "error: ETIMEDOUT", code: 1}},
+				latest:              {{out: `"1.1.0"`}},
+				update:              {{out: "error: unknown flag", code: 1}},
+				"bun remove -g pkg": {{out: "removed"}},
+			},
+			wantStatus: statusFailed,
+			wantAfter:  "unknown",
+			wantReason: codeUninstalled,
+			wantRemove: true,
+			wantAdds:   2,
+		},
+		{
+			name: "reinstall_declined",
+			replies: map[string][]fakeReply{
+				"a --version":  {{out: "1.0.0"}},
+				cmdString(add): {{out: "installed pkg@1.0.0"}},
+				latest:         {{out: `"1.1.0"`}},
+				update:         {{out: "no changes"}},
+			},
+			wantStatus: statusUnchanged,
+			wantAfter:  "1.0.0",
+			wantAdds:   1,
+			gate:       &confirmGate{in: bufio.NewReader(strings.NewReader("n\n")), out: io.Discard},
+		},
+		{
+			name: "already_latest",
+			replies: map[string][]fakeReply{
+				"a --version":  {{out: "1.1.0"}},
+				cmdString(add): {{out: "installed pkg@1.1.0"}},
+				latest:         {{out: `"1.1.0"`}},
+			},
+			wantStatus: statusUnchanged,
+			wantAfter:  "1.1.0",
+			wantAdds:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{replies: tt.replies}
+			env := &envState{runner: runner, binPathCache: map[string]string{}}
+			results := make([]result, 1)
+			runTask(context.Background(), task, env, options{gate: tt.gate}, newManagerLocker(), nil, results)
+			res := results[0]
+			if res.Status != tt.wantStatus || res.After != tt.wantAfter || res.ReasonCode != tt.wantReason {
+				t.Fatalf("result = %s %s (%s), want %s %s (%s)\nlog: %s", res.Status, res.After, res.ReasonCode, tt.wantStatus, tt.wantAfter, tt.wantReason, res.Log)
+			}
+			removed, adds := false, 0
+			for _, call := range runner.calls {
+				switch call {
+				case "bun remove -g pkg":
+					removed = true
+				case cmdString(add):
+					adds++
+				}
+			}
+			if removed != tt.wantRemove || adds != tt.wantAdds {
+				t.Fatalf("bun remove called = %v, bun add called %d times; want %v, %d (calls %v)", removed, adds, tt.wantRemove, tt.wantAdds, runner.calls)
+			}
+		})
+	}
+}
+
+func TestMarketplaceLatestVersion(t *testing.T) {
+	const payload = `{"results":[{"extensions":[{"versions":[
+		{"version":"3.2.0","properties":[{"key":"Microsoft.VisualStudio.Code.PreRelease","value":"true"}]},
+		{"version":"3.1.4","properties":[]},
+		{"version":"3.1.3"}
+	]}]}]}`
+	if got := parseMarketplaceLatest([]byte(payload)); got != "3.1.4" {
+		t.Fatalf("parseMarketplaceLatest() = %q, want 3.1.4", got)
+	}
+
+	var gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		gotBody = string(data)
+		_, _ = io.WriteString(w, payload)
+	}))
+	defer srv.Close()
+	orig := marketplaceQueryURL
+	t.Cleanup(func() { marketplaceQueryURL = orig })
+
+	marketplaceQueryURL = srv.URL
+	if got := marketplaceLatestVersion(context.Background(), "saoudrizwan.claude-dev"); got != "3.1.4" {
+		t.Fatalf("marketplaceLatestVersion() = %q, want 3.1.4", got)
+	}
+	if !strings.Contains(gotBody, "saoudrizwan.claude-dev") {
+		t.Fatalf("query body = %s, want extension id", gotBody)
+	}
+
+	// Unreachable marketplace: empty, not an error.
+	srv.Close()
+	if got := marketplaceLatestVersion(context.Background(), "saoudrizwan.claude-dev"); got != "" {
+		t.Fatalf("marketplaceLatestVersion(offline) = %q, want empty", got)
+	}
+}
+
+func TestPypiLatestVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pypi/aider-chat/json" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, `{"info":{"name":"aider-chat","version":"0.86.1"}}`)
+	}))
+	defer srv.Close()
+	orig := pypiJSONURL
+	t.Cleanup(func() { pypiJSONURL = orig })
+	pypiJSONURL = srv.URL + "/pypi/%s/json"
+
+	if got := pypiLatestVersion(context.Background(), "aider-chat"); got != "0.86.1" {
+		t.Fatalf("pypiLatestVersion() = %q, want 0.86.1", got)
+	}
+	if got := pypiLatestVersion(context.Background(), "does-not-exist"); got != "" {
+		t.Fatalf("pypiLatestVersion(missing) = %q, want empty", got)
+	}
+}
+
+func TestDryRunVersionSuffix(t *testing.T) {
+	tests := []struct {
+		method        string
+		before, after string
+		want          string
+	}{
+		{method: agents.KindVSCode, before: "3.1.3", after: "3.1.4", want: " [outdated: 3.1.3 -> 3.1.4]"},
+		{method: agents.KindVSCode, before: "3.1.4", after: "3.1.4", want: ""},
+		{method: agents.KindNpm, before: "codex-cli 0.40.0", after: "0.40.0", want: ""},
+		{method: agents.KindPip, before: "3.1.3", after: "unknown", want: " [latest unknown]"},
+		{method: agents.KindBrew, before: "unknown", after: "3.1.4", want: ""},
+		{method: agents.KindNative, before: "1.0.0", after: "1.0.0", want: " [target unknown]"},
+	}
+	for _, tt := range tests {
+		if got := dryRunVersionSuffix(result{Method: tt.method, Before: tt.before, After: tt.after}); got != tt.want {
+			t.Fatalf("dryRunVersionSuffix(%q, %q) = %q, want %q", tt.before, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestRefreshManagers(t *testing.T) {
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		"brew update": {{out: "Already up-to-date."}},
+	}}
+	env := &envState{runner: runner}
+	tasks := []updateTask{
+		{kind: agents.KindBrew, agents: []agentWork{{agent: agents.Agent{Name: "copilot"}}}},
+		{kind: agents.KindBrew, agents: []agentWork{{agent: agents.Agent{Name: "other"}}}},
+		{kind: agents.KindNpm, agents: []agentWork{{agent: agents.Agent{Name: "codex"}}}},
+		{kind: agents.KindAsdf, agents: []agentWork{{agent: agents.Agent{Name: "tool"}}}},
+	}
+	refreshes := refreshManagers(context.Background(), env, tasks, time.Minute)
+	if want := []string{"brew update", "asdf plugin update --all"}; !reflect.DeepEqual(runner.calls, want) {
+		t.Fatalf("calls = %v, want %v", runner.calls, want)
+	}
+
+	results := []result{
+		{Agent: agents.Agent{Name: "copilot"}, Method: agents.KindBrew, Status: statusUpdated, Log: "Upgrading copilot-cli"},
+		{Agent: agents.Agent{Name: "codex"}, Method: agents.KindNpm, Status: statusUpdated, Log: "changed 1 package"},
+		{Agent: agents.Agent{Name: "tool"}, Method: agents.KindAsdf, Status: statusUnchanged},
+	}
+	attachRefreshLogs(results, refreshes)
+	if want := "(uca) brew update (--refresh-first)\nAlready up-to-date.\n\nUpgrading copilot-cli"; results[0].Log != want {
+		t.Fatalf("brew log = %q, want %q", results[0].Log, want)
+	}
+	if results[1].Log != "changed 1 package" {
+		t.Fatalf("npm log changed: %q", results[1].Log)
+	}
+	if !strings.Contains(results[2].Log, "refresh failed (exit 127)") || !strings.Contains(results[2].Explain, "stale index") {
+		t.Fatalf("asdf result = %+v, want failed refresh noted", results[2])
+	}
+}
+
+func TestPrintConfigRoundTrips(t *testing.T) {
+	effective, err := applyPins(agents.Default(), map[string]string{"codex": "beta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := printConfig(&buf, effective); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "uca.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := agents.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig(--print-config output) error = %v", err)
+	}
+	if !reflect.DeepEqual(cfg.Agents, effective) {
+		t.Fatalf("round trip changed the agents:\n%s", buf.String())
+	}
+}
+
+func TestSkipCurrentAgents(t *testing.T) {
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		"codex --version":                              {{out: "codex-cli 0.40.0"}},
+		"npm view @openai/codex dist-tags.latest":      {{out: "0.40.0"}},
+		"gemini --version":                             {{out: "0.1.0"}},
+		"npm view @google/gemini-cli dist-tags.latest": {{out: "0.2.0"}},
+		"copilot --version":                            {{out: "0.0.300"}},
+		"brew info --json=v2 copilot-cli":              {{out: `{"formulae":[{"versions":{"stable":"0.0.300"}}]}`}},
+		"amp --version":                                {{out: "1.0.0"}},
+	}}
+	env := &envState{runner: runner, binPathCache: map[string]string{}}
+	works := []agentWork{
+		{agent: agents.Agent{Name: "codex", VersionCmd: []string{"codex", "--version"}}, method: agents.KindNpm, nodePackageName: "@openai/codex", updateCmdSingle: []string{"npm", "install", "-g", "@openai/codex@latest"}},
+		{agent: agents.Agent{Name: "gemini", VersionCmd: []string{"gemini", "--version"}}, method: agents.KindNpm, nodePackageName: "@google/gemini-cli", updateCmdSingle: []string{"npm", "install", "-g", "@google/gemini-cli@latest"}},
+		{agent: agents.Agent{Name: "copilot", VersionCmd: []string{"copilot", "--version"}, Strategies: []agents.UpdateStrategy{{Kind: agents.KindBrew, Package: "copilot-cli"}}}, method: agents.KindBrew, updateCmdSingle: []string{"brew", "upgrade", "copilot-cli"}},
+		{agent: agents.Agent{Name: "amp", VersionCmd: []string{"amp", "--version"}}, method: agents.KindNative, updateCmdSingle: []string{"amp", "update"}},
+	}
+	skipCurrentAgents(context.Background(), env, works)
+
+	wantSkipped := map[string]bool{"codex": true, "gemini": false, "copilot": true, "amp": false}
+	for _, work := range works {
+		skipped := work.updateCmdSingle == nil
+		if skipped != wantSkipped[work.agent.Name] {
+			t.Fatalf("%s skipped = %v, want %v (%s)", work.agent.Name, skipped, wantSkipped[work.agent.Name], work.explain)
+		}
+		if skipped && work.reason != codeCurrent {
+			t.Fatalf("%s reason = %q, want %q", work.agent.Name, work.reason, codeCurrent)
+		}
+	}
+}
+
+func TestWatchLoopStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cycles := 0
+	done := make(chan struct{})
+	go func() {
+		watchLoop(ctx, time.Millisecond, func() {
+			cycles++
+			if cycles == 3 {
+				cancel()
+			}
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchLoop did not return after cancel")
+	}
+	if cycles != 3 {
+		t.Fatalf("cycles = %d, want 3", cycles)
+	}
+}
+
+func TestGuardMajorUpgrades(t *testing.T) {
+	newWorks := func() []agentWork {
+		return []agentWork{
+			{agent: agents.Agent{Name: "codex", VersionCmd: []string{"codex", "--version"}}, method: agents.KindNpm, nodePackageName: "@openai/codex", updateCmdSingle: []string{"npm", "install", "-g", "@openai/codex@latest"}},
+			{agent: agents.Agent{Name: "gemini", VersionCmd: []string{"gemini", "--version"}}, method: agents.KindNpm, nodePackageName: "@google/gemini-cli", updateCmdSingle: []string{"npm", "install", "-g", "@google/gemini-cli@latest"}},
+		}
+	}
+	replies := func() map[string][]fakeReply {
+		return map[string][]fakeReply{
+			"codex --version":                              {{out: "codex-cli 1.4.0"}},
+			"npm view @openai/codex dist-tags.latest":      {{out: "2.0.0"}},
+			"gemini --version":                             {{out: "0.9.0"}},
+			"npm view @google/gemini-cli dist-tags.latest": {{out: "0.10.0"}},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		ask         func(string) bool
+		wantSkipped bool
+	}{
+		{name: "non_interactive", ask: nil, wantSkipped: true},
+		{name: "declined", ask: func(string) bool { return false }, wantSkipped: true},
+		{name: "confirmed", ask: func(string) bool { return true }, wantSkipped: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &envState{runner: &fakeRunner{replies: replies()}, binPathCache: map[string]string{}}
+			works := newWorks()
+			guardMajorUpgrades(context.Background(), env, works, tt.ask)
+			if skipped := works[0].updateCmdSingle == nil; skipped != tt.wantSkipped {
+				t.Fatalf("codex skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+			if tt.wantSkipped && (works[0].reason != codeMajorUpgrade || !strings.Contains(works[0].explain, "--allow-major")) {
+				t.Fatalf("codex = %q (%s), want major upgrade hint", works[0].reason, works[0].explain)
+			}
+			if works[1].updateCmdSingle == nil {
+				t.Fatalf("gemini 0.9.0 -> 0.10.0 is not a major upgrade but was skipped")
+			}
+		})
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"y\n", true},
+		{"YES\n", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if got := confirm(bufio.NewReader(strings.NewReader(tt.input)), &out, "go?"); got != tt.want {
+			t.Fatalf("confirm(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if out.String() != "go? [y/N] " {
+			t.Fatalf("prompt = %q", out.String())
+		}
+	}
+}
+
+func TestBuildMetadata(t *testing.T) {
+	oldVersion, oldCommit := version, commit
+	t.Cleanup(func() { version, commit = oldVersion, oldCommit })
+	version, commit = "1.2.3", "abc123"
+	data, err := json.Marshal(buildMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`{"version":"1.2.3","commit":"abc123","goVersion":%q,"os":%q,"arch":%q}`, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if string(data) != want {
+		t.Fatalf("buildMetadata() = %s, want %s", data, want)
+	}
+}
+
+func TestCommandDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"npm", "install", "-g", "@openai/codex@latest"}, home},
+		{[]string{"/usr/local/bin/pnpm", "add", "-g", "x"}, home},
+		{[]string{"npm.cmd", "list", "-g"}, home},
+		{[]string{"bun", "add", "-g", "x"}, home},
+		{[]string{"brew", "upgrade", "x"}, ""},
+		{[]string{"uv", "tool", "upgrade", "x"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := commandDir(tt.args); got != tt.want {
+			t.Fatalf("commandDir(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRunUpdateCmdCleanReinstallAsksFirst(t *testing.T) {
+	install := []string{"npm", "install", "-g", "@google/gemini-cli@latest"}
+	uninstall := []string{"npm", "uninstall", "-g", "@google/gemini-cli"}
+	tests := []struct {
+		name          string
+		gate          *confirmGate
+		wantUninstall bool
+	}{
+		{name: "no gate", gate: nil, wantUninstall: true},
+		{name: "confirmed", gate: &confirmGate{in: bufio.NewReader(strings.NewReader("y\n")), out: io.Discard}, wantUninstall: true},
+		{name: "declined", gate: &confirmGate{in: bufio.NewReader(strings.NewReader("n\n")), out: io.Discard}, wantUninstall: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{replies: map[string][]fakeReply{
+				cmdString(install):   {{out: "npm error ENOTEMPTY", code: 1}},
+				cmdString(uninstall): {{out: "removed 1 package"}},
+			}}
+			_, _, _, _, _ = runUpdateCmd(context.Background(), runner, install, time.Minute, true, tt.gate)
+			uninstalled := false
+			for _, call := range runner.calls {
+				if call == cmdString(uninstall) {
+					uninstalled = true
+				}
+			}
+			if uninstalled != tt.wantUninstall {
+				t.Fatalf("uninstalled = %v, want %v (calls %v)", uninstalled, tt.wantUninstall, runner.calls)
+			}
+		})
+	}
+}
+
+func TestRunUpdateCmdCleanReinstall(t *testing.T) {
+	install := []string{"npm", "install", "-g", "cline@latest"}
+	uninstall := []string{"npm", "uninstall", "-g", "cline"}
+	tests := []struct {
+		name      string
+		replies   map[string][]fakeReply
+		wantCalls []string
+		wantCode  int
+		wantErr   error
+		wantOut   string
+	}{
+		{
+			name:      "first failure is not retried away",
+			replies:   map[string][]fakeReply{cmdString(install): {{out: "npm error EINVAL", code: 1}}},
+			wantCalls: []string{cmdString(install)},
+			wantCode:  1,
+		},
+		{
+			name: "uninstall fails",
+			replies: map[string][]fakeReply{
+				cmdString(install):   {{out: "npm error ENOTEMPTY", code: 1}},
+				cmdString(uninstall): {{out: "npm error EACCES", code: 243}},
+			},
+			wantCalls: []string{cmdString(install), cmdString(install), cmdString(uninstall)},
+			wantCode:  1,
+			wantOut:   "(uca) npm uninstall failed (exit 243); not reinstalling",
+		},
+		{
+			name: "reinstall fails",
+			replies: map[string][]fakeReply{
+				cmdString(install):   {{out: "npm error ENOTEMPTY", code: 1}, {out: "npm error ENOTEMPTY", code: 1}, {out: "npm error ETIMEDOUT", code: 1}},
+				cmdString(uninstall): {{out: "removed 1 package"}},
+			},
+			wantCalls: []string{cmdString(install), cmdString(install), cmdString(uninstall), cmdString(install)},
+			wantCode:  1,
+			wantErr:   errRemovedNotReinstalled,
+			wantOut:   "(uca) cline was uninstalled and the install failed; it is no longer installed",
+		},
+		{
+			name: "reinstall succeeds",
+			replies: map[string][]fakeReply{
+				cmdString(install):   {{out: "npm error ENOTEMPTY", code: 1}, {out: "npm error ENOTEMPTY", code: 1}, {out: "added 1 package"}},
+				cmdString(uninstall): {{out: "removed 1 package"}},
+			},
+			wantCalls: []string{cmdString(install), cmdString(install), cmdString(uninstall), cmdString(install)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{replies: tt.replies}
+			out, _, code, _, err := runUpdateCmd(context.Background(), runner, install, time.Minute, true, nil)
+			if !reflect.DeepEqual(runner.calls, tt.wantCalls) {
+				t.Fatalf("calls = %q, want %q", runner.calls, tt.wantCalls)
+			}
+			if code != tt.wantCode || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) || !strings.Contains(out, tt.wantOut) {
+				t.Fatalf("runUpdateCmd() = exit %d, err %v:\n%s", code, err, out)
+			}
+		})
+	}
+
+	res := result{Agent: agents.Agent{Name: "cline"}, Status: statusFailed}
+	setFailureResult(&res, 1, install, "npm error ETIMEDOUT", time.Minute)
+	markRemovedNotReinstalled(&res, install)
+	if res.Reason() != "removed, reinstall failed" || !strings.Contains(res.Explain, "reinstalling it failed (network), so it is no longer installed") {
+		t.Fatalf("markRemovedNotReinstalled() = %q; %s", res.Reason(), res.Explain)
+	}
+}
+
+func TestRunTaskLockTimeout(t *testing.T) {
+	install := []string{"npm", "install", "-g", "pkg@latest"}
+	work := agentWork{agent: agents.Agent{Name: "a", VersionCmd: []string{"a", "--version"}, ConflictGroups: []string{"node"}}, method: agents.KindNpm, updateCmd: install, updateCmdSingle: install, show: true}
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		"a --version":      {{out: "1.0.0"}},
+		cmdString(install): {{out: "changed 1 package"}},
+	}}
+	env := &envState{runner: runner, binPathCache: map[string]string{}}
+	locker := newManagerLocker()
+	unlock, _ := locker.lockContext(context.Background(), conflictLockPrefix+"node")
+	defer unlock()
+	results := make([]result, 1)
+	runTask(context.Background(), updateTask{kind: agents.KindNpm, cmd: install, agents: []agentWork{work}}, env, options{LockTimeout: 20 * time.Millisecond}, locker, nil, results)
+	if res := results[0]; res.Status != statusSkipped || res.ReasonCode != codeLockTimeout || !strings.Contains(res.Explain, `conflict group "node"`) {
+		t.Fatalf("result = %+v, want a lock-timeout skip naming the conflict group", res)
+	}
+	if len(runner.calls) != 0 {
+		t.Fatalf("calls = %v, want none", runner.calls)
+	}
+	// The npm lock taken before the conflict group was given back.
+	select {
+	case locker.locks[agents.KindNpm] <- struct{}{}:
+	default:
+		t.Fatalf("npm lock still held after the lock timeout")
+	}
+}
+
+func TestReinstallCommand(t *testing.T) {
+	tests := []struct {
+		kind   string
+		cmd    []string
+		want   []string
+		wantOK bool
+	}{
+		{kind: agents.KindNpm, cmd: []string{"npm", "install", "-g", "a@latest", "b@latest"}, want: []string{"npm", "install", "-g", "a@latest", "b@latest", "--force"}, wantOK: true},
+		{kind: agents.KindBun, cmd: []string{"bun", "add", "-g", "a@latest", "--force"}, want: []string{"bun", "add", "-g", "a@latest", "--force"}, wantOK: true},
+		{kind: agents.KindBrew, cmd: []string{"brew", "upgrade", "copilot-cli"}, want: []string{"brew", "reinstall", "copilot-cli"}, wantOK: true},
+		{kind: agents.KindPip, cmd: []string{"python3", "-m", "pip", "install", "-U", "aider-chat"}, want: []string{"python3", "-m", "pip", "install", "-U", "aider-chat", "--force-reinstall"}, wantOK: true},
+		{kind: agents.KindUv, cmd: uvToolInstallCommand("aider-chat"), want: uvToolInstallCommand("aider-chat"), wantOK: true},
+		{kind: agents.KindUv, cmd: []string{"uv", "tool", "upgrade", "aider-chat"}, want: uvToolInstallCommand("aider-chat"), wantOK: true},
+		{kind: agents.KindNative, cmd: []string{"claude", "update"}, want: []string{"claude", "update"}, wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := reinstallCommand(tt.kind, tt.cmd)
+		if !reflect.DeepEqual(got, tt.want) || ok != tt.wantOK {
+			t.Fatalf("reinstallCommand(%s, %q) = %q, %v; want %q, %v", tt.kind, tt.cmd, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRunTaskRechecksVersionsAfterReleasingLocks(t *testing.T) {
+	prev := versionSettleDelay
+	versionSettleDelay = 300 * time.Millisecond
+	t.Cleanup(func() { versionSettleDelay = prev })
+
+	batch := []string{"npm", "install", "-g", "a@latest", "b@latest"}
+	unknown := fakeReply{out: "", code: 1}
+	task := updateTask{kind: agents.KindNpm, cmd: batch}
+	replies := map[string][]fakeReply{cmdString(batch): {{out: "npm ERR! code E404", code: 1}}}
+	for _, name := range []string{"a", "b"} {
+		single := []string{"npm", "install", "-g", name + "@latest"}
+		task.agents = append(task.agents, agentWork{index: len(task.agents), agent: agents.Agent{Name: name, VersionCmd: []string{name, "--version"}}, method: agents.KindNpm, updateCmd: batch, updateCmdSingle: single})
+		replies[cmdString(single)] = []fakeReply{{out: "changed 1 package"}}
+		// Before, unknown right after the update, then the re-read.
+		replies[name+" --version"] = []fakeReply{{out: "1.0.0"}, unknown, {out: "1.1.0"}}
+	}
+	runner := &fakeRunner{replies: replies}
+	env := &envState{runner: runner, binPathCache: map[string]string{}}
+	locker := newManagerLocker()
+	results := make([]result, 2)
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		runTask(context.Background(), task, env, options{}, locker, nil, results)
+		close(done)
+	}()
+
+	// The npm lock frees up while the task waits out the settle delay, not after it.
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	<-time.After(20 * time.Millisecond)
+	unlock, ok := locker.lockContext(ctx, agents.KindNpm)
+	if !ok {
+		t.Fatalf("npm lock still held during the version re-check")
+	}
+	unlock()
+	<-done
+	// One shared delay for both fallback members.
+	if elapsed := time.Since(start); elapsed >= 2*versionSettleDelay {
+		t.Fatalf("runTask took %s, want a single %s settle delay", elapsed, versionSettleDelay)
+	}
+	for _, res := range results {
+		if res.Status != statusUpdated || res.After != "1.1.0" {
+			t.Fatalf("%s = %s %q, want updated 1.1.0", res.Agent.Name, res.Status, res.After)
+		}
+	}
+}
+
+func TestRunTaskRollbackBrokenUpdate(t *testing.T) {
+	prev := versionSettleDelay
+	versionSettleDelay = 0
+	t.Cleanup(func() { versionSettleDelay = prev })
+
+	update := []string{"npm", "install", "-g", "a-cli@latest"}
+	rollback := "npm install -g a-cli@1.0.0"
+	ok, crash := fakeReply{out: "v1.0.0"}, fakeReply{out: "Segmentation fault", code: 139}
+	tests := []struct {
+		name       string
+		versions   []fakeReply
+		rollback   fakeReply
+		wantReason reasonCode
+		wantAfter  string
+	}{
+		{name: "rolled back", versions: []fakeReply{ok, ok, crash, crash, crash, ok}, wantReason: codeRolledBack, wantAfter: "v1.0.0"},
+		{name: "rollback fails", versions: []fakeReply{ok, ok, crash}, rollback: fakeReply{out: "npm ERR! 404", code: 1}, wantReason: codeBroken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			work := agentWork{agent: agents.Agent{Name: "a", VersionCmd: []string{"a", "--version"}}, method: agents.KindNpm, nodePackageName: "a-cli", updateCmd: update, updateCmdSingle: update}
+			runner := &fakeRunner{replies: map[string][]fakeReply{
+				"a --version":     tt.versions,
+				cmdString(update): {{out: "changed 1 package"}},
+				rollback:          {tt.rollback},
+			}}
+			env := &envState{runner: runner, binPathCache: map[string]string{}}
+			results := make([]result, 1)
+			runTask(context.Background(), updateTask{kind: agents.KindNpm, cmd: update, agents: []agentWork{work}}, env, options{Verify: true, Rollback: true}, newManagerLocker(), nil, results)
+			res := results[0]
+			if res.Status != statusFailed || res.ReasonCode != tt.wantReason {
+				t.Fatalf("result = %s (%s), want failed (%s): %s", res.Status, res.ReasonCode, tt.wantReason, res.Explain)
+			}
+			if !strings.Contains(res.Log, "(uca) rolling back: "+rollback) {
+				t.Fatalf("log missing rollback command:\n%s", res.Log)
+			}
+			if tt.wantAfter != "" && res.After != tt.wantAfter {
+				t.Fatalf("After = %q, want %q", res.After, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestRunTaskVerifyFlagsBrokenUpdate(t *testing.T) {
+	prev := versionSettleDelay
+	versionSettleDelay = 0
+	t.Cleanup(func() { versionSettleDelay = prev })
+
+	update := []string{"a", "update"}
+	tests := []struct {
+		name     string
+		versions []fakeReply
+		want     string
+	}{
+		{name: "regression", versions: []fakeReply{{out: "1.0.0"}, {out: "1.0.0"}, {out: "Segmentation fault", code: 139}}, want: statusFailed},
+		{name: "still launches", versions: []fakeReply{{out: "1.0.0"}, {out: "1.0.0"}, {out: "1.1.0"}}, want: statusUpdated},
+		{name: "broken before", versions: []fakeReply{{out: "crash", code: 1}}, want: statusUpdated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			work := agentWork{agent: agents.Agent{Name: "a", VersionCmd: []string{"a", "--version"}}, method: agents.KindNative, updateCmd: update, updateCmdSingle: update}
+			runner := &fakeRunner{replies: map[string][]fakeReply{
+				"a --version":     tt.versions,
+				cmdString(update): {{out: "updated"}},
+			}}
+			env := &envState{runner: runner, binPathCache: map[string]string{}}
+			results := make([]result, 1)
+			runTask(context.Background(), updateTask{kind: agents.KindNative, cmd: update, agents: []agentWork{work}}, env, options{Verify: true}, newManagerLocker(), nil, results)
+			if results[0].Status != tt.want {
+				t.Fatalf("status = %q (%s), want %q", results[0].Status, results[0].Explain, tt.want)
+			}
+			if tt.want == statusFailed && (results[0].ReasonCode != codeBroken || !strings.Contains(results[0].Explain, "exited 139: Segmentation fault")) {
+				t.Fatalf("broken result = %q (%s)", results[0].ReasonCode, results[0].Explain)
+			}
+		})
+	}
+}
+
+func TestRunTaskReportsReinstalled(t *testing.T) {
+	install := []string{"npm", "install", "-g", "pkg@latest", "--force"}
+	work := agentWork{
+		agent:           agents.Agent{Name: "a", VersionCmd: []string{"a", "--version"}},
+		method:          agents.KindNpm,
+		updateCmd:       install,
+		updateCmdSingle: install,
+		reinstall:       true,
+	}
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		"a --version":      {{out: "1.1.0"}},
+		cmdString(install): {{out: "changed 1 package"}},
+	}}
+	env := &envState{runner: runner, binPathCache: map[string]string{}}
+	results := make([]result, 1)
+	runTask(context.Background(), updateTask{kind: agents.KindNpm, cmd: install, agents: []agentWork{work}}, env, options{}, newManagerLocker(), nil, results)
+	res := results[0]
+	if res.Status != statusUpdated || res.ReasonCode != codeReinstalled {
+		t.Fatalf("result = %s (%s), want updated (reinstalled)", res.Status, res.ReasonCode)
+	}
+	if got := formatResult(res, options{}); !strings.HasPrefix(got, "a: reinstalled 1.1.0 (") {
+		t.Fatalf("formatResult() = %q", got)
+	}
+	if got := formatSummary(results, nil, time.Second, false); !strings.HasPrefix(got, "reinstalled: a\n") {
+		t.Fatalf("formatSummary() = %q", got)
+	}
+}
+
+func TestRunTaskNativeFallsThrough(t *testing.T) {
+	update := []string{"claude", "update"}
+	npmInstall := []string{"npm", "install", "-g", "@anthropic-ai/claude-code@latest"}
+	work := agentWork{
+		agent:           agents.Agent{Name: "claude", Binary: "claude", VersionCmd: []string{"claude", "--version"}},
+		method:          agents.KindNative,
+		updateCmd:       update,
+		updateCmdSingle: update,
+		fallbackCmd:     npmInstall,
+		fallbackMethod:  agents.KindNpm,
+	}
+	tests := []struct {
+		name       string
+		replies    map[string][]fakeReply
+		wantStatus string
+		wantMethod string
+		wantNpm    bool
+	}{
+		{
+			name: "refuses_self_update",
+			replies: map[string][]fakeReply{
+				"claude --version":    {{out: "1.0.0"}, {out: "1.0.0"}, {out: "1.1.0"}},
+				"claude update":       {{out: "Claude Code was installed with npm; update it using your package manager", code: 1}},
+				cmdString(npmInstall): {{out: "changed 1 package"}},
+			},
+			wantStatus: statusUpdated,
+			wantMethod: agents.KindNpm,
+			wantNpm:    true,
+		},
+		{
+			name: "already_latest",
+			replies: map[string][]fakeReply{
+				"claude --version": {{out: "1.1.0"}},
+				"claude update":    {{out: "Claude Code is up to date (1.1.0)"}},
+			},
+			wantStatus: statusUnchanged,
+			wantMethod: agents.KindNative,
+		},
+		{
+			name: "native_update_works",
+			replies: map[string][]fakeReply{
+				"claude --version": {{out: "1.0.0"}, {out: "1.1.0"}},
+				"claude update":    {{out: "Successfully updated from 1.0.0 to 1.1.0"}},
+			},
+			wantStatus: statusUpdated,
+			wantMethod: agents.KindNative,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{replies: tt.replies}
+			env := &envState{runner: runner, binPathCache: map[string]string{}}
+			results := make([]result, 1)
+			runTask(context.Background(), updateTask{kind: agents.KindNative, cmd: update, agents: []agentWork{work}}, env, options{}, newManagerLocker(), nil, results)
+			res := results[0]
+			if res.Status != tt.wantStatus || res.Method != tt.wantMethod {
+				t.Fatalf("result = %s via %s, want %s via %s\nlog: %s", res.Status, res.Method, tt.wantStatus, tt.wantMethod, res.Log)
+			}
+			ranNpm := false
+			for _, call := range runner.calls {
+				if call == cmdString(npmInstall) {
+					ranNpm = true
+				}
+			}
+			if ranNpm != tt.wantNpm {
+				t.Fatalf("npm ran = %v, want %v (calls %v)", ranNpm, tt.wantNpm, runner.calls)
+			}
+		})
+	}
+}
+
+func TestRunTaskAuthPrecheck(t *testing.T) {
+	update := []string{"mytool", "update"}
+	work := agentWork{
+		agent:           agents.Agent{Name: "mytool", VersionCmd: []string{"mytool", "--version"}, AuthCheckCmd: []string{"mytool", "whoami"}},
+		method:          agents.KindNative,
+		updateCmd:       update,
+		updateCmdSingle: update,
+	}
+	tests := []struct {
+		name       string
+		check      fakeReply
+		wantStatus string
+		wantReason reasonCode
+	}{
+		{name: "logged_in", check: fakeReply{out: "me@example.com"}, wantStatus: statusUpdated},
+		{name: "not_logged_in", check: fakeReply{out: "Error: Not logged in. Run mytool login.", code: 1}, wantStatus: statusSkipped, wantReason: codeAuth},
+		{name: "quota", check: fakeReply{out: "TerminalQuotaError: quota will reset tomorrow", code: 1}, wantStatus: statusSkipped, wantReason: codeQuota},
+		{name: "other_failure_still_updates", check: fakeReply{out: "segfault", code: 139}, wantStatus: statusUpdated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{replies: map[string][]fakeReply{
+				"mytool whoami":    {tt.check},
+				"mytool --version": {{out: "1.0.0"}, {out: "1.1.0"}},
+				"mytool update":    {{out: "updated"}},
+			}}
+			env := &envState{runner: runner, binPathCache: map[string]string{}}
+			results := make([]result, 1)
+			runTask(context.Background(), updateTask{kind: agents.KindNative, cmd: update, agents: []agentWork{work}}, env, options{}, newManagerLocker(), nil, results)
+			if results[0].Status != tt.wantStatus || results[0].ReasonCode != tt.wantReason {
+				t.Fatalf("result = %s (%s), want %s (%s); explain %q", results[0].Status, results[0].ReasonCode, tt.wantStatus, tt.wantReason, results[0].Explain)
+			}
+			ranUpdate := false
+			for _, call := range runner.calls {
+				if call == "mytool update" {
+					ranUpdate = true
+				}
+			}
+			if ranUpdate != (tt.wantStatus == statusUpdated) {
+				t.Fatalf("update ran = %v (calls %v)", ranUpdate, runner.calls)
+			}
+		})
+	}
+}
+
+func TestTaskConflictGroups(t *testing.T) {
+	task := updateTask{agents: []agentWork{
+		{agent: agents.Agent{Name: "a", ConflictGroups: []string{"zeta", "shared"}}},
+		{agent: agents.Agent{Name: "b", ConflictGroups: []string{"shared"}}},
+		{agent: agents.Agent{Name: "c"}},
+	}}
+	if got, want := taskConflictGroups(task), []string{"shared", "zeta"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("taskConflictGroups() = %v, want %v", got, want)
+	}
+}
+
+// overlapRunner records the peak number of update commands running at once.
+type overlapRunner struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (r *overlapRunner) Run(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error) {
+	if len(args) < 2 || args[1] != "update" {
+		return "1.0.0", 0, 0, nil
+	}
+	r.mu.Lock()
+	r.running++
+	if r.running > r.peak {
+		r.peak = r.running
+	}
+	r.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	r.mu.Lock()
+	r.running--
+	r.mu.Unlock()
+	return "", 0, 0, nil
+}
+
+func (r *overlapRunner) Output(ctx context.Context, args []string, timeout time.Duration) (string, int, time.Duration, error) {
+	return r.Run(ctx, args, timeout)
+}
+
+func TestNetworkLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		kinds    []string
+		wantPeak int
+	}{
+		{name: "caps_network_tasks", limit: 1, kinds: []string{agents.KindNpm, agents.KindBrew, agents.KindPip}, wantPeak: 1},
+		{name: "native_runs_freely", limit: 1, kinds: []string{agents.KindNpm, agents.KindNative, agents.KindNative}, wantPeak: 3},
+		{name: "disabled", limit: 0, kinds: []string{agents.KindNpm, agents.KindPnpm}, wantPeak: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newNetworkLimiter(tt.limit)
+			runner := &overlapRunner{}
+			var wg sync.WaitGroup
+			for _, kind := range tt.kinds {
+				wg.Add(1)
+				go func(kind string) {
+					defer wg.Done()
+					release := limiter.acquire(kind)
+					defer release()
+					_, _, _, _ = runner.Run(context.Background(), []string{"tool", "update"}, 0)
+				}(kind)
+			}
+			wg.Wait()
+			if runner.peak != tt.wantPeak {
+				t.Fatalf("peak concurrency = %d, want %d", runner.peak, tt.wantPeak)
+			}
+		})
+	}
+}
+
+func TestRunTaskSerializesConflictGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		groups   []string
+		wantPeak int
+	}{
+		{name: "shared_group", groups: []string{"shared-bin"}, wantPeak: 1},
+		{name: "no_group", groups: nil, wantPeak: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &overlapRunner{}
+			env := &envState{runner: runner, binPathCache: map[string]string{}}
+			locker := newManagerLocker()
+			results := make([]result, 2)
+			var wg sync.WaitGroup
+			for i, name := range []string{"one", "two"} {
+				cmd := []string{name, "update"}
+				work := agentWork{
+					agent:           agents.Agent{Name: name, VersionCmd: []string{name, "--version"}, ConflictGroups: tt.groups},
+					index:           i,
+					method:          agents.KindNative,
+					updateCmd:       cmd,
+					updateCmdSingle: cmd,
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					runTask(context.Background(), updateTask{kind: agents.KindNative, cmd: cmd, agents: []agentWork{work}}, env, options{}, locker, nil, results)
+				}()
+			}
+			wg.Wait()
+			if runner.peak != tt.wantPeak {
+				t.Fatalf("peak concurrent updates = %d, want %d", runner.peak, tt.wantPeak)
+			}
+		})
+	}
+}
+
+func TestRestoreTerminalOnPanic(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	t.Cleanup(func() { os.Stdout = stdout })
+
+	cursorHidden.Store(true)
+	func() {
+		defer func() {
+			if got := recover(); got != "boom" {
+				t.Errorf("recover() = %v, want re-panic with boom", got)
+			}
+		}()
+		defer restoreTerminalOnPanic()
+		panic("boom")
+	}()
+	w.Close()
+
+	out, _ := io.ReadAll(r)
+	if string(out) != "\x1b[0m\r\n\x1b[?25h" {
+		t.Fatalf("terminal output = %q, want reset + show cursor", out)
+	}
+	if cursorHidden.Load() {
+		t.Fatalf("cursorHidden still set after restore")
+	}
+}
+
+func TestFormatCatalogLine(t *testing.T) {
+	byName := map[string]agents.Agent{}
+	for _, agent := range agents.Default() {
+		byName[agent.Name] = agent
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "codex", want: "codex: binary=codex strategies=npm,pnpm,yarn,bun aliases=codex-cli,openai-codex"},
+		{name: "roocode", want: "roocode: extension=RooVeterinaryInc.roo-cline strategies=vscode aliases=roo,roo-code,roo-cline"},
+		{name: "cline", want: "cline: binary=cline extension=saoudrizwan.claude-dev strategies=npm,pnpm,yarn,bun,vscode aliases=claude-dev"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatCatalogLine(byName[tt.name]); got != tt.want {
+				t.Fatalf("formatCatalogLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogStreamPrintsBatchLogOnce(t *testing.T) {
+	opts := options{Verbose: true}
+	batch := "npm install -g @openai/codex @google/gemini-cli"
+	member := func(name, phase string) updateEvent {
+		return updateEvent{Phase: phase, Result: result{
+			Agent:     agents.Agent{Name: name},
+			Status:    statusUpdated,
+			UpdateCmd: batch,
+			Batched:   true,
+			Log:       "added 2 packages",
+		}}
+	}
+	stream := newLogStream()
+	for _, name := range []string{"codex", "gemini"} {
+		if got := stream.add(member(name, phaseStart), opts); got != "" {
+			t.Fatalf("start printed %q", got)
+		}
+	}
+	if got := stream.add(member("codex", phaseFinish), opts); got != "" {
+		t.Fatalf("first member printed %q before the batch finished", got)
+	}
+	if got, want := stream.add(member("gemini", phaseFinish), opts), "==> codex, gemini\nadded 2 packages\n"; got != want {
+		t.Fatalf("batch log = %q, want %q", got, want)
+	}
+
+	single := updateEvent{Phase: phaseFinish, Result: result{
+		Agent:     agents.Agent{Name: "claude"},
+		Status:    statusUpdated,
+		UpdateCmd: "claude update",
+		Log:       "updated",
+	}}
+	if got, want := stream.add(single, opts), "==> claude\nupdated\n"; got != want {
+		t.Fatalf("single log = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// profiler collects per-task timings for --profile. A nil profiler records nothing.
+type profiler struct {
+	mu    sync.Mutex
+	start time.Time
+	tasks []taskProfile
+}
+
+// taskProfile is one update task in the --profile document. Offsets are relative to when the profiler
+// was created, as the update workers start; running time excludes the lock and network waits.
+type taskProfile struct {
+	Worker        int      `json:"worker"`
+	Kind          string   `json:"kind"`
+	Agents        []string `json:"agents"`
+	Command       string   `json:"command"`
+	StartMs       int64    `json:"startMs"`
+	EndMs         int64    `json:"endMs"`
+	LockWaitMs    int64    `json:"lockWaitMs"`
+	NetworkWaitMs int64    `json:"networkWaitMs,omitempty"`
+	RunMs         int64    `json:"runMs"`
+}
+
+// profileReport is the --profile document. SummedRunMs greater than WallMs means tasks overlapped;
+// a large SummedLockWaitMs means workers mostly queued behind the per-manager locks.
+type profileReport struct {
+	Workers             int           `json:"workers"`
+	WallMs              int64         `json:"wallMs"`
+	SummedRunMs         int64         `json:"summedRunMs"`
+	SummedLockWaitMs    int64         `json:"summedLockWaitMs"`
+	SummedNetworkWaitMs int64         `json:"summedNetworkWaitMs"`
+	Tasks               []taskProfile `json:"tasks"`
+}
+
+func newProfiler() *profiler {
+	return &profiler{start: time.Now()}
+}
+
+func (p *profiler) record(worker int, task updateTask, start, end time.Time, networkWait, lockWait time.Duration) {
+	if p == nil {
+		return
+	}
+	names := make([]string, 0, len(task.agents))
+	for _, work := range task.agents {
+		names = append(names, work.agent.Name)
+	}
+	run := end.Sub(start) - networkWait - lockWait
+	if run < 0 {
+		run = 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tasks = append(p.tasks, taskProfile{
+		Worker:        worker,
+		Kind:          task.kind,
+		Agents:        names,
+		Command:       cmdString(task.cmd),
+		StartMs:       start.Sub(p.start).Milliseconds(),
+		EndMs:         end.Sub(p.start).Milliseconds(),
+		LockWaitMs:    lockWait.Milliseconds(),
+		NetworkWaitMs: networkWait.Milliseconds(),
+		RunMs:         run.Milliseconds(),
+	})
+}
+
+func (p *profiler) report(workers int) profileReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	report := profileReport{Workers: workers, Tasks: append([]taskProfile{}, p.tasks...)}
+	for _, task := range report.Tasks {
+		if task.EndMs > report.WallMs {
+			report.WallMs = task.EndMs
+		}
+		report.SummedRunMs += task.RunMs
+		report.SummedLockWaitMs += task.LockWaitMs
+		report.SummedNetworkWaitMs += task.NetworkWaitMs
+	}
+	return report
+}
+
+func (p *profiler) write(path string, workers int) error {
+	if p == nil || path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.report(workers), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write --profile: %w", err)
+	}
+	return nil
+}
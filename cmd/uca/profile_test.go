@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chhoumann/uca/internal/agents"
+)
+
+func TestProfilerReport(t *testing.T) {
+	p := newProfiler()
+	p.start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	npm := updateTask{kind: agents.KindNpm, cmd: []string{"npm", "install", "-g", "a@latest", "b@latest"}, agents: []agentWork{
+		{agent: agents.Agent{Name: "a"}},
+		{agent: agents.Agent{Name: "b"}},
+	}}
+	native := updateTask{kind: agents.KindNative, cmd: []string{"amp", "update"}, agents: []agentWork{{agent: agents.Agent{Name: "amp"}}}}
+	p.record(1, npm, p.start, p.start.Add(10*time.Second), time.Second, 2*time.Second)
+	p.record(2, native, p.start.Add(time.Second), p.start.Add(4*time.Second), 0, 0)
+
+	path := filepath.Join(t.TempDir(), "profile.json")
+	if err := p.write(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report profileReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("profile is not JSON: %v\n%s", err, data)
+	}
+	if report.Workers != 2 || report.WallMs != 10000 || report.SummedRunMs != 10000 || report.SummedLockWaitMs != 2000 || report.SummedNetworkWaitMs != 1000 {
+		t.Fatalf("report totals = %+v", report)
+	}
+	if got := report.Tasks[0]; got.RunMs != 7000 || len(got.Agents) != 2 || got.Command != "npm install -g a@latest b@latest" {
+		t.Fatalf("npm task = %+v", got)
+	}
+	if got := report.Tasks[1]; got.Worker != 2 || got.StartMs != 1000 || got.EndMs != 4000 {
+		t.Fatalf("native task = %+v", got)
+	}
+
+	var disabled *profiler
+	disabled.record(1, npm, time.Now(), time.Now(), 0, 0)
+	if err := disabled.write(path, 1); err != nil {
+		t.Fatalf("nil profiler write = %v", err)
+	}
+}
+
+func TestRunTaskReportsLockWait(t *testing.T) {
+	install := []string{"npm", "install", "-g", "pkg@latest"}
+	work := agentWork{agent: agents.Agent{Name: "a", VersionCmd: []string{"a", "--version"}}, method: agents.KindNpm, updateCmd: install, updateCmdSingle: install}
+	runner := &fakeRunner{replies: map[string][]fakeReply{
+		"a --version":      {{out: "1.0.0"}},
+		cmdString(install): {{out: "changed 1 package"}},
+	}}
+	env := &envState{runner: runner, binPathCache: map[string]string{}}
+	locker := newManagerLocker()
+	unlock, _ := locker.lockContext(context.Background(), agents.KindNpm)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		unlock()
+	}()
+	wait := runTask(context.Background(), updateTask{kind: agents.KindNpm, cmd: install, agents: []agentWork{work}}, env, options{}, locker, nil, make([]result, 1))
+	if wait < 40*time.Millisecond {
+		t.Fatalf("lock wait = %s, want about 50ms", wait)
+	}
+}
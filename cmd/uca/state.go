@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// agentRecord is what uca remembers about an agent between runs.
+type agentRecord struct {
+	// Version is the version reported after the last successful update or check.
+	Version string `json:"version,omitempty"`
+	// UpdatedAt is when an update last changed (or installed) the agent.
+	UpdatedAt time.Time `json:"updatedAt"`
+	// CheckedAt is when a run last completed successfully for the agent, changed or not.
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// runState is the JSON state file, keyed by canonical agent name.
+type runState struct {
+	Agents map[string]agentRecord `json:"agents"`
+	// Failed lists agents whose last attempted update failed, in result order (--retry-failed).
+	Failed []string `json:"failed,omitempty"`
+}
+
+// resolveStatePath returns the state file path: --state-file, else $XDG_STATE_HOME/uca/state.json, else
+// uca/state.json under the user config dir. "" means there is nowhere to keep state.
+func resolveStatePath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "uca", "state.json")
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "uca", "state.json")
+}
+
+// loadState reads the state file. A missing file is an empty state, not an error. A file that doesn't parse
+// is moved aside to path+".bak", so the next save starts fresh without destroying it.
+func loadState(path string) (runState, error) {
+	state := runState{Agents: map[string]agentRecord{}}
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("read state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		empty := runState{Agents: map[string]agentRecord{}}
+		backup := path + ".bak"
+		if renameErr := os.Rename(path, backup); renameErr != nil {
+			return empty, fmt.Errorf("parse state %s: %w (could not move it aside: %v)", path, err, renameErr)
+		}
+		return empty, fmt.Errorf("parse state %s: %w (moved to %s)", path, err, backup)
+	}
+	if state.Agents == nil {
+		state.Agents = map[string]agentRecord{}
+	}
+	return state, nil
+}
+
+// saveState writes the state file atomically: a temp file in the same directory renamed over the target,
+// so an interrupted run never leaves a truncated file behind.
+func saveState(path string, state runState) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".state-*.json")
+	if err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
+
+// recordResults stores the outcome of successful updates in state and refreshes the failed set: agents
+// this run attempted leave it, and the ones that failed (or hit --lock-timeout) join it. A run where
+// everything succeeded clears it.
+func recordResults(state *runState, results []result, now time.Time) {
+	state.Failed = mergeFailed(state.Failed, results)
+	for _, res := range results {
+		if res.Status != statusUpdated && res.Status != statusUnchanged {
+			continue
+		}
+		rec := state.Agents[res.Agent.Name]
+		if after := safeVersion(res.After); after != "unknown" {
+			rec.Version = after
+		}
+		rec.CheckedAt = now
+		// A batch-partial member did not move, so it does not count as updated.
+		if res.Status == statusUpdated && res.ReasonCode != codeBatchPartial {
+			rec.UpdatedAt = now
+		}
+		state.Agents[res.Agent.Name] = rec
+	}
+}
+
+func mergeFailed(previous []string, results []result) []string {
+	attempted := map[string]bool{}
+	for _, res := range results {
+		// Agents canceled before they started weren't attempted; keep them for the retry.
+		if res.Status == statusSkipped && res.ReasonCode == codeCanceled {
+			continue
+		}
+		attempted[res.Agent.Name] = true
+	}
+	failed := []string{}
+	for _, name := range previous {
+		if !attempted[name] {
+			failed = append(failed, name)
+		}
+	}
+	for _, res := range results {
+		// A lock-timeout skip was deferred, not done: --retry-failed picks it up next time.
+		if res.Status == statusFailed || (res.Status == statusSkipped && res.ReasonCode == codeLockTimeout) {
+			failed = append(failed, res.Agent.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return failed
+}
+
+// isStale reports whether an agent has not been updated within since. Agents with no record are stale.
+func isStale(rec agentRecord, ok bool, now time.Time, since time.Duration) bool {
+	if !ok || rec.UpdatedAt.IsZero() {
+		return true
+	}
+	return now.Sub(rec.UpdatedAt) > since
+}
+
+// withLastUpdated appends "last updated 3d ago" to an --explain detail when uca has a record for the agent.
+func withLastUpdated(detail string, records map[string]agentRecord, name string, now time.Time) string {
+	rec, ok := records[name]
+	if !ok || rec.UpdatedAt.IsZero() {
+		return detail
+	}
+	return appendNote(detail, "last updated "+fmtAge(rec.UpdatedAt, now))
+}
+
+// formatStale lists installed agents that uca has not updated within since, with their age, for --check.
+func formatStale(results []result, state runState, now time.Time, since time.Duration) string {
+	items := []string{}
+	for _, res := range results {
+		// Under --check, installed agents with a known update command show up as dry-run updates.
+		if res.Status != statusUpdated {
+			continue
+		}
+		rec, ok := state.Agents[res.Agent.Name]
+		if !isStale(rec, ok, now, since) {
+			continue
+		}
+		age := "never"
+		if ok && !rec.UpdatedAt.IsZero() {
+			age = fmtAge(rec.UpdatedAt, now)
+		}
+		items = append(items, fmt.Sprintf("%s (%s)", res.Agent.Name, age))
+	}
+	var b strings.Builder
+	writeSummaryLine(&b, fmt.Sprintf("not updated in %s", fmtElapsed(since)), items)
+	return b.String()
+}
+
+// fmtAge renders how long ago t was in the largest whole unit, e.g. "3d ago".
+func fmtAge(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dd ago", int(d/(24*time.Hour)))
+	}
+}
+
+// versionChange is an agent whose version differs from the one recorded by the previous run.
+type versionChange struct {
+	name      string
+	from, to  string
+	checkedAt time.Time
+	downgrade bool
+}
+
+// versionChanges compares each agent's current version with state's record from earlier runs, so a
+// change made between runs (by uca or by hand) shows up as well as this run's own updates. Under
+// --dry-run the installed version counts, not the projected one.
+func versionChanges(results []result, state runState, dryRun bool) []versionChange {
+	changes := []versionChange{}
+	for _, res := range results {
+		rec, ok := state.Agents[res.Agent.Name]
+		if !ok || rec.Version == "" {
+			continue
+		}
+		current := res.After
+		if dryRun || safeVersion(current) == "unknown" {
+			current = res.Before
+		}
+		current = safeVersion(current)
+		if current == "unknown" || sameRecordedVersion(rec.Version, current) {
+			continue
+		}
+		change := versionChange{name: res.Agent.Name, from: versionLabel(rec.Version), to: versionLabel(current), checkedAt: rec.CheckedAt}
+		if cmp, ok := compareVersions(rec.Version, current); ok && cmp > 0 {
+			change.downgrade = true
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// sameRecordedVersion compares version tokens when both sides have one, so "2.1.20 (Claude Code)" and
+// "v2.1.20" match; otherwise the trimmed strings.
+func sameRecordedVersion(a, b string) bool {
+	if _, ok := extractVersionToken(a); ok {
+		if _, ok := extractVersionToken(b); ok {
+			return sameVersionToken(a, b)
+		}
+	}
+	return strings.TrimSpace(a) == strings.TrimSpace(b)
+}
+
+func versionLabel(s string) string {
+	if token, ok := extractVersionToken(s); ok {
+		return token
+	}
+	return strings.TrimSpace(s)
+}
+
+// formatSinceLastRun renders the "since last run" section that follows the summary, one agent per line.
+func formatSinceLastRun(results []result, state runState, dryRun bool, now time.Time) string {
+	changes := versionChanges(results, state, dryRun)
+	if len(changes) == 0 {
+		return ""
+	}
+	width := 0
+	for _, change := range changes {
+		width = max(width, len(change.name))
+	}
+	var b strings.Builder
+	b.WriteString("since last run:\n")
+	for _, change := range changes {
+		notes := []string{}
+		if change.downgrade {
+			notes = append(notes, "downgrade")
+		}
+		if !change.checkedAt.IsZero() {
+			notes = append(notes, "last run "+fmtAge(change.checkedAt, now))
+		}
+		line := fmt.Sprintf("  %-*s  %s -> %s", width, change.name, change.from, change.to)
+		if len(notes) > 0 {
+			line += " (" + strings.Join(notes, ", ") + ")"
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chhoumann/uca/internal/agents"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nested", "state.json")
+
+	state, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState(missing) error = %v", err)
+	}
+	if len(state.Agents) != 0 {
+		t.Fatalf("loadState(missing) = %v, want empty", state.Agents)
+	}
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	state.Agents["codex"] = agentRecord{Version: "0.40.0", UpdatedAt: now, CheckedAt: now}
+	if err := saveState(path, state); err != nil {
+		t.Fatalf("saveState error = %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("state dir has %d entries, want only state.json (temp file left behind?)", len(entries))
+	}
+
+	got, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState error = %v", err)
+	}
+	rec := got.Agents["codex"]
+	if rec.Version != "0.40.0" || !rec.UpdatedAt.Equal(now) {
+		t.Fatalf("loadState record = %+v", rec)
+	}
+}
+
+func TestLoadStateCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadState(path)
+	if err == nil {
+		t.Fatal("loadState(corrupt) error = nil, want error")
+	}
+	if state.Agents == nil {
+		t.Fatal("loadState(corrupt) should still return a usable state")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("corrupt state file still in place (stat err = %v)", err)
+	}
+	if data, err := os.ReadFile(path + ".bak"); err != nil || string(data) != "{not json" {
+		t.Fatalf("state.json.bak = %q, %v; want the corrupt file", data, err)
+	}
+}
+
+func TestRecordResults(t *testing.T) {
+	old := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := old.Add(72 * time.Hour)
+	state := runState{Agents: map[string]agentRecord{
+		"gemini": {Version: "1.0.0", UpdatedAt: old, CheckedAt: old},
+		"pi":     {Version: "0.1.0", UpdatedAt: old, CheckedAt: old},
+	}}
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, After: "0.40.0"},
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusUnchanged, After: "1.0.0"},
+		{Agent: agents.Agent{Name: "pi"}, Status: statusUpdated, ReasonCode: codeBatchPartial, After: "0.1.0"},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusFailed},
+		{Agent: agents.Agent{Name: "cursor"}, Status: statusSkipped, ReasonCode: codeMissing},
+	}
+	recordResults(&state, results, now)
+
+	if rec := state.Agents["codex"]; !rec.UpdatedAt.Equal(now) || rec.Version != "0.40.0" {
+		t.Fatalf("codex = %+v, want updated now", rec)
+	}
+	if rec := state.Agents["gemini"]; !rec.UpdatedAt.Equal(old) || !rec.CheckedAt.Equal(now) {
+		t.Fatalf("gemini = %+v, want checked now but updated earlier", rec)
+	}
+	if rec := state.Agents["pi"]; !rec.UpdatedAt.Equal(old) {
+		t.Fatalf("pi = %+v, batch partial should not count as updated", rec)
+	}
+	for _, name := range []string{"amp", "cursor"} {
+		if _, ok := state.Agents[name]; ok {
+			t.Fatalf("%s should not be recorded", name)
+		}
+	}
+}
+
+func TestRecordResultsTracksFailed(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := runState{Agents: map[string]agentRecord{}, Failed: []string{"gemini", "pi", "cursor"}}
+	recordResults(&state, []result{
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusUpdated, After: "1.1.0"},
+		{Agent: agents.Agent{Name: "pi"}, Status: statusFailed, ReasonCode: codeNetwork},
+		{Agent: agents.Agent{Name: "cursor"}, Status: statusSkipped, ReasonCode: codeCanceled},
+		{Agent: agents.Agent{Name: "codex"}, Status: statusFailed, ReasonCode: codeTimeout},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusSkipped, ReasonCode: codeLockTimeout},
+	}, now)
+	if want := []string{"cursor", "pi", "codex", "amp"}; !reflect.DeepEqual(state.Failed, want) {
+		t.Fatalf("Failed = %q, want %q", state.Failed, want)
+	}
+
+	recordResults(&state, []result{
+		{Agent: agents.Agent{Name: "cursor"}, Status: statusUnchanged},
+		{Agent: agents.Agent{Name: "pi"}, Status: statusUpdated},
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusUpdated},
+	}, now)
+	if state.Failed != nil {
+		t.Fatalf("Failed = %q after a clean run, want nil", state.Failed)
+	}
+}
+
+func TestFmtAge(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{10 * time.Second, "just now"},
+		{12 * time.Minute, "12m ago"},
+		{5 * time.Hour, "5h ago"},
+		{3*24*time.Hour + time.Hour, "3d ago"},
+	}
+	for _, tt := range tests {
+		if got := fmtAge(now.Add(-tt.ago), now); got != tt.want {
+			t.Fatalf("fmtAge(-%s) = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+}
+
+func TestWithLastUpdated(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	records := map[string]agentRecord{"codex": {UpdatedAt: now.Add(-72 * time.Hour)}}
+	if got := withLastUpdated("npm global", records, "codex", now); got != "npm global; last updated 3d ago" {
+		t.Fatalf("withLastUpdated = %q", got)
+	}
+	if got := withLastUpdated("npm global", records, "gemini", now); got != "npm global" {
+		t.Fatalf("withLastUpdated(no record) = %q", got)
+	}
+}
+
+func TestFormatStale(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	state := runState{Agents: map[string]agentRecord{
+		"codex":  {UpdatedAt: now.Add(-10 * 24 * time.Hour)},
+		"gemini": {UpdatedAt: now.Add(-time.Hour)},
+	}}
+	results := []result{
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, ReasonCode: codeDryRun},
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusUpdated, ReasonCode: codeDryRun},
+		{Agent: agents.Agent{Name: "pi"}, Status: statusUpdated, ReasonCode: codeDryRun},
+		{Agent: agents.Agent{Name: "cursor"}, Status: statusSkipped, ReasonCode: codeMissing},
+	}
+	got := formatStale(results, state, now, 7*24*time.Hour)
+	want := "not updated in 168h00m: codex (10d ago) pi (never)\n"
+	if got != want {
+		t.Fatalf("formatStale = %q, want %q", got, want)
+	}
+	if got := formatStale(results[1:2], state, now, 7*24*time.Hour); strings.TrimSpace(got) != "" {
+		t.Fatalf("formatStale(fresh) = %q, want empty", got)
+	}
+}
+
+func TestChangedSinceRequiresCheck(t *testing.T) {
+	opts := options{RefreshInterval: time.Second, DetectTimeout: time.Second, Color: modeAuto, Unicode: modeAuto, ChangedSince: time.Hour}
+	if err := validateOptions(opts); err == nil {
+		t.Fatal("validateOptions(--changed-since without --check) error = nil")
+	}
+	opts.Check = true
+	if err := validateOptions(opts); err != nil {
+		t.Fatalf("validateOptions(--check --changed-since) error = %v", err)
+	}
+}
+
+func TestFormatSinceLastRun(t *testing.T) {
+	now := time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)
+	state := runState{Agents: map[string]agentRecord{
+		"claude":   {Version: "2.1.19 (Claude Code)", CheckedAt: now.Add(-24 * time.Hour)},
+		"codex":    {Version: "codex-cli 0.40.0"},
+		"gemini":   {Version: "0.5.1", CheckedAt: now.Add(-2 * time.Hour)},
+		"opencode": {Version: "v0.3.0"},
+	}}
+	results := []result{
+		{Agent: agents.Agent{Name: "claude"}, Status: statusUpdated, Before: "2.1.19 (Claude Code)", After: "2.1.20 (Claude Code)"},
+		{Agent: agents.Agent{Name: "codex"}, Status: statusUnchanged, Before: "codex-cli 0.40.0", After: "codex-cli 0.40.0"},
+		{Agent: agents.Agent{Name: "gemini"}, Status: statusFailed, Before: "0.5.0", After: "unknown"},
+		{Agent: agents.Agent{Name: "opencode"}, Status: statusUnchanged, Before: "0.3.0", After: "0.3.0"},
+		{Agent: agents.Agent{Name: "amp"}, Status: statusUpdated, Before: "1.0.0", After: "1.1.0"},
+	}
+	want := "since last run:\n" +
+		"  claude  2.1.19 -> 2.1.20 (last run 1d ago)\n" +
+		"  gemini  0.5.1 -> 0.5.0 (downgrade, last run 2h ago)\n"
+	if got := formatSinceLastRun(results, state, false, now); got != want {
+		t.Fatalf("formatSinceLastRun() = %q, want %q", got, want)
+	}
+	// A dry run projects claude's After; only the installed version counts.
+	if got := formatSinceLastRun(results[:1], state, true, now); got != "" {
+		t.Fatalf("formatSinceLastRun(dry run) = %q, want empty", got)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		want   int
+		wantOK bool
+	}{
+		{a: "2.1.19", b: "2.1.20", want: -1, wantOK: true},
+		{a: "v0.10.0", b: "0.9.9", want: 1, wantOK: true},
+		{a: "1.2", b: "1.2.0", want: 0, wantOK: true},
+		{a: "1.2.0-beta.1", b: "1.2.0", want: -1, wantOK: true},
+		{a: "1.0.0-beta.9", b: "1.0.0-beta.10", want: -1, wantOK: true},
+		{a: "1.0.0-beta", b: "1.0.0-beta.1", want: -1, wantOK: true},
+		{a: "1.0.0-1", b: "1.0.0-alpha", want: -1, wantOK: true},
+		{a: "1.0.0-rc.1", b: "1.0.0-beta.11", want: 1, wantOK: true},
+		{a: "codex-cli 0.40.0", b: "0.40.0+build.7", want: 0, wantOK: true},
+		{a: "unknown", b: "1.0.0", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := compareVersions(tt.a, tt.b)
+		if got != tt.want || ok != tt.wantOK {
+			t.Fatalf("compareVersions(%q, %q) = %d, %v; want %d, %v", tt.a, tt.b, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// webhookTimeout bounds delivering the --webhook report, so a dead endpoint can't hang a cron run.
+const webhookTimeout = 15 * time.Second
+
+// webhookReport is the --webhook payload: the --json run report plus the machine and uca version.
+type webhookReport struct {
+	Host    string `json:"host"`
+	Version string `json:"version"`
+	runReport
+}
+
+// headerFlag collects repeatable --webhook-header values. Unlike listFlag it does not split on commas,
+// which header values may contain.
+type headerFlag []string
+
+func (h *headerFlag) String() string {
+	return strings.Join(*h, "; ")
+}
+
+func (h *headerFlag) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// validateWebhookURL accepts absolute http(s) URLs.
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --webhook %q (want an http or https URL)", raw)
+	}
+	return nil
+}
+
+// parseWebhookHeaders parses "Name: value" entries.
+func parseWebhookHeaders(entries []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid --webhook-header %q (want 'Name: value')", entry)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// buildWebhookReport wraps the run report with the hostname and uca version.
+func buildWebhookReport(results []result, unknown []string, elapsed time.Duration, dryRun bool) webhookReport {
+	host, _ := os.Hostname()
+	return webhookReport{Host: host, Version: version, runReport: buildRunReport(results, unknown, elapsed, dryRun)}
+}
+
+// postWebhook POSTs the report as JSON. Any non-2xx response is an error.
+func postWebhook(target string, headers http.Header, report webhookReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	// Not tied to the run's context: an interrupted run is still worth reporting.
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	for name, values := range headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "uca/"+version)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s returned %s", target, resp.Status)
+	}
+	return nil
+}
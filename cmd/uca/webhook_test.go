@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chhoumann/uca/internal/agents"
+)
+
+func TestPostWebhook(t *testing.T) {
+	var gotAuth, gotType string
+	var got webhookReport
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotType = r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("payload is not JSON: %v\n%s", err, body)
+		}
+		if !strings.Contains(string(body), `"agents":[`) {
+			t.Errorf("payload does not inline the run report: %s", body)
+		}
+	}))
+	defer srv.Close()
+
+	headers, err := parseWebhookHeaders([]string{"Authorization: Bearer abc,def"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []result{{Agent: agents.Agent{Name: "codex"}, Status: statusUpdated, Before: "1.0.0", After: "1.1.0"}}
+	if err := postWebhook(srv.URL, headers, buildWebhookReport(results, nil, time.Second, false)); err != nil {
+		t.Fatalf("postWebhook() = %v", err)
+	}
+	if gotAuth != "Bearer abc,def" || gotType != "application/json" {
+		t.Fatalf("headers = %q, %q", gotAuth, gotType)
+	}
+	if got.Version != version || got.Host == "" || len(got.Agents) != 1 || got.Agents[0].After != "1.1.0" {
+		t.Fatalf("payload = %+v", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer failing.Close()
+	if err := postWebhook(failing.URL, nil, buildWebhookReport(results, nil, 0, false)); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("postWebhook() to a 401 endpoint = %v", err)
+	}
+}
+
+func TestValidateWebhookOptions(t *testing.T) {
+	base := options{RefreshInterval: time.Second, DetectTimeout: time.Second, Color: modeAuto, Unicode: modeAuto}
+	tests := []struct {
+		name    string
+		url     string
+		headers headerFlag
+		wantErr string
+	}{
+		{name: "ok", url: "https://example.com/hook", headers: headerFlag{"Authorization: Bearer x"}},
+		{name: "bad scheme", url: "ftp://example.com", wantErr: "invalid --webhook"},
+		{name: "bad header", url: "https://example.com/hook", headers: headerFlag{"no colon"}, wantErr: "invalid --webhook-header"},
+		{name: "header without url", headers: headerFlag{"A: b"}, wantErr: "requires --webhook"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := base
+			opts.Webhook = tt.url
+			opts.WebhookHeaders = tt.headers
+			err := validateOptions(opts)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("validateOptions() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
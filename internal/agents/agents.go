@@ -1,19 +1,42 @@
 package agents
 
 type UpdateStrategy struct {
-	Kind        string
-	Command     []string
-	Package     string
-	ExtensionID string
+	Kind        string   `json:"kind"`
+	Command     []string `json:"command,omitempty"`
+	Package     string   `json:"package,omitempty"`
+	ExtensionID string   `json:"extensionId,omitempty"`
+	// Tag is the npm dist-tag to install for node strategies ("" means latest).
+	Tag string `json:"tag,omitempty"`
+	// DetectCommand is used by KindExec: exit code 0 means the tool is installed via this strategy.
+	DetectCommand []string `json:"detectCmd,omitempty"`
+	// Plugin is the asdf plugin name for KindAsdf; asdf has no package-to-plugin mapping, so it must be explicit.
+	Plugin string `json:"plugin,omitempty"`
 }
 
 // Agent defines how to update and version a CLI tool.
 type Agent struct {
-	Name        string
-	Binary      string
-	VersionCmd  []string
-	ExtensionID string
-	Strategies  []UpdateStrategy
+	Name        string           `json:"name"`
+	Binary      string           `json:"binary,omitempty"`
+	VersionCmd  []string         `json:"versionCmd,omitempty"`
+	ExtensionID string           `json:"extensionId,omitempty"`
+	Strategies  []UpdateStrategy `json:"strategies"`
+	// ManualInstructions tell the user how to update the agent themselves when uca can't tell how it was
+	// installed ("manual install") or its built-in updater fails, e.g. "update from Settings > Updates".
+	ManualInstructions string `json:"manualInstructions,omitempty"`
+	// VersionPrefix is prepended to VersionCmd, e.g. ["mise", "exec", "--"] for a tool whose version
+	// manager shim only works inside a configured directory.
+	VersionPrefix []string `json:"versionPrefix,omitempty"`
+	// Aliases are alternate names accepted by --only/--skip.
+	Aliases []string `json:"aliases,omitempty"`
+	// AuthCheckCmd, when set, runs before a native update; an auth or quota failure skips the update
+	// instead of running the updater into a confusing error.
+	AuthCheckCmd []string `json:"authCheckCmd,omitempty"`
+	// ConflictGroups name mutual-exclusion groups: agents sharing a group never update at the same time,
+	// even through different managers (e.g. two CLIs whose installers write the same shared binary).
+	ConflictGroups []string `json:"conflictGroups,omitempty"`
+	// MethodCmd, when set, asks the tool how it was installed: the first strategy kind named in its output
+	// (e.g. "installed via npm") is tried before the others. A failing command leaves detection unchanged.
+	MethodCmd []string `json:"methodCmd,omitempty"`
 }
 
 const (
@@ -26,6 +49,9 @@ const (
 	KindPip    = "pip"
 	KindUv     = "uv"
 	KindVSCode = "vscode"
+	KindAsdf   = "asdf"
+	// KindExec runs arbitrary detect/update commands. Only available to config-defined agents.
+	KindExec = "exec"
 )
 
 func nodePackageStrategies(pkg string) []UpdateStrategy {
@@ -42,48 +68,61 @@ func Default() []Agent {
 	return []Agent{
 		{
 			Name:       "amp",
+			Aliases:    []string{"ampcode"},
 			Binary:     "amp",
 			VersionCmd: []string{"amp", "--version"},
 			Strategies: []UpdateStrategy{{Kind: KindNative, Command: []string{"amp", "update"}}},
+			// Shown when `amp update` fails or uca can't tell how amp was installed.
+			ManualInstructions: "reinstall with `curl -fsSL https://ampcode.com/install.sh | bash`, or update it with the tool you installed it with",
 		},
 		{
 			Name:       "gemini",
+			Aliases:    []string{"gemini-cli", "google-gemini", "gem"},
 			Binary:     "gemini",
 			VersionCmd: []string{"gemini", "--version"},
 			Strategies: nodePackageStrategies("@google/gemini-cli"),
 		},
 		{
 			Name:       "claude",
+			Aliases:    []string{"claude-code"},
 			Binary:     "claude",
 			VersionCmd: []string{"claude", "--version"},
-			Strategies: []UpdateStrategy{{Kind: KindNative, Command: []string{"claude", "update"}}},
+			// The node strategies are a fallback for npm installs whose `claude update` refuses to self-update.
+			Strategies: append([]UpdateStrategy{{Kind: KindNative, Command: []string{"claude", "update"}}}, nodePackageStrategies("@anthropic-ai/claude-code")...),
 		},
 		{
 			Name:       "codex",
+			Aliases:    []string{"codex-cli", "openai-codex"},
 			Binary:     "codex",
 			VersionCmd: []string{"codex", "--version"},
 			Strategies: nodePackageStrategies("@openai/codex"),
 		},
 		{
 			Name:       "opencode",
+			Aliases:    []string{"opencode-ai"},
 			Binary:     "opencode",
 			VersionCmd: []string{"opencode", "--version"},
 			Strategies: nodePackageStrategies("opencode-ai"),
 		},
 		{
 			Name:       "cursor",
+			Aliases:    []string{"cursor-agent"},
 			Binary:     "cursor-agent",
 			VersionCmd: []string{"cursor-agent", "--version"},
 			Strategies: []UpdateStrategy{{Kind: KindNative, Command: []string{"cursor-agent", "update"}}},
+			// Shown when `cursor-agent update` fails or uca can't tell how it was installed.
+			ManualInstructions: "reinstall with `curl https://cursor.com/install -fsS | bash`",
 		},
 		{
 			Name:       "copilot",
+			Aliases:    []string{"copilot-cli", "github-copilot"},
 			Binary:     "copilot",
 			VersionCmd: []string{"copilot", "--version"},
 			Strategies: append([]UpdateStrategy{{Kind: KindBrew, Package: "copilot-cli"}}, nodePackageStrategies("@github/copilot")...),
 		},
 		{
 			Name:        "cline",
+			Aliases:     []string{"claude-dev"},
 			Binary:      "cline",
 			VersionCmd:  []string{"cline", "--version"},
 			ExtensionID: "saoudrizwan.claude-dev",
@@ -91,6 +130,7 @@ func Default() []Agent {
 		},
 		{
 			Name:        "roocode",
+			Aliases:     []string{"roo", "roo-code", "roo-cline"},
 			ExtensionID: "RooVeterinaryInc.roo-cline",
 			Strategies: []UpdateStrategy{
 				{Kind: KindVSCode, ExtensionID: "RooVeterinaryInc.roo-cline"},
@@ -98,6 +138,7 @@ func Default() []Agent {
 		},
 		{
 			Name:       "aider",
+			Aliases:    []string{"aider-chat"},
 			Binary:     "aider",
 			VersionCmd: []string{"aider", "--version"},
 			Strategies: []UpdateStrategy{
@@ -107,6 +148,7 @@ func Default() []Agent {
 		},
 		{
 			Name:       "pi",
+			Aliases:    []string{"pi-coding-agent"},
 			Binary:     "pi",
 			VersionCmd: []string{"pi", "--version"},
 			Strategies: nodePackageStrategies("@mariozechner/pi-coding-agent"),
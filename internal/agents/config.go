@@ -0,0 +1,110 @@
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Config is the on-disk format for user-defined agents.
+type Config struct {
+	Agents []Agent `json:"agents"`
+}
+
+// LoadConfig reads and validates a JSON config file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range cfg.Agents {
+		cfg.Agents[i].Name = strings.ToLower(strings.TrimSpace(cfg.Agents[i].Name))
+		if err := validateAgent(cfg.Agents[i]); err != nil {
+			return cfg, fmt.Errorf("%s: agent %d: %w", path, i+1, err)
+		}
+	}
+	return cfg, nil
+}
+
+func validateAgent(agent Agent) error {
+	if agent.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if len(agent.Strategies) == 0 {
+		return fmt.Errorf("%s: no strategies", agent.Name)
+	}
+	if len(agent.VersionPrefix) > 0 && len(agent.VersionCmd) == 0 {
+		return fmt.Errorf("%s: versionPrefix needs versionCmd", agent.Name)
+	}
+	for _, group := range agent.ConflictGroups {
+		if strings.TrimSpace(group) == "" {
+			return fmt.Errorf("%s: empty conflict group name", agent.Name)
+		}
+	}
+	for _, strat := range agent.Strategies {
+		switch strat.Kind {
+		case KindExec:
+			if len(strat.DetectCommand) == 0 || len(strat.Command) == 0 {
+				return fmt.Errorf("%s: exec strategy needs both detectCmd and command", agent.Name)
+			}
+		case KindNative:
+			if len(strat.Command) == 0 {
+				return fmt.Errorf("%s: native strategy needs command", agent.Name)
+			}
+		case KindNpm, KindPnpm, KindYarn, KindBun, KindBrew, KindPip, KindUv:
+			if strat.Package == "" {
+				return fmt.Errorf("%s: %s strategy needs package", agent.Name, strat.Kind)
+			}
+		case KindVSCode:
+			if strat.ExtensionID == "" {
+				return fmt.Errorf("%s: vscode strategy needs extensionId", agent.Name)
+			}
+		case KindAsdf:
+			if !validPluginName(strat.Plugin) {
+				return fmt.Errorf("%s: asdf strategy needs plugin (letters, digits, '-', '_', '.')", agent.Name)
+			}
+		default:
+			return fmt.Errorf("%s: unknown strategy kind %q", agent.Name, strat.Kind)
+		}
+	}
+	return nil
+}
+
+func validPluginName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Merge overlays config-defined agents on top of the built-ins. An agent with the same name replaces the
+// built-in in place; new agents are appended in config order.
+func Merge(builtin, custom []Agent) []Agent {
+	merged := make([]Agent, len(builtin), len(builtin)+len(custom))
+	copy(merged, builtin)
+	index := make(map[string]int, len(merged))
+	for i, agent := range merged {
+		index[agent.Name] = i
+	}
+	for _, agent := range custom {
+		if i, ok := index[agent.Name]; ok {
+			merged[i] = agent
+			continue
+		}
+		index[agent.Name] = len(merged)
+		merged = append(merged, agent)
+	}
+	return merged
+}
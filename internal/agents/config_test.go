@@ -0,0 +1,110 @@
+package agents
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name: "exec_ok",
+			body: `{"agents":[{"name":"MyTool","binary":"mytool","strategies":[{"kind":"exec","detectCmd":["mytool","--version"],"command":["mytool","self-update"]}]}]}`,
+		},
+		{
+			name:    "exec_missing_detect",
+			body:    `{"agents":[{"name":"mytool","strategies":[{"kind":"exec","command":["mytool","self-update"]}]}]}`,
+			wantErr: "needs both detectCmd and command",
+		},
+		{
+			name: "asdf_ok",
+			body: `{"agents":[{"name":"mytool","binary":"mytool","strategies":[{"kind":"asdf","plugin":"my-tool"}]}]}`,
+		},
+		{
+			name:    "asdf_missing_plugin",
+			body:    `{"agents":[{"name":"mytool","strategies":[{"kind":"asdf"}]}]}`,
+			wantErr: "asdf strategy needs plugin",
+		},
+		{
+			name:    "asdf_bad_plugin",
+			body:    `{"agents":[{"name":"mytool","strategies":[{"kind":"asdf","plugin":"x; rm -rf ~"}]}]}`,
+			wantErr: "asdf strategy needs plugin",
+		},
+		{
+			name: "conflict_groups_ok",
+			body: `{"agents":[{"name":"mytool","conflictGroups":["shared-bin"],"strategies":[{"kind":"npm","package":"mytool"}]}]}`,
+		},
+		{
+			name:    "conflict_group_empty",
+			body:    `{"agents":[{"name":"mytool","conflictGroups":[" "],"strategies":[{"kind":"npm","package":"mytool"}]}]}`,
+			wantErr: "empty conflict group",
+		},
+		{
+			name: "version_prefix_ok",
+			body: `{"agents":[{"name":"mytool","versionCmd":["mytool","--version"],"versionPrefix":["mise","exec","--"],"strategies":[{"kind":"npm","package":"mytool"}]}]}`,
+		},
+		{
+			name:    "version_prefix_without_cmd",
+			body:    `{"agents":[{"name":"mytool","versionPrefix":["mise","exec","--"],"strategies":[{"kind":"npm","package":"mytool"}]}]}`,
+			wantErr: "versionPrefix needs versionCmd",
+		},
+		{
+			name:    "unknown_kind",
+			body:    `{"agents":[{"name":"mytool","strategies":[{"kind":"cargo","package":"mytool"}]}]}`,
+			wantErr: "unknown strategy kind",
+		},
+		{
+			name:    "missing_name",
+			body:    `{"agents":[{"strategies":[{"kind":"npm","package":"x"}]}]}`,
+			wantErr: "missing name",
+		},
+		{
+			name:    "bad_json",
+			body:    `{"agents":`,
+			wantErr: "parse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "uca.json")
+			if err := os.WriteFile(path, []byte(tt.body), 0o644); err != nil {
+				t.Fatalf("write config: %v", err)
+			}
+			cfg, err := LoadConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() err = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() err = %v", err)
+			}
+			if len(cfg.Agents) != 1 || cfg.Agents[0].Name != "mytool" {
+				t.Fatalf("LoadConfig() agents = %#v", cfg.Agents)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	builtin := []Agent{{Name: "a", Binary: "a"}, {Name: "b", Binary: "b"}}
+	custom := []Agent{{Name: "b", Binary: "b2"}, {Name: "c", Binary: "c"}}
+	got := Merge(builtin, custom)
+	names := []string{}
+	for _, agent := range got {
+		names = append(names, agent.Name+"="+agent.Binary)
+	}
+	if strings.Join(names, ",") != "a=a,b=b2,c=c" {
+		t.Fatalf("Merge() = %v", names)
+	}
+	if builtin[1].Binary != "b" {
+		t.Fatalf("Merge() mutated builtin slice")
+	}
+}